@@ -0,0 +1,2377 @@
+package api
+
+import (
+	"github.com/gin-contrib/cors"
+)
+
+import (
+	"context"
+	crand "crypto/rand"
+	"discord-smtp-server/attachment"
+	"discord-smtp-server/authn"
+	"discord-smtp-server/events"
+	"discord-smtp-server/mailer"
+	"discord-smtp-server/notify"
+	"discord-smtp-server/oidc"
+	"discord-smtp-server/password"
+	"discord-smtp-server/rbac"
+	"discord-smtp-server/store"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/gin-contrib/timeout"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt"
+	"html/template"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	time "time"
+)
+
+type mailDto = store.Mail
+
+type mailPartDto = store.Part
+
+type attachmentDto = store.Attachment
+
+type mailListDto struct {
+	Id        string    `json:"id"`
+	Subject   string    `json:"subject"`
+	To        string    `json:"to"`
+	IsRead    int       `json:"isread"`
+	From      string    `json:"from"`
+	Inbox     string    `json:"inbox"`
+	Date      time.Time `json:"date"`
+	CreatedAt time.Time `json:"createdat"`
+}
+
+type userDto = store.User
+
+type projectDto = store.Project
+
+type apiKeyDto struct {
+	Id         string    `json:"id"`
+	Name       string    `json:"name"`
+	Key        string    `json:"key,omitempty"`
+	Prefix     string    `json:"prefix"`
+	ProjectId  string    `json:"projectid"`
+	Inbox      string    `json:"inbox"`
+	Scope      string    `json:"scope"`
+	LastUsedAt time.Time `json:"lastusedat"`
+	CreatedAt  time.Time `json:"createdat"`
+}
+
+type credentialDto struct {
+	Id        string    `json:"id"`
+	Username  string    `json:"username"`
+	Password  string    `json:"password,omitempty"`
+	Inbox     string    `json:"inbox"`
+	CreatedAt time.Time `json:"createdat"`
+}
+
+type userListDto = struct {
+	Id       string          `json:"id"`
+	Username string          `json:"username"`
+	Role     string          `json:"role"`
+	Emails   []string        `json:"emails"`
+	Webhooks []store.Webhook `json:"webhooks"`
+	// Permissions are those of the role, only set for the current user.
+	Permissions []string  `json:"permissions,omitempty"`
+	CreatedAt   time.Time `json:"createdat"`
+}
+
+// roleDto is a built-in or custom role, built-in roles have their name as
+// id.
+type roleDto struct {
+	Id          string    `json:"id"`
+	Name        string    `json:"name"`
+	Permissions []string  `json:"permissions"`
+	Builtin     bool      `json:"builtin"`
+	CreatedAt   time.Time `json:"createdat"`
+}
+
+type supportDto = store.Ticket
+
+type supportMessageDto = store.TicketMessage
+
+// enum status for support
+const (
+	SupportStatusOpen       = "open"
+	SupportStatusInProgress = "inprogress"
+	SupportStatusClosed     = "closed"
+	SupportStatusResolved   = "resolved"
+)
+
+// st is shared by the handlers.
+var st store.Store
+
+func extractBearerToken(header string) (string, error) {
+	if header == "" {
+		return "", errors.New("bad header value given")
+	}
+
+	jwtToken := strings.Split(header, " ")
+	if len(jwtToken) != 2 {
+		return "", errors.New("incorrectly formatted authorization header")
+	}
+
+	return jwtToken[1], nil
+}
+
+func parseToken(jwtToken string) (*jwt.Token, error) {
+	token, err := jwt.Parse(jwtToken, func(token *jwt.Token) (interface{}, error) {
+		if _, OK := token.Method.(*jwt.SigningMethodHMAC); !OK {
+			return nil, errors.New("bad signed method received")
+		}
+		return []byte(os.Getenv("JWT_SECRET")), nil
+	})
+
+	if err != nil {
+		return nil, errors.New("bad jwt token")
+	}
+
+	return token, nil
+}
+
+func timeoutResponse(c *gin.Context) {
+	c.JSON(http.StatusRequestTimeout, gin.H{
+		"message": "Request Timeout",
+	})
+}
+
+// untimedRoutes hold the connection open or wait on other servers, like a
+// directory, an identity provider or the mail relay, and skip the request
+// timeout.
+var untimedRoutes = map[string]bool{
+	"/api/mails/stream":    true,
+	"/api/mails/wait":      true,
+	"/api/login":           true,
+	"/api/password/forgot": true,
+	"/api/oidc/login":      true,
+	"/api/oidc/callback":   true,
+}
+
+const requestTimeout = 500 * time.Millisecond
+
+func timeoutMiddleware() gin.HandlerFunc {
+	handler := timeout.New(
+		timeout.WithTimeout(requestTimeout),
+		timeout.WithHandler(func(c *gin.Context) {
+			c.Next()
+		}),
+		timeout.WithResponse(timeoutResponse),
+	)
+	return func(c *gin.Context) {
+		if untimedRoutes[c.FullPath()] {
+			c.Next()
+			return
+		}
+		// store calls use the request context, a timed out request
+		// cancels them instead of running on in the background
+		ctx, cancel := context.WithTimeout(c.Request.Context(), requestTimeout)
+		defer cancel()
+		c.Request = c.Request.WithContext(ctx)
+		handler(c)
+	}
+}
+
+// mailScope limits a user to their user inbox and the inboxes of their
+// projects, or of the projects they maintain when maintain is set. Users
+// with mails:all see every mail but the user inboxes of others.
+func mailScope(ctx context.Context, user userListDto, maintain bool) (store.MailFilter, error) {
+	if can(user, rbac.MailsAll) {
+		return store.MailFilter{Owner: user.Username}, nil
+	}
+	projects, err := st.ListProjects(ctx, user.Username)
+	if err != nil {
+		return store.MailFilter{}, err
+	}
+	inboxes := []string{store.UserInbox(user.Username)}
+	for _, project := range projects {
+		member, _ := project.Member(user.Username)
+		if maintain && member.Role != store.ProjectRoleMaintainer {
+			continue
+		}
+		inboxes = append(inboxes, project.Inbox)
+	}
+	return store.MailFilter{Inboxes: inboxes}, nil
+}
+
+// renameProjectMember moves the project memberships of a renamed user,
+// members are stored by username.
+func renameProjectMember(ctx context.Context, from, to string) error {
+	projects, err := st.ListProjects(ctx, from)
+	if err != nil {
+		return err
+	}
+	for _, project := range projects {
+		members := make([]store.ProjectMember, len(project.Members))
+		for i, member := range project.Members {
+			if member.Username == from {
+				member.Username = to
+			}
+			members[i] = member
+		}
+		project.Members = members
+		if err := st.UpdateProject(ctx, &project); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// canAccessMail applies mailScope to one mail.
+func canAccessMail(ctx context.Context, user userListDto, mail store.Mail, maintain bool) (bool, error) {
+	if strings.HasPrefix(mail.Inbox, store.UserInboxPrefix) {
+		return mail.Inbox == store.UserInbox(user.Username), nil
+	}
+	if can(user, rbac.MailsAll) {
+		return true, nil
+	}
+	if mail.Inbox == "" {
+		return false, nil
+	}
+	project, err := st.FindProjectByInbox(ctx, mail.Inbox)
+	if err == store.ErrNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	member, ok := project.Member(user.Username)
+	return ok && (!maintain || member.Role == store.ProjectRoleMaintainer), nil
+}
+
+// requestMailScope is the mailScope of the current user, narrowed to the
+// project of the api key the request is made with.
+func requestMailScope(c *gin.Context, maintain bool) (store.MailFilter, error) {
+	user, _ := c.Get("currentUser")
+	filter, err := mailScope(c.Request.Context(), user.(userListDto), maintain)
+	if err != nil {
+		return filter, err
+	}
+	inbox := keyInbox(c)
+	if inbox == "" {
+		return filter, nil
+	}
+	if filter.Inboxes == nil {
+		filter.Inboxes = []string{inbox}
+		return filter, nil
+	}
+	inboxes := []string{}
+	for _, i := range filter.Inboxes {
+		if i == inbox {
+			inboxes = append(inboxes, i)
+		}
+	}
+	filter.Inboxes = inboxes
+	return filter, nil
+}
+
+// requestCanAccessMail applies requestMailScope to one mail.
+func requestCanAccessMail(c *gin.Context, mail store.Mail, maintain bool) (bool, error) {
+	if inbox := keyInbox(c); inbox != "" && mail.Inbox != inbox {
+		return false, nil
+	}
+	user, _ := c.Get("currentUser")
+	return canAccessMail(c.Request.Context(), user.(userListDto), mail, maintain)
+}
+
+// keyInbox is the inbox the api key of the request is limited to.
+func keyInbox(c *gin.Context) string {
+	if key, ok := c.Get("currentApiKey"); ok {
+		return key.(store.ApiKey).Inbox
+	}
+	return ""
+}
+
+// userCanAccessMail aborts with 404 when the current user may not see the
+// mail, or may not change it when maintain is set.
+func userCanAccessMail(c *gin.Context, mail store.Mail, maintain bool) bool {
+	ok, err := requestCanAccessMail(c, mail, maintain)
+	if err != nil {
+		abortWithError(c, err)
+		return false
+	}
+	if !ok {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+			"message": "Mail not found",
+		})
+	}
+	return ok
+}
+
+// inboxPattern is what project inboxes may contain, never the colon of
+// store.UserInboxPrefix.
+var inboxPattern = regexp.MustCompile(`^[a-z0-9_-]+$`)
+
+func validateMembers(ctx context.Context, members []store.ProjectMember) error {
+	for _, member := range members {
+		if member.Role != store.ProjectRoleMaintainer && member.Role != store.ProjectRoleViewer {
+			return newAPIError(http.StatusBadRequest, fmt.Sprintf("Geçersiz proje rolü %q", member.Role))
+		}
+		if _, err := st.FindUserByUsername(ctx, member.Username); err == store.ErrNotFound {
+			return newAPIError(http.StatusBadRequest, "Kullanıcı bulunamadı: "+member.Username)
+		} else if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// withoutSecrets hides the webhook secrets, they are only returned when
+// they are set.
+func withoutSecrets(webhooks []store.Webhook) []store.Webhook {
+	if webhooks == nil {
+		return nil
+	}
+	hidden := make([]store.Webhook, len(webhooks))
+	for i, webhook := range webhooks {
+		webhook.Secret = ""
+		hidden[i] = webhook
+	}
+	return hidden
+}
+
+// keepSecrets gives the webhooks sent without a secret the one stored for
+// the same target, a client never reads them back to send them again.
+func keepSecrets(webhooks, stored []store.Webhook) []store.Webhook {
+	kept := make([]store.Webhook, len(webhooks))
+	for i, webhook := range webhooks {
+		if webhook.Secret == "" {
+			for _, old := range stored {
+				if old.Kind == webhook.Kind && old.URL == webhook.URL {
+					webhook.Secret = old.Secret
+				}
+			}
+		}
+		kept[i] = webhook
+	}
+	return kept
+}
+
+func validateWebhooks(webhooks []store.Webhook) error {
+	for _, webhook := range webhooks {
+		if err := notify.Validate(webhook); err != nil {
+			return newAPIError(http.StatusBadRequest, "Geçersiz webhook: "+err.Error())
+		}
+	}
+	return nil
+}
+
+// validateRole accepts the built-in roles and the custom ones.
+func validateRole(ctx context.Context, role string) error {
+	if _, ok := rbac.Builtin(role); ok {
+		return nil
+	}
+	_, err := st.FindRoleByName(ctx, role)
+	if err == store.ErrNotFound {
+		return newAPIError(http.StatusBadRequest, fmt.Sprintf("Geçersiz rol %q", role))
+	}
+	return err
+}
+
+func validatePermissions(permissions []string) error {
+	for _, permission := range permissions {
+		if !rbac.Valid(permission) {
+			return newAPIError(http.StatusBadRequest, fmt.Sprintf("Geçersiz yetki %q", permission))
+		}
+	}
+	return nil
+}
+
+// checkGrant refuses permissions the current user does not hold, so
+// users:manage never hands out more than its holder has.
+func checkGrant(c *gin.Context, permissions []string) error {
+	user, _ := c.Get("currentUser")
+	for _, permission := range permissions {
+		if !can(user.(userListDto), permission) {
+			return newAPIError(http.StatusForbidden, fmt.Sprintf("Sahip olmadığınız %q yetkisi verilemez", permission))
+		}
+	}
+	return nil
+}
+
+// checkRoleGrant applies checkGrant to the permissions of a role.
+func checkRoleGrant(c *gin.Context, role string) error {
+	permissions, err := rbac.Resolve(c.Request.Context(), st, role)
+	if err != nil {
+		return err
+	}
+	return checkGrant(c, permissions)
+}
+
+// managedUser loads the user of id, refusing users whose role the caller
+// could not grant.
+func managedUser(c *gin.Context, id string) (*store.User, error) {
+	user, err := st.FindUser(c.Request.Context(), id)
+	if err == store.ErrNotFound {
+		return nil, newAPIError(http.StatusNotFound, "Kullanıcı bulunamadı")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := checkRoleGrant(c, user.Role); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func newRoleDto(role store.Role, builtin bool) roleDto {
+	return roleDto{
+		Id:          role.Id,
+		Name:        role.Name,
+		Permissions: role.Permissions,
+		Builtin:     builtin,
+		CreatedAt:   role.CreatedAt,
+	}
+}
+
+func newUserListDto(user store.User) userListDto {
+	return userListDto{
+		Id:        user.Id,
+		Username:  user.Username,
+		Role:      user.Role,
+		Emails:    user.Emails,
+		Webhooks:  withoutSecrets(user.Webhooks),
+		CreatedAt: user.CreatedAt,
+	}
+}
+
+func newApiKeyDto(key store.ApiKey) apiKeyDto {
+	return apiKeyDto{
+		Id:         key.Id,
+		Name:       key.Name,
+		Prefix:     key.Prefix,
+		ProjectId:  key.ProjectId,
+		Inbox:      key.Inbox,
+		Scope:      key.Scope,
+		LastUsedAt: key.LastUsedAt,
+		CreatedAt:  key.CreatedAt,
+	}
+}
+
+func newMailListDto(mail store.Mail) mailListDto {
+	return mailListDto{
+		Id:        mail.Id,
+		Subject:   mail.Subject,
+		To:        mail.To,
+		IsRead:    mail.IsRead,
+		From:      mail.From,
+		Inbox:     mail.Inbox,
+		Date:      mail.Date,
+		CreatedAt: mail.CreatedAt,
+	}
+}
+
+// NewRouter builds the dashboard and API routes on top of the given store,
+// passwords hashes the passwords of users and logins checks them at login,
+// against the hashes of the store when nil.
+func NewRouter(db store.Store, attachments attachment.Storage, bus *events.Bus, passwords *password.Hasher, sender mailer.Sender, sso *oidc.Provider, logins authn.Authenticator) *gin.Engine {
+	st = db
+	if logins == nil {
+		logins = &authn.Local{Users: db, Passwords: passwords}
+	}
+	auth := newAuthorizer(db, db, db, userCacheTTL)
+	router := gin.Default()
+	router.LoadHTMLGlob("templates/*")
+	router.Static("/assets", "./assets")
+	router.Use(cors.Default())
+	router.Use(timeoutMiddleware())
+	// the page is public, the examples never show the smtp password
+	router.GET("/", func(c *gin.Context) {
+
+		curl := "curl  --url 'smtp://" + os.Getenv("HOST") + ":" + os.Getenv("SMTP_PORT") + "' --user '" + os.Getenv("SMTP_USERNAME") + ":<parola>' --mail-from " + os.Getenv("SMTP_USERNAME") + " --mail-rcpt " + os.Getenv("SMTP_USERNAME") + " --upload-file - <<EOF\n" +
+			"From: My Inbox <" + os.Getenv("SMTP_USERNAME") + ">\n" +
+			"To: Your Inbox <" + os.Getenv("SMTP_USERNAME") + ">\n" +
+			"Subject: Test Mail\n" +
+			"Content-Type: multipart/alternative; boundary=\"boundary-string\"\n" +
+			"\n" +
+			"--boundary-string\n" +
+			"--boundary-string\n" +
+			"Content-Type: text/plain; charset=\"utf-8\"\n" +
+			"Content-Transfer-Encoding: quoted-printable\n" +
+			"Content-Disposition: inline\n" +
+			"\n" +
+			"Test Mail\n" +
+			"\n" +
+			"Lorem Ipsum is simply dummy text of the printing and typesetting industry. Lorem Ipsum has been the industry's standard dummy text ever since the 1500s, when an unknown printer took a galley of type and scrambled it to make a type specimen book\n" +
+			"\n" +
+			"--boundary-string\n" +
+			"Content-Type: text/html; charset=\"utf-8\"\n" +
+			"Content-Transfer-Encoding: quoted-printable\n" +
+			"Content-Disposition: inline\n" +
+			"\n" +
+			"<!doctype html>\n" +
+			"<html>\n" +
+			"<head>\n" +
+			"<meta http-equiv=\"Content-Type\" content=\"text/html; charset=UTF-8\">\n" +
+			"</head>\n" +
+			"<body style=\"font-family: sans-serif;\">\n" +
+			"<div style=\"display: block; margin: auto; max-width: 600px;\" class=\"main\">\n" +
+			"<h1 style=\"font-size: 18px; font-weight: bold; margin-top: 20px\">Test Mail</h1>\n" +
+			"<p>Lorem Ipsum is simply dummy text of the printing and typesetting industry. Lorem Ipsum has been the industry's standard dummy text ever since the 1500s, when an unknown printer took a galley of type and scrambled it to make a type specimen book</p>\n" +
+			"</div>\n" +
+			"<style>\n" +
+			".main { background-color: #EEE; }\n" +
+			"a:hover { border-left-width: 1em; min-height: 2em; }\n" +
+			"</style>\n" +
+			"</body>\n" +
+			"</html>\n" +
+			"\n" +
+			"--boundary-string--\n" +
+			"EOF"
+
+		c.HTML(http.StatusOK, "index.tmpl", gin.H{
+			"title":    "MailTracker",
+			"curl":     curl,
+			"host":     os.Getenv("HOST"),
+			"port":     os.Getenv("SMTP_PORT"),
+			"username": os.Getenv("SMTP_USERNAME"),
+			"sso":      sso != nil,
+		})
+	})
+
+	// an iframe can not send the Authorization header, the dashboard loads
+	// the frame with a pass from POST /api/mails/passes
+	router.GET("/iframe/mails/:id", auth.requirePass(rbac.MailsRead, func(c *gin.Context) string {
+		return framePass(c.Param("id"))
+	}), func(c *gin.Context) {
+		mail, err := st.FindMail(c.Request.Context(), c.Param("id"))
+		if err == store.ErrNotFound {
+			err = newAPIError(http.StatusNotFound, "Mail not found")
+		}
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+		if !userCanAccessMail(c, *mail, false) {
+			return
+		}
+		// links in the mail must not carry the pass away
+		c.Header("Referrer-Policy", "no-referrer")
+		html := ""
+
+		// mails stored before multipart parsing only have a body
+		source := mail.Html
+		if source == "" {
+			source = mail.Body
+		}
+		data := regexp.MustCompile(`(?s)<body.*?>(.*?)</body>`).FindStringSubmatch(source)
+		if len(data) > 0 {
+			html = data[1]
+		}
+
+		// when request query param return json
+		if c.Query("json") == "true" {
+			c.JSON(http.StatusOK, gin.H{
+				"data": html,
+			})
+			return
+		}
+
+		// return template
+		c.HTML(http.StatusOK, "iframe.tmpl", gin.H{
+			"body": template.HTML(html),
+		})
+	})
+
+	// EventSource clients can not set headers either and connect with a
+	// stream pass
+	router.GET("/api/mails/stream", auth.requirePass(rbac.MailsRead, func(c *gin.Context) string {
+		return streamPass
+	}), func(c *gin.Context) {
+		mails, stop := bus.Subscribe()
+		defer stop()
+
+		ping := time.NewTicker(30 * time.Second)
+		defer ping.Stop()
+
+		c.Header("Content-Type", "text/event-stream")
+		c.Header("Cache-Control", "no-cache")
+		c.Header("X-Accel-Buffering", "no")
+		c.Writer.WriteHeaderNow()
+		c.Writer.Flush()
+		c.Stream(func(w io.Writer) bool {
+			select {
+			case mail := <-mails:
+				if ok, _ := requestCanAccessMail(c, mail, false); ok {
+					c.SSEvent("mail", newMailListDto(mail))
+				}
+				return true
+			case <-ping.C:
+				c.SSEvent("ping", "")
+				return true
+			case <-c.Request.Context().Done():
+				return false
+			}
+		})
+	})
+
+	permissionMailRouter := router.Group("/")
+	permissionMailRouter.Use(auth.require(rbac.MailsRead))
+	permissionMailDeleteRouter := router.Group("/")
+	permissionMailDeleteRouter.Use(auth.require(rbac.MailsDelete))
+	permissionMailRouter.GET("/api/mails", func(c *gin.Context) {
+
+		var mails []mailListDto
+
+		// limit to the inboxes of the user's projects
+		filter, err := requestMailScope(c, false)
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+
+		filter, err = parseMailSearch(c, filter)
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+		page, err := parsePage(c, store.MailSortCreatedAt, store.MailSortSubject, store.MailSortFrom, store.MailSortTo)
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+
+		found, err := st.PageMails(c.Request.Context(), filter, page)
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+
+		for _, m := range found.Mails {
+			mails = append(mails, newMailListDto(m))
+		}
+		response := pageResponse(mails, page, found.Total)
+		response["unread"] = found.Unread
+		c.JSON(http.StatusOK, response)
+	})
+	// long poll for test automation, answers with the first matching mail
+	// or 408 when none arrives in time
+	permissionMailRouter.GET("/api/mails/wait", func(c *gin.Context) {
+		query, err := parseWaitQuery(c)
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+
+		// subscribe before looking at the stored mails, a mail stored in
+		// between is not missed
+		mails, stop := bus.Subscribe()
+		defer stop()
+
+		if !query.since.IsZero() {
+			filter, err := requestMailScope(c, false)
+			if err != nil {
+				abortWithError(c, err)
+				return
+			}
+			filter.Subject = query.subject
+			filter.After = query.since
+			found, err := st.ListMails(c.Request.Context(), filter)
+			if err != nil {
+				abortWithError(c, err)
+				return
+			}
+			// oldest first, the mail a test waits for is the first one
+			for i := len(found) - 1; i >= 0; i-- {
+				if !query.matches(found[i]) {
+					continue
+				}
+				// the listing has no bodies
+				mail, err := st.FindMail(c.Request.Context(), found[i].Id)
+				if err != nil {
+					abortWithError(c, err)
+					return
+				}
+				c.JSON(http.StatusOK, gin.H{
+					"data": mail,
+				})
+				return
+			}
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), query.timeout)
+		defer cancel()
+		for {
+			select {
+			case mail := <-mails:
+				if !query.matches(mail) {
+					continue
+				}
+				if ok, err := requestCanAccessMail(c, mail, false); err != nil || !ok {
+					continue
+				}
+				c.JSON(http.StatusOK, gin.H{
+					"data": mail,
+				})
+				return
+			case <-ctx.Done():
+				if c.Request.Context().Err() != nil {
+					// the client went away
+					return
+				}
+				abortWithError(c, newAPIError(http.StatusRequestTimeout, "Timed out waiting for the mail"))
+				return
+			}
+		}
+	})
+	// get mail from iframe
+
+	permissionMailRouter.GET("/api/mails/:id", func(c *gin.Context) {
+		mail, err := st.FindMail(c.Request.Context(), c.Param("id"))
+		if err == store.ErrNotFound {
+			err = newAPIError(http.StatusNotFound, "Mail not found")
+		}
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+		if !userCanAccessMail(c, *mail, false) {
+			return
+		}
+		// a read-only api key looks at the mail without marking it read
+		if key, ok := c.Get("currentApiKey"); !ok || key.(store.ApiKey).Scope != store.ApiKeyScopeRead {
+			err = st.MarkMailRead(c.Request.Context(), mail.Id)
+			if err != nil {
+				abortWithError(c, err)
+				return
+			}
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"data": mail,
+		})
+	})
+	// passes stand in for the access token where the browser loads a
+	// route by its url, use is "frame" with the mailid to show or "stream"
+	permissionMailRouter.POST("/api/mails/passes", func(c *gin.Context) {
+		var body struct {
+			Use    string `json:"use"`
+			MailId string `json:"mailid"`
+		}
+		c.BindJSON(&body)
+
+		var use string
+		switch body.Use {
+		case "frame":
+			mail, err := st.FindMail(c.Request.Context(), body.MailId)
+			if err == store.ErrNotFound {
+				err = newAPIError(http.StatusNotFound, "Mail not found")
+			}
+			if err != nil {
+				abortWithError(c, err)
+				return
+			}
+			if !userCanAccessMail(c, *mail, false) {
+				return
+			}
+			use = framePass(mail.Id)
+		case "stream":
+			use = streamPass
+		default:
+			abortWithError(c, newAPIError(http.StatusBadRequest, fmt.Sprintf("Geçersiz bilet kullanımı %q", body.Use)))
+			return
+		}
+
+		current, _ := c.Get("currentUser")
+		user, err := st.FindUser(c.Request.Context(), current.(userListDto).Id)
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+		pass, err := signPass(user.Salt, use)
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"data": gin.H{
+				"pass":      pass,
+				"expiresin": int(passTTL / time.Second),
+			},
+		})
+	})
+	permissionMailRouter.GET("/api/mails/:id/attachments", func(c *gin.Context) {
+		mail, err := st.FindMail(c.Request.Context(), c.Param("id"))
+		if err != nil {
+			if err == store.ErrNotFound {
+				c.JSON(http.StatusNotFound, gin.H{
+					"message": "Mail not found",
+				})
+				return
+			}
+			abortWithError(c, err)
+			return
+		}
+		if !userCanAccessMail(c, *mail, false) {
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"data": mail.Attachments,
+		})
+	})
+	permissionMailRouter.GET("/api/mails/:id/attachments/:aid", func(c *gin.Context) {
+		mail, err := st.FindMail(c.Request.Context(), c.Param("id"))
+		if err != nil && err != store.ErrNotFound {
+			abortWithError(c, err)
+			return
+		}
+
+		if mail != nil && !userCanAccessMail(c, *mail, false) {
+			return
+		}
+
+		var file *attachmentDto
+		if mail != nil {
+			for i, a := range mail.Attachments {
+				if a.Id == c.Param("aid") {
+					file = &mail.Attachments[i]
+				}
+			}
+		}
+		if file == nil {
+			c.JSON(http.StatusNotFound, gin.H{
+				"message": "Attachment not found",
+			})
+			return
+		}
+
+		reader, err := attachments.Open(c.Request.Context(), file.Id)
+		if err != nil {
+			if err == attachment.ErrNotFound {
+				c.JSON(http.StatusNotFound, gin.H{
+					"message": "Attachment not found",
+				})
+				return
+			}
+			abortWithError(c, err)
+			return
+		}
+		defer reader.Close()
+
+		filename := file.Filename
+		if filename == "" {
+			filename = "attachment-" + file.Id
+		}
+		contentType := file.ContentType
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		c.DataFromReader(http.StatusOK, int64(file.Size), contentType, reader, map[string]string{
+			"Content-Disposition": mime.FormatMediaType("attachment", map[string]string{"filename": filename}),
+		})
+	})
+	permissionMailDeleteRouter.DELETE("/api/mails/:id", func(c *gin.Context) {
+		mail, err := st.FindMail(c.Request.Context(), c.Param("id"))
+		if err != nil && err != store.ErrNotFound {
+			abortWithError(c, err)
+			return
+		}
+		if mail != nil && !userCanAccessMail(c, *mail, true) {
+			return
+		}
+		if mail != nil {
+			for _, a := range mail.Attachments {
+				err = attachments.Delete(c.Request.Context(), a.Id)
+				if err != nil {
+					log.Println(err)
+				}
+			}
+		}
+		err = st.DeleteMail(c.Request.Context(), c.Param("id"))
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Mail deleted",
+		})
+	})
+	// delete all mails of the projects the user maintains
+	permissionMailDeleteRouter.DELETE("/api/mails", func(c *gin.Context) {
+		filter, err := requestMailScope(c, true)
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+		files, err := st.MailAttachments(c.Request.Context(), filter)
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+		for _, a := range files {
+			err = attachments.Delete(c.Request.Context(), a.Id)
+			if err != nil {
+				log.Println(err)
+			}
+		}
+		err = st.DeleteAllMails(c.Request.Context(), filter)
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"message": "All mails deleted",
+		})
+	})
+	// read all mails of the projects the user maintains
+	permissionMailDeleteRouter.PUT("/api/mails", func(c *gin.Context) {
+		filter, err := requestMailScope(c, true)
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+		err = st.MarkAllMailsRead(c.Request.Context(), filter)
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"message": "All mails read",
+		})
+	})
+	permissionUsersRouter := router.Group("/")
+	permissionUsersRouter.Use(auth.require(rbac.UsersManage))
+	permissionProjectsRouter := router.Group("/")
+	permissionProjectsRouter.Use(auth.require(rbac.ProjectsManage))
+	permissionTicketsRouter := router.Group("/")
+	permissionTicketsRouter.Use(auth.require(rbac.TicketsManage))
+	permissionSettingsRouter := router.Group("/")
+	permissionSettingsRouter.Use(auth.require(rbac.SettingsManage))
+
+	// user and login routes
+
+	router.POST("/api/login", func(c *gin.Context) {
+		var login userDto
+		c.BindJSON(&login)
+
+		user, err := logins.Authenticate(c.Request.Context(), login.Username, login.Password)
+		switch err {
+		case nil:
+		case authn.ErrInvalidCredentials:
+			c.JSON(
+				http.StatusUnauthorized,
+				gin.H{
+					"message": "Kullanıcı adı veya parola hatalı.",
+				})
+			return
+		case authn.ErrNoRole:
+			abortWithError(c, newAPIError(http.StatusForbidden, "Hesabınızın MailTracker erişimi yok"))
+			return
+		case authn.ErrUsernameTaken:
+			abortWithError(c, newAPIError(http.StatusConflict, "Kullanıcı adı zaten kullanılıyor"))
+			return
+		default:
+			log.Println("Login of", login.Username, "failed:", err)
+			abortWithError(c, newAPIError(http.StatusServiceUnavailable, "Kimlik doğrulama şu anda yapılamıyor"))
+			return
+		}
+		// a directory login may have changed the role
+		auth.forget()
+
+		tokens, err := issueTokens(c.Request.Context(), st, user)
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+
+		c.JSON(
+			http.StatusOK,
+			gin.H{
+				"data": gin.H{
+					"token":        tokens.Token,
+					"refreshtoken": tokens.RefreshToken,
+					"expiresin":    tokens.ExpiresIn,
+					"user":         newUserListDto(*user),
+				},
+			},
+		)
+	})
+
+	// single sign-on: the login sends the browser to the provider, which
+	// sends it back to the callback with a code
+	router.GET("/api/oidc/login", func(c *gin.Context) {
+		if sso == nil {
+			abortWithError(c, newAPIError(http.StatusNotFound, "Tek oturum açma yapılandırılmamış"))
+			return
+		}
+		var flow ssoFlow
+		for _, value := range []*string{&flow.State, &flow.Nonce, &flow.Verifier} {
+			random, err := oidc.RandomString()
+			if err != nil {
+				abortWithError(c, err)
+				return
+			}
+			*value = random
+		}
+		cookie, err := signSSOFlow(flow)
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+		// lax, the cookie has to come along with the redirect back
+		c.SetSameSite(http.SameSiteLaxMode)
+		c.SetCookie(ssoCookie, cookie, int(ssoFlowTTL/time.Second), "/api/oidc", "", c.Request.TLS != nil, true)
+		c.Redirect(http.StatusFound, sso.AuthCodeURL(flow.State, flow.Nonce, flow.Verifier))
+	})
+
+	// the callback hands the tokens to the dashboard in the url fragment,
+	// which never reaches a server
+	router.GET("/api/oidc/callback", func(c *gin.Context) {
+		fail := func(message string) {
+			c.Redirect(http.StatusFound, "/#ssoerror="+url.QueryEscape(message))
+		}
+		if sso == nil {
+			abortWithError(c, newAPIError(http.StatusNotFound, "Tek oturum açma yapılandırılmamış"))
+			return
+		}
+		cookie, _ := c.Cookie(ssoCookie)
+		c.SetCookie(ssoCookie, "", -1, "/api/oidc", "", c.Request.TLS != nil, true)
+		flow, err := parseSSOFlow(cookie)
+		if err != nil || flow.State == "" || flow.State != c.Query("state") {
+			fail("Oturum açma isteği geçersiz veya süresi dolmuş")
+			return
+		}
+		if c.Query("error") != "" {
+			log.Println("Sign-on refused by the provider:", c.Query("error"), c.Query("error_description"))
+			fail("Tek oturum açma başarısız")
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), ssoTimeout)
+		defer cancel()
+		claims, err := sso.Exchange(ctx, c.Query("code"), flow.Verifier, flow.Nonce)
+		if err != nil {
+			log.Println("Sign-on failed:", err)
+			fail("Tek oturum açma başarısız")
+			return
+		}
+		user, err := provisionSSOUser(ctx, sso, claims)
+		var apiErr *apiError
+		if errors.As(err, &apiErr) {
+			fail(apiErr.Error())
+			return
+		}
+		if err != nil {
+			log.Println("Provisioning the sign-on user", claims.Username(), "failed:", err)
+			fail("Tek oturum açma başarısız")
+			return
+		}
+		// the role may have changed with the groups
+		auth.forget()
+
+		tokens, err := issueTokens(ctx, st, user)
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+		c.Redirect(http.StatusFound, "/#"+url.Values{
+			"token":        {tokens.Token},
+			"refreshtoken": {tokens.RefreshToken},
+			"expiresin":    {strconv.Itoa(tokens.ExpiresIn)},
+		}.Encode())
+	})
+
+	// the refresh token is replaced on every use
+	router.POST("/api/token/refresh", func(c *gin.Context) {
+		var body struct {
+			RefreshToken string `json:"refreshtoken"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			return
+		}
+
+		// taking the session is atomic, a token used twice at once only
+		// gets one new pair
+		session, err := st.TakeRefreshToken(c.Request.Context(), hashToken(body.RefreshToken))
+		if err == store.ErrNotFound {
+			abortWithError(c, newAPIError(http.StatusUnauthorized, "Oturum sona erdi"))
+			return
+		}
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+		if time.Now().After(session.ExpiresAt) {
+			abortWithError(c, newAPIError(http.StatusUnauthorized, "Oturum sona erdi"))
+			return
+		}
+
+		user, err := st.FindUser(c.Request.Context(), session.UserId)
+		if err == store.ErrNotFound {
+			abortWithError(c, newAPIError(http.StatusUnauthorized, "Kullanıcı bulunamadı"))
+			return
+		}
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+		tokens, err := issueTokens(c.Request.Context(), st, user)
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"data": tokens,
+		})
+	})
+	// logout ends the session of the refresh token, its access token runs
+	// out on its own
+	router.POST("/api/logout", func(c *gin.Context) {
+		var body struct {
+			RefreshToken string `json:"refreshtoken"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			return
+		}
+		session, err := st.FindRefreshToken(c.Request.Context(), hashToken(body.RefreshToken))
+		if err != nil && err != store.ErrNotFound {
+			abortWithError(c, err)
+			return
+		}
+		if session != nil {
+			if err := st.DeleteRefreshToken(c.Request.Context(), session.Id); err != nil {
+				abortWithError(c, err)
+				return
+			}
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Logged out",
+		})
+	})
+
+	// forgot always answers the same so it does not tell which accounts
+	// exist
+	router.POST("/api/password/forgot", func(c *gin.Context) {
+		var body struct {
+			Username string `json:"username"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			return
+		}
+		if _, ok := resetLink(""); !ok {
+			abortWithError(c, newAPIError(http.StatusServiceUnavailable, "Parola sıfırlama yapılandırılmamış"))
+			return
+		}
+		sent := gin.H{
+			"message": "Hesap varsa parola sıfırlama bağlantısı gönderildi",
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), resetTimeout)
+		defer cancel()
+		user, err := findResetUser(ctx, strings.TrimSpace(body.Username))
+		if err == store.ErrNotFound {
+			c.JSON(http.StatusOK, sent)
+			return
+		}
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+		// users of the sign-on have no password to reset
+		if user.Subject != "" && user.Password == "" {
+			c.JSON(http.StatusOK, sent)
+			return
+		}
+
+		token, err := randomToken()
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+		_, err = st.InsertPasswordReset(ctx, &store.PasswordReset{
+			Hash:      hashToken(token),
+			UserId:    user.Id,
+			ExpiresAt: time.Now().Add(passwordResetTTL),
+			CreatedAt: time.Now(),
+		})
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+		link, _ := resetLink(token)
+		if err := sender.Send(ctx, resetMessage(user, link)); err != nil {
+			log.Println("Sending the password reset of", user.Username, "failed:", err)
+		}
+		c.JSON(http.StatusOK, sent)
+	})
+
+	// reset sets a new password with the token of a reset mail and ends
+	// the sessions of the user
+	router.POST("/api/password/reset", func(c *gin.Context) {
+		var body struct {
+			Token    string `json:"token"`
+			Password string `json:"password"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			return
+		}
+
+		reset, err := st.FindPasswordReset(c.Request.Context(), hashToken(body.Token))
+		if err == store.ErrNotFound || (err == nil && time.Now().After(reset.ExpiresAt)) {
+			abortWithError(c, newAPIError(http.StatusBadRequest, "Bağlantı geçersiz veya süresi dolmuş"))
+			return
+		}
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+		// a weak password leaves the token usable for another try
+		if err := password.DefaultPolicy.Check(body.Password); err != nil {
+			abortWithError(c, newAPIError(http.StatusBadRequest, err.Error()))
+			return
+		}
+
+		hash, err := passwords.Hash(body.Password)
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+		err = st.UpdateUserPassword(c.Request.Context(), reset.UserId, hash)
+		if err == store.ErrNotFound {
+			abortWithError(c, newAPIError(http.StatusBadRequest, "Bağlantı geçersiz veya süresi dolmuş"))
+			return
+		}
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+		if err := st.DeleteUserPasswordResets(c.Request.Context(), reset.UserId); err != nil {
+			abortWithError(c, err)
+			return
+		}
+		if _, err := revokeSessions(c.Request.Context(), st, reset.UserId); err != nil {
+			abortWithError(c, err)
+			return
+		}
+		auth.forget()
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Parola güncellendi",
+		})
+	})
+
+	permissionUserRouter := router.Group("/")
+	permissionUserRouter.Use(auth.require(""))
+	permissionUserRouter.GET("/api/users/me", func(c *gin.Context) {
+
+		user, err := c.Get("currentUser")
+		if !err {
+			c.JSON(
+				http.StatusUnauthorized,
+				gin.H{
+					"message": "Oturum açmadınız.",
+				})
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"data": user,
+		})
+	})
+
+	// a password change needs the current password and ends the other
+	// sessions, the caller gets new tokens
+	permissionUserRouter.PUT("/api/users/me", func(c *gin.Context) {
+		var body struct {
+			Emails          []string `json:"emails"`
+			CurrentPassword string   `json:"currentpassword"`
+			Password        string   `json:"password"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			return
+		}
+		for _, email := range body.Emails {
+			if _, err := mail.ParseAddress(email); err != nil {
+				abortWithError(c, newAPIError(http.StatusBadRequest, "Geçersiz e-posta adresi: "+email))
+				return
+			}
+		}
+
+		current, _ := c.Get("currentUser")
+		user, err := st.FindUser(c.Request.Context(), current.(userListDto).Id)
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+
+		response := gin.H{
+			"message": "User updated",
+		}
+		if body.Password != "" {
+			// directory and sign-on users log in with the password kept there
+			if user.Subject != "" && user.Password == "" {
+				message := "Parolanız tek oturum açma sağlayıcısında yönetiliyor"
+				if strings.HasPrefix(user.Subject, authn.LDAPSubject) {
+					message = "Parolanız dizinde yönetiliyor"
+				}
+				abortWithError(c, newAPIError(http.StatusBadRequest, message))
+				return
+			}
+			if _, err := passwords.Verify(user.Password, body.CurrentPassword); err != nil {
+				abortWithError(c, newAPIError(http.StatusForbidden, "Mevcut parola hatalı"))
+				return
+			}
+			if err := password.DefaultPolicy.Check(body.Password); err != nil {
+				abortWithError(c, newAPIError(http.StatusBadRequest, err.Error()))
+				return
+			}
+			hash, err := passwords.Hash(body.Password)
+			if err != nil {
+				abortWithError(c, err)
+				return
+			}
+			if err := st.UpdateUserPassword(c.Request.Context(), user.Id, hash); err != nil {
+				abortWithError(c, err)
+				return
+			}
+			salt, err := revokeSessions(c.Request.Context(), st, user.Id)
+			if err != nil {
+				abortWithError(c, err)
+				return
+			}
+			user.Salt = salt
+			tokens, err := issueTokens(c.Request.Context(), st, user)
+			if err != nil {
+				abortWithError(c, err)
+				return
+			}
+			response["data"] = tokens
+		}
+
+		// emails are left alone when the request does not send them
+		if body.Emails != nil {
+			user.Emails = body.Emails
+			if err := st.UpdateUser(c.Request.Context(), user); err != nil {
+				abortWithError(c, err)
+				return
+			}
+		}
+		auth.forget()
+		c.JSON(http.StatusOK, response)
+	})
+
+	permissionUserRouter.PUT("/api/users/me/webhooks", func(c *gin.Context) {
+		var body struct {
+			Webhooks []store.Webhook `json:"webhooks"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			return
+		}
+		if err := validateWebhooks(body.Webhooks); err != nil {
+			abortWithError(c, err)
+			return
+		}
+
+		current, _ := c.Get("currentUser")
+		user, err := st.FindUser(c.Request.Context(), current.(userListDto).Id)
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+		webhooks := keepSecrets(body.Webhooks, user.Webhooks)
+		err = st.UpdateUserWebhooks(c.Request.Context(), user.Id, webhooks)
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+		auth.forget()
+		// the only response with the secrets
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Webhooks updated",
+			"data":    webhooks,
+		})
+	})
+
+	permissionUsersRouter.GET("/api/users", func(c *gin.Context) {
+		var users []userListDto
+		page, err := parsePage(c, "username", "role", "createdat")
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+		found, err := st.ListUsers(c.Request.Context())
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+		start, end := pageBounds(found, len(found), page, func(i, j int) bool {
+			switch page.Sort {
+			case "role":
+				return found[i].Role < found[j].Role
+			case "createdat":
+				return found[i].CreatedAt.Before(found[j].CreatedAt)
+			}
+			return strings.ToLower(found[i].Username) < strings.ToLower(found[j].Username)
+		})
+		for _, u := range found[start:end] {
+			users = append(users, newUserListDto(u))
+		}
+		c.JSON(http.StatusOK, pageResponse(users, page, len(found)))
+	})
+	permissionUsersRouter.POST("/api/users", func(c *gin.Context) {
+		var user userDto
+		c.BindJSON(&user)
+
+		// validate username length
+		if len(user.Username) < 3 {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"message": "Kullanıcı adı en az 3 karakter olmalıdır",
+			})
+			return
+		}
+		if err := password.DefaultPolicy.Check(user.Password); err != nil {
+			abortWithError(c, newAPIError(http.StatusBadRequest, err.Error()))
+			return
+		}
+
+		if err := validateWebhooks(user.Webhooks); err != nil {
+			abortWithError(c, err)
+			return
+		}
+		if err := validateRole(c.Request.Context(), user.Role); err != nil {
+			abortWithError(c, err)
+			return
+		}
+		if err := checkRoleGrant(c, user.Role); err != nil {
+			abortWithError(c, err)
+			return
+		}
+
+		salt, err := authn.NewSalt()
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+
+		_, err = st.FindUserByUsername(c.Request.Context(), user.Username)
+		if err != nil {
+			if err != store.ErrNotFound {
+				abortWithError(c, err)
+				return
+			}
+		} else {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"message": "Kullanıcı adı zaten kullanılıyor",
+			})
+			return
+		}
+
+		hashPassword, err := passwords.Hash(user.Password)
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+
+		_, err = st.InsertUser(c.Request.Context(), &store.User{
+			Username:  user.Username,
+			Password:  hashPassword,
+			Emails:    user.Emails,
+			Webhooks:  user.Webhooks,
+			Salt:      salt,
+			Role:      user.Role,
+			CreatedAt: time.Now().UTC(),
+		})
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"message": "User created",
+		})
+	})
+	permissionUsersRouter.DELETE("/api/users/:id", func(c *gin.Context) {
+		if _, err := managedUser(c, c.Param("id")); err != nil {
+			abortWithError(c, err)
+			return
+		}
+		err := st.DeleteUser(c.Request.Context(), c.Param("id"))
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+		err = st.DeleteUserRefreshTokens(c.Request.Context(), c.Param("id"))
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+		auth.forget()
+		c.JSON(http.StatusOK, gin.H{
+			"message": "User deleted",
+		})
+	})
+	// revoke every session of a user, a new salt also invalidates the
+	// access tokens already issued
+	permissionUsersRouter.DELETE("/api/users/:id/sessions", func(c *gin.Context) {
+		if _, err := managedUser(c, c.Param("id")); err != nil {
+			abortWithError(c, err)
+			return
+		}
+		_, err := revokeSessions(c.Request.Context(), st, c.Param("id"))
+		if err == store.ErrNotFound {
+			abortWithError(c, newAPIError(http.StatusNotFound, "Kullanıcı bulunamadı"))
+			return
+		}
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+		auth.forget()
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Sessions revoked",
+		})
+	})
+	// update user
+	permissionUsersRouter.PUT("/api/users/:id", func(c *gin.Context) {
+		id := c.Param("id")
+		var user userDto
+		c.BindJSON(&user)
+
+		// validate username length
+		if len(user.Username) < 3 {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"message": "Kullanıcı adı en az 3 karakter olmalıdır",
+			})
+			return
+		}
+
+		if user.Password != "" {
+			if err := password.DefaultPolicy.Check(user.Password); err != nil {
+				abortWithError(c, newAPIError(http.StatusBadRequest, err.Error()))
+				return
+			}
+		}
+
+		// check if user exists
+		userExists, err := st.FindUserByUsername(c.Request.Context(), user.Username)
+		if err != nil {
+			if err != store.ErrNotFound {
+				abortWithError(c, err)
+				return
+			}
+		} else if userExists.Id != id {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"message": "Kullanıcı adı zaten kullanılıyor",
+			})
+			return
+		}
+
+		if err := validateWebhooks(user.Webhooks); err != nil {
+			abortWithError(c, err)
+			return
+		}
+		if err := validateRole(c.Request.Context(), user.Role); err != nil {
+			abortWithError(c, err)
+			return
+		}
+		// neither the new role nor the user may have more than the caller
+		if err := checkRoleGrant(c, user.Role); err != nil {
+			abortWithError(c, err)
+			return
+		}
+		target, err := managedUser(c, id)
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+
+		user.Id = id
+		err = st.UpdateUser(c.Request.Context(), &user)
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+		// the role may have changed
+		auth.forget()
+		if user.Username != target.Username {
+			if err := renameProjectMember(c.Request.Context(), target.Username, user.Username); err != nil {
+				abortWithError(c, err)
+				return
+			}
+		}
+
+		// webhooks are left alone when the request does not send them
+		if user.Webhooks != nil {
+			err = st.UpdateUserWebhooks(c.Request.Context(), id, keepSecrets(user.Webhooks, target.Webhooks))
+			if err != nil {
+				abortWithError(c, err)
+				return
+			}
+		}
+
+		if len(user.Password) > 0 {
+			hashPassword, err := passwords.Hash(user.Password)
+			if err != nil {
+				abortWithError(c, err)
+				return
+			}
+			err = st.UpdateUserPassword(c.Request.Context(), id, hashPassword)
+			if err != nil {
+				abortWithError(c, err)
+				return
+			}
+			// whoever knew the old password is logged out
+			if _, err := revokeSessions(c.Request.Context(), st, id); err != nil {
+				abortWithError(c, err)
+				return
+			}
+			auth.forget()
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"message": "User updated",
+		})
+	})
+	permissionUsersRouter.GET("/api/users/:id", func(c *gin.Context) {
+		user, err := st.FindUser(c.Request.Context(), c.Param("id"))
+		if err != nil {
+			if err == store.ErrNotFound {
+				c.JSON(http.StatusNotFound, gin.H{
+					"message": "Kullanıcı bulunamadı",
+				})
+				return
+			}
+			abortWithError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"data": newUserListDto(*user),
+		})
+	})
+
+	// roles, custom ones next to the built-in admin and watcher
+	permissionUsersRouter.GET("/api/roles", func(c *gin.Context) {
+		roles := []roleDto{}
+		for _, name := range []string{rbac.RoleAdmin, rbac.RoleWatcher} {
+			permissions, _ := rbac.Builtin(name)
+			roles = append(roles, newRoleDto(store.Role{Id: name, Name: name, Permissions: permissions}, true))
+		}
+		custom, err := st.ListRoles(c.Request.Context())
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+		for _, role := range custom {
+			roles = append(roles, newRoleDto(role, false))
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"data":        roles,
+			"permissions": rbac.Permissions(),
+		})
+	})
+	permissionUsersRouter.POST("/api/roles", func(c *gin.Context) {
+		var body struct {
+			Name        string   `json:"name"`
+			Permissions []string `json:"permissions"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			return
+		}
+		body.Name = strings.TrimSpace(body.Name)
+		if len(body.Name) < 3 {
+			abortWithError(c, newAPIError(http.StatusBadRequest, "Rol adı en az 3 karakter olmalıdır"))
+			return
+		}
+		if err := validatePermissions(body.Permissions); err != nil {
+			abortWithError(c, err)
+			return
+		}
+		if err := checkGrant(c, body.Permissions); err != nil {
+			abortWithError(c, err)
+			return
+		}
+		_, err := st.FindRoleByName(c.Request.Context(), body.Name)
+		if _, builtin := rbac.Builtin(body.Name); builtin || err == nil {
+			abortWithError(c, newAPIError(http.StatusBadRequest, "Rol adı zaten kullanılıyor"))
+			return
+		}
+		if err != store.ErrNotFound {
+			abortWithError(c, err)
+			return
+		}
+
+		role := store.Role{
+			Name:        body.Name,
+			Permissions: body.Permissions,
+			CreatedAt:   time.Now().UTC(),
+		}
+		if role.Permissions == nil {
+			role.Permissions = []string{}
+		}
+		if _, err := st.InsertRole(c.Request.Context(), &role); err != nil {
+			abortWithError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"data": newRoleDto(role, false),
+		})
+	})
+	// the name of a role is fixed, users refer to it
+	permissionUsersRouter.PUT("/api/roles/:id", func(c *gin.Context) {
+		var body struct {
+			Permissions []string `json:"permissions"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			return
+		}
+		if err := validatePermissions(body.Permissions); err != nil {
+			abortWithError(c, err)
+			return
+		}
+		if _, builtin := rbac.Builtin(c.Param("id")); builtin {
+			abortWithError(c, newAPIError(http.StatusBadRequest, "Yerleşik roller değiştirilemez"))
+			return
+		}
+		role, err := st.FindRole(c.Request.Context(), c.Param("id"))
+		if err == store.ErrNotFound {
+			abortWithError(c, newAPIError(http.StatusNotFound, "Rol bulunamadı"))
+			return
+		}
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+		// the role may be given to users already, it can not grow past
+		// the caller or be changed by someone with less
+		if err := checkGrant(c, append(body.Permissions, role.Permissions...)); err != nil {
+			abortWithError(c, err)
+			return
+		}
+		role.Permissions = body.Permissions
+		if role.Permissions == nil {
+			role.Permissions = []string{}
+		}
+		if err := st.UpdateRole(c.Request.Context(), role); err != nil {
+			abortWithError(c, err)
+			return
+		}
+		auth.forget()
+		c.JSON(http.StatusOK, gin.H{
+			"data": newRoleDto(*role, false),
+		})
+	})
+	// a role still given to users can not be deleted
+	permissionUsersRouter.DELETE("/api/roles/:id", func(c *gin.Context) {
+		if _, builtin := rbac.Builtin(c.Param("id")); builtin {
+			abortWithError(c, newAPIError(http.StatusBadRequest, "Yerleşik roller silinemez"))
+			return
+		}
+		role, err := st.FindRole(c.Request.Context(), c.Param("id"))
+		if err == store.ErrNotFound {
+			abortWithError(c, newAPIError(http.StatusNotFound, "Rol bulunamadı"))
+			return
+		}
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+		users, err := st.ListUsers(c.Request.Context())
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+		for _, user := range users {
+			if user.Role == role.Name {
+				abortWithError(c, newAPIError(http.StatusBadRequest, "Rol kullanıcılara atanmış: "+user.Username))
+				return
+			}
+		}
+		if err := st.DeleteRole(c.Request.Context(), role.Id); err != nil {
+			abortWithError(c, err)
+			return
+		}
+		auth.forget()
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Role deleted",
+		})
+	})
+
+	// projects, mails belong to the project owning their inbox
+	permissionUserRouter.GET("/api/projects", func(c *gin.Context) {
+		user, _ := c.Get("currentUser")
+		username := user.(userListDto).Username
+		if can(user.(userListDto), rbac.MailsAll) || can(user.(userListDto), rbac.ProjectsManage) {
+			username = ""
+		}
+		projects, err := st.ListProjects(c.Request.Context(), username)
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+		if projects == nil {
+			projects = []projectDto{}
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"data": projects,
+		})
+	})
+	permissionUserRouter.GET("/api/projects/:id", func(c *gin.Context) {
+		project, err := st.FindProject(c.Request.Context(), c.Param("id"))
+		if err != nil && err != store.ErrNotFound {
+			abortWithError(c, err)
+			return
+		}
+		user, _ := c.Get("currentUser")
+		if project != nil && !can(user.(userListDto), rbac.MailsAll) && !can(user.(userListDto), rbac.ProjectsManage) {
+			if _, ok := project.Member(user.(userListDto).Username); !ok {
+				project = nil
+			}
+		}
+		if project == nil {
+			c.JSON(http.StatusNotFound, gin.H{
+				"message": "Proje bulunamadı",
+			})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"data": project,
+		})
+	})
+	permissionProjectsRouter.POST("/api/projects", func(c *gin.Context) {
+		var project projectDto
+		c.BindJSON(&project)
+
+		if project.Name == "" || !inboxPattern.MatchString(project.Inbox) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"message": "Proje adı boş olamaz, inbox yalnızca küçük harf, rakam, - ve _ içerebilir",
+			})
+			return
+		}
+		if err := validateMembers(c.Request.Context(), project.Members); err != nil {
+			abortWithError(c, err)
+			return
+		}
+
+		_, err := st.FindProjectByInbox(c.Request.Context(), project.Inbox)
+		if err == nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"message": "Inbox zaten kullanılıyor",
+			})
+			return
+		}
+		if err != store.ErrNotFound {
+			abortWithError(c, err)
+			return
+		}
+
+		project.CreatedAt = time.Now().UTC()
+		if _, err := st.InsertProject(c.Request.Context(), &project); err != nil {
+			abortWithError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"data": project,
+		})
+	})
+	// update the name and members, the inbox of a project never changes
+	permissionProjectsRouter.PUT("/api/projects/:id", func(c *gin.Context) {
+		var project projectDto
+		c.BindJSON(&project)
+
+		if project.Name == "" {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"message": "Proje adı boş olamaz",
+			})
+			return
+		}
+		if err := validateMembers(c.Request.Context(), project.Members); err != nil {
+			abortWithError(c, err)
+			return
+		}
+
+		project.Id = c.Param("id")
+		err := st.UpdateProject(c.Request.Context(), &project)
+		if err == store.ErrNotFound {
+			c.JSON(http.StatusNotFound, gin.H{
+				"message": "Proje bulunamadı",
+			})
+			return
+		}
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Project updated",
+		})
+	})
+	permissionProjectsRouter.DELETE("/api/projects/:id", func(c *gin.Context) {
+		err := st.DeleteProject(c.Request.Context(), c.Param("id"))
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Project deleted",
+		})
+	})
+
+	// api keys, for scripts and test suites reading the mails of a user
+	permissionUserRouter.GET("/api/keys", func(c *gin.Context) {
+		user, _ := c.Get("currentUser")
+		found, err := st.ListApiKeys(c.Request.Context(), user.(userListDto).Id)
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+		keys := []apiKeyDto{}
+		for _, key := range found {
+			keys = append(keys, newApiKeyDto(key))
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"data": keys,
+		})
+	})
+	permissionUserRouter.POST("/api/keys", func(c *gin.Context) {
+		var body apiKeyDto
+		if err := c.BindJSON(&body); err != nil {
+			return
+		}
+		if body.Scope == "" {
+			body.Scope = store.ApiKeyScopeRead
+		}
+		if body.Name == "" || (body.Scope != store.ApiKeyScopeRead && body.Scope != store.ApiKeyScopeReadDelete) {
+			abortWithError(c, newAPIError(http.StatusBadRequest, "Anahtar adı boş olamaz, kapsam read veya read-delete olmalıdır"))
+			return
+		}
+
+		user, _ := c.Get("currentUser")
+		key := store.ApiKey{
+			Name:      body.Name,
+			UserId:    user.(userListDto).Id,
+			Scope:     body.Scope,
+			CreatedAt: time.Now().UTC(),
+		}
+		// a project key only reaches the mails of the project
+		if body.ProjectId != "" {
+			project, err := st.FindProject(c.Request.Context(), body.ProjectId)
+			if err != nil && err != store.ErrNotFound {
+				abortWithError(c, err)
+				return
+			}
+			if project != nil && !can(user.(userListDto), rbac.MailsAll) {
+				if _, ok := project.Member(user.(userListDto).Username); !ok {
+					project = nil
+				}
+			}
+			if project == nil {
+				abortWithError(c, newAPIError(http.StatusBadRequest, "Proje bulunamadı"))
+				return
+			}
+			key.ProjectId = project.Id
+			key.Inbox = project.Inbox
+		}
+
+		raw, err := randomToken()
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+		raw = apiKeyPrefix + raw
+		key.Hash = hashToken(raw)
+		key.Prefix = raw[:len(apiKeyPrefix)+6]
+		if _, err := st.InsertApiKey(c.Request.Context(), &key); err != nil {
+			abortWithError(c, err)
+			return
+		}
+
+		// the key is only shown now
+		dto := newApiKeyDto(key)
+		dto.Key = raw
+		c.JSON(http.StatusOK, gin.H{
+			"data": dto,
+		})
+	})
+	permissionUserRouter.DELETE("/api/keys/:id", func(c *gin.Context) {
+		key, err := st.FindApiKey(c.Request.Context(), c.Param("id"))
+		if err != nil && err != store.ErrNotFound {
+			abortWithError(c, err)
+			return
+		}
+		user, _ := c.Get("currentUser")
+		if key == nil || (key.UserId != user.(userListDto).Id && !can(user.(userListDto), rbac.UsersManage)) {
+			abortWithError(c, newAPIError(http.StatusNotFound, "API anahtarı bulunamadı"))
+			return
+		}
+		if err := st.DeleteApiKey(c.Request.Context(), key.Id); err != nil {
+			abortWithError(c, err)
+			return
+		}
+		auth.forget()
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Key deleted",
+		})
+	})
+
+	// smtp credentials of the applications sending to MailTracker
+	permissionSettingsRouter.GET("/api/smtp-credentials", func(c *gin.Context) {
+		credentials := []credentialDto{}
+		found, err := st.ListCredentials(c.Request.Context())
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+		for _, credential := range found {
+			credentials = append(credentials, credentialDto{
+				Id:        credential.Id,
+				Username:  credential.Username,
+				Inbox:     credential.Inbox,
+				CreatedAt: credential.CreatedAt,
+			})
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"data": credentials,
+		})
+	})
+	permissionSettingsRouter.POST("/api/smtp-credentials", func(c *gin.Context) {
+		var credential credentialDto
+		c.BindJSON(&credential)
+
+		if len(credential.Username) < 3 || credential.Inbox == "" {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"message": "Kullanıcı adı en az 3 karakter olmalıdır ve inbox boş olamaz",
+			})
+			return
+		}
+
+		if _, err := st.FindProjectByInbox(c.Request.Context(), credential.Inbox); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"message": "Inbox için proje bulunamadı",
+			})
+			return
+		}
+
+		// credentials are checked before users on smtp login, a shared name
+		// would lock the user out
+		_, credentialErr := st.FindCredentialByUsername(c.Request.Context(), credential.Username)
+		_, userErr := st.FindUserByUsername(c.Request.Context(), credential.Username)
+		if credentialErr != store.ErrNotFound || userErr != store.ErrNotFound {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"message": "Kullanıcı adı zaten kullanılıyor",
+			})
+			return
+		}
+
+		// generate a password when none is given, it is only shown now
+		if credential.Password == "" {
+			b := make([]byte, 18)
+			if _, err := crand.Read(b); err != nil {
+				abortWithError(c, err)
+				return
+			}
+			credential.Password = base64.RawURLEncoding.EncodeToString(b)
+		}
+		hashPassword, err := passwords.Hash(credential.Password)
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+
+		stored := store.SmtpCredential{
+			Username:  credential.Username,
+			Password:  hashPassword,
+			Inbox:     credential.Inbox,
+			CreatedAt: time.Now().UTC(),
+		}
+		if _, err := st.InsertCredential(c.Request.Context(), &stored); err != nil {
+			abortWithError(c, err)
+			return
+		}
+		credential.Id = stored.Id
+		credential.CreatedAt = stored.CreatedAt
+		c.JSON(http.StatusOK, gin.H{
+			"data": credential,
+		})
+	})
+	permissionSettingsRouter.DELETE("/api/smtp-credentials/:id", func(c *gin.Context) {
+		err := st.DeleteCredential(c.Request.Context(), c.Param("id"))
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Credential deleted",
+		})
+	})
+
+	// quarantine (mails the smtp server could not parse)
+
+	permissionSettingsRouter.GET("/api/quarantine", func(c *gin.Context) {
+		mails, err := st.ListQuarantined(c.Request.Context())
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+		if mails == nil {
+			mails = []store.QuarantinedMail{}
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"data": mails,
+		})
+	})
+	permissionSettingsRouter.GET("/api/quarantine/:id/raw", func(c *gin.Context) {
+		mail, err := st.FindQuarantined(c.Request.Context(), c.Param("id"))
+		if err != nil {
+			if err == store.ErrNotFound {
+				c.JSON(http.StatusNotFound, gin.H{
+					"message": "Mail not found",
+				})
+				return
+			}
+			abortWithError(c, err)
+			return
+		}
+		c.Data(http.StatusOK, "message/rfc822", mail.Raw)
+	})
+	permissionSettingsRouter.DELETE("/api/quarantine/:id", func(c *gin.Context) {
+		err := st.DeleteQuarantined(c.Request.Context(), c.Param("id"))
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Mail deleted",
+		})
+	})
+
+	// support (ticket system)
+
+	permissionTicketsRouter.DELETE("/api/tickets/:id", func(c *gin.Context) {
+		err := st.DeleteTicket(c.Request.Context(), c.Param("id"))
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Support deleted",
+		})
+	})
+	permissionTicketsRouter.PUT("/api/tickets/:id", func(c *gin.Context) {
+		var support supportDto
+		c.BindJSON(&support)
+		err := st.SetTicketStatus(c.Request.Context(), c.Param("id"), support.Status)
+		if err != nil && err != store.ErrNotFound {
+			abortWithError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Support updated",
+		})
+	})
+	permissionUserRouter.GET("/api/tickets/:id", func(c *gin.Context) {
+		username, error := c.Get("currentUserName")
+		if !error {
+			c.JSON(
+				http.StatusUnauthorized,
+				gin.H{
+					"message": "Oturum açmadınız.",
+				})
+		}
+		user, _ := c.Get("currentUser")
+		manage := can(user.(userListDto), rbac.TicketsManage)
+
+		owner := ""
+		if !manage {
+			owner = username.(string)
+		}
+
+		support, err := st.FindTicket(c.Request.Context(), c.Param("id"), owner)
+		if err != nil {
+			if err == store.ErrNotFound {
+				c.JSON(http.StatusNotFound, gin.H{
+					"message": "Support bulunamadı",
+				})
+				return
+			}
+			abortWithError(c, err)
+			return
+		}
+
+		if manage {
+			// isread and status update
+			err = st.MarkTicketRead(c.Request.Context(), support.Id)
+			if err != nil {
+				abortWithError(c, err)
+				return
+			}
+
+			if support.Status == SupportStatusOpen {
+				err = st.SetTicketStatus(c.Request.Context(), support.Id, SupportStatusInProgress)
+				if err != nil {
+					abortWithError(c, err)
+					return
+				}
+				support.Status = SupportStatusInProgress
+			}
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"data": support,
+		})
+	})
+	permissionUserRouter.POST("/api/tickets", func(c *gin.Context) {
+		username, error := c.Get("currentUserName")
+		if !error {
+			c.JSON(
+				http.StatusUnauthorized,
+				gin.H{
+					"message": "Oturum açmadınız.",
+				})
+		}
+
+		var support supportDto
+		c.BindJSON(&support)
+		support.Username = username.(string)
+		support.IsRead = 0
+		support.Status = SupportStatusOpen
+		support.CreatedAt = time.Now().UTC()
+
+		_, err := st.InsertTicket(c.Request.Context(), &support)
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Support created",
+		})
+	})
+	permissionUserRouter.GET("/api/tickets", func(c *gin.Context) {
+		username, error := c.Get("currentUserName")
+		if !error {
+			c.JSON(
+				http.StatusUnauthorized,
+				gin.H{
+					"message": "Oturum açmadınız.",
+				})
+		}
+		user, _ := c.Get("currentUser")
+		manage := can(user.(userListDto), rbac.TicketsManage)
+
+		owner := username.(string)
+		if manage {
+			owner = ""
+		}
+		page, err := parsePage(c, "createdat", "subject", "status")
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+		supports, err := st.ListTickets(c.Request.Context(), owner)
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+		unread := 0
+		for _, support := range supports {
+			if support.IsRead == 0 {
+				unread++
+			}
+		}
+		start, end := pageBounds(supports, len(supports), page, func(i, j int) bool {
+			switch page.Sort {
+			case "subject":
+				return strings.ToLower(supports[i].Subject) < strings.ToLower(supports[j].Subject)
+			case "status":
+				return supports[i].Status < supports[j].Status
+			}
+			return supports[i].CreatedAt.Before(supports[j].CreatedAt)
+		})
+		response := pageResponse(supports[start:end], page, len(supports))
+		response["unread"] = unread
+		c.JSON(http.StatusOK, response)
+	})
+
+	permissionUserRouter.GET("/api/tickets/:id/messages", func(c *gin.Context) {
+		username, error := c.Get("currentUserName")
+		if !error {
+			c.JSON(
+				http.StatusUnauthorized,
+				gin.H{
+					"message": "Oturum açmadınız.",
+				})
+		}
+		user, _ := c.Get("currentUser")
+		manage := can(user.(userListDto), rbac.TicketsManage)
+
+		ticketId := c.Param("id")
+		if !manage {
+			// check ticket owner
+			_, err := st.FindTicket(c.Request.Context(), ticketId, username.(string))
+			if err != nil {
+				if err == store.ErrNotFound {
+					c.JSON(http.StatusNotFound, gin.H{
+						"message": "Support bulunamadı",
+					})
+					return
+				}
+				abortWithError(c, err)
+				return
+			}
+		}
+
+		supportMessages, err := st.ListTicketMessages(c.Request.Context(), ticketId)
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"data": supportMessages,
+		})
+	})
+	permissionUserRouter.POST("/api/tickets/:id/messages", func(c *gin.Context) {
+		username, error := c.Get("currentUserName")
+		if !error {
+			c.JSON(
+				http.StatusUnauthorized,
+				gin.H{
+					"message": "Oturum açmadınız.",
+				})
+		}
+		user, _ := c.Get("currentUser")
+		manage := can(user.(userListDto), rbac.TicketsManage)
+
+		ticketId := c.Param("id")
+
+		if !manage {
+			// check ticket owner
+			_, err := st.FindTicket(c.Request.Context(), ticketId, username.(string))
+			if err != nil {
+				if err == store.ErrNotFound {
+					c.JSON(http.StatusNotFound, gin.H{
+						"message": "Support bulunamadı",
+					})
+					return
+				}
+				abortWithError(c, err)
+				return
+			}
+		}
+
+		var supportMessage supportMessageDto
+		c.BindJSON(&supportMessage)
+		supportMessage.Username = username.(string)
+		supportMessage.TicketId = ticketId
+		supportMessage.IsReadWatcher = 0
+		supportMessage.IsReadAdmin = 0
+		supportMessage.CreatedAt = time.Now().UTC()
+
+		_, err := st.InsertTicketMessage(c.Request.Context(), &supportMessage)
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Support message created",
+		})
+	})
+
+	return router
+}
@@ -0,0 +1,366 @@
+package api
+
+import (
+	"context"
+	crand "crypto/rand"
+	"crypto/sha256"
+	"discord-smtp-server/authn"
+	"discord-smtp-server/rbac"
+	"discord-smtp-server/store"
+	"encoding/base64"
+	"encoding/hex"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// userCacheTTL is how long a token keeps its user before it is loaded
+	// again.
+	userCacheTTL    = 30 * time.Second
+	accessTokenTTL  = 15 * time.Minute
+	refreshTokenTTL = 30 * 24 * time.Hour
+	// passwordResetTTL is how long a reset link works.
+	passwordResetTTL = time.Hour
+	// resetTimeout bounds a forgot request, which waits on the mail relay
+	// and skips the request timeout.
+	resetTimeout = 30 * time.Second
+	// passTTL is how long a pass works, it only has to reach the
+	// browser and come back.
+	passTTL = time.Minute
+)
+
+// signToken issues an access token for the user with the salt.
+func signToken(salt string) (string, error) {
+	token := jwt.New(jwt.SigningMethodHS256)
+	claims := make(jwt.MapClaims)
+	claims["exp"] = time.Now().Add(accessTokenTTL).Unix()
+	claims["iat"] = time.Now().Unix()
+	claims["sub"] = salt
+	token.Claims = claims
+	return token.SignedString([]byte(os.Getenv("JWT_SECRET")))
+}
+
+// signPass issues a pass for the user with the salt. A pass stands in
+// for the access token in the url of a route a browser loads without
+// headers, and works for that one use only, see requirePass.
+func signPass(salt, use string) (string, error) {
+	token := jwt.New(jwt.SigningMethodHS256)
+	claims := make(jwt.MapClaims)
+	claims["exp"] = time.Now().Add(passTTL).Unix()
+	claims["iat"] = time.Now().Unix()
+	claims["sub"] = salt
+	claims["use"] = use
+	token.Claims = claims
+	return token.SignedString([]byte(os.Getenv("JWT_SECRET")))
+}
+
+// streamPass is the use of a pass for the mail stream.
+const streamPass = "stream"
+
+// framePass is the use of a pass for the frame of a mail.
+func framePass(mailID string) string {
+	return "frame:" + mailID
+}
+
+type tokenPair struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refreshtoken"`
+	// ExpiresIn is the lifetime of the access token in seconds.
+	ExpiresIn int `json:"expiresin"`
+}
+
+// issueTokens starts a session for the user, only the hash of the refresh
+// token is stored.
+func issueTokens(ctx context.Context, tokens store.TokenStore, user *store.User) (tokenPair, error) {
+	access, err := signToken(user.Salt)
+	if err != nil {
+		return tokenPair{}, err
+	}
+	refresh, err := randomToken()
+	if err != nil {
+		return tokenPair{}, err
+	}
+	_, err = tokens.InsertRefreshToken(ctx, &store.RefreshToken{
+		Hash:      hashToken(refresh),
+		UserId:    user.Id,
+		ExpiresAt: time.Now().Add(refreshTokenTTL).UTC(),
+		CreatedAt: time.Now().UTC(),
+	})
+	if err != nil {
+		return tokenPair{}, err
+	}
+	return tokenPair{
+		Token:        access,
+		RefreshToken: refresh,
+		ExpiresIn:    int(accessTokenTTL / time.Second),
+	}, nil
+}
+
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := crand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// revokeSessions ends every session of a user, the access tokens with a
+// new salt and the refresh tokens, and returns the new salt.
+func revokeSessions(ctx context.Context, db store.Store, userId string) (string, error) {
+	salt, err := authn.NewSalt()
+	if err != nil {
+		return "", err
+	}
+	if err := db.UpdateUserSalt(ctx, userId, salt); err != nil {
+		return "", err
+	}
+	return salt, db.DeleteUserRefreshTokens(ctx, userId)
+}
+
+// apiKeyPrefix starts every api key, it tells keys and jwts apart.
+const apiKeyPrefix = "mt_"
+
+type cachedUser struct {
+	user    userListDto
+	key     *store.ApiKey
+	expires time.Time
+}
+
+// authorizer checks the bearer token or api key of a request and loads its
+// user, caching the user of each token subject and key for ttl.
+type authorizer struct {
+	users store.UserStore
+	keys  store.ApiKeyStore
+	roles store.RoleStore
+	ttl   time.Duration
+	now   func() time.Time
+
+	mu    sync.Mutex
+	cache map[string]cachedUser
+}
+
+func newAuthorizer(users store.UserStore, keys store.ApiKeyStore, roles store.RoleStore, ttl time.Duration) *authorizer {
+	return &authorizer{
+		users: users,
+		keys:  keys,
+		roles: roles,
+		ttl:   ttl,
+		now:   time.Now,
+		cache: map[string]cachedUser{},
+	}
+}
+
+// require lets the request through when it has a valid token of a user
+// whose role has the permission, any user when permission is empty, and
+// sets the currentUser, currentUserName and currentUserRole keys for the
+// handlers. Requests made with an api key also get currentApiKey.
+func (a *authorizer) require(permission string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, key, err := a.authenticate(c)
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+		a.admit(c, user, key, permission)
+	}
+}
+
+// requirePass is require for the routes a browser loads without headers,
+// like an iframe. They take a pass for use(c) as the pass query
+// parameter, so the access token never shows up in urls and logs.
+func (a *authorizer) requirePass(permission string, use func(c *gin.Context) string) gin.HandlerFunc {
+	headers := a.require(permission)
+	return func(c *gin.Context) {
+		raw := c.Query("pass")
+		if raw == "" {
+			headers(c)
+			return
+		}
+		token, err := parseToken(raw)
+		if err != nil {
+			abortWithError(c, newAPIError(http.StatusUnauthorized, "bad pass"))
+			return
+		}
+		claims := token.Claims.(jwt.MapClaims)
+		if got, _ := claims["use"].(string); got != use(c) {
+			abortWithError(c, newAPIError(http.StatusUnauthorized, "bad pass"))
+			return
+		}
+		salt, _ := claims["sub"].(string)
+		user, err := a.user(c.Request.Context(), salt)
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+		a.admit(c, user, nil, permission)
+	}
+}
+
+// admit lets the authenticated request through when the user has the
+// permission, see require.
+func (a *authorizer) admit(c *gin.Context, user userListDto, key *store.ApiKey, permission string) {
+	if permission != "" && !can(user, permission) {
+		abortWithError(c, newAPIError(http.StatusForbidden, "user not authorized"))
+		return
+	}
+	if key != nil {
+		if err := keyAllows(*key, permission, c.Request.Method, c.FullPath()); err != nil {
+			abortWithError(c, err)
+			return
+		}
+		c.Set("currentApiKey", *key)
+	}
+
+	c.Set("currentUser", user)
+	c.Set("currentUserName", user.Username)
+	c.Set("currentUserRole", user.Role)
+	c.Next()
+}
+
+// can tells whether the role of the user has the permission.
+func can(user userListDto, permission string) bool {
+	return rbac.Has(user.Permissions, permission)
+}
+
+// authenticate reads an api key from X-Api-Key or the bearer token, or
+// the jwt of a login.
+func (a *authorizer) authenticate(c *gin.Context) (userListDto, *store.ApiKey, error) {
+	ctx := c.Request.Context()
+	if key := c.GetHeader("X-Api-Key"); key != "" {
+		return a.keyUser(ctx, key)
+	}
+
+	jwtToken, err := extractBearerToken(c.GetHeader("Authorization"))
+	if err != nil {
+		return userListDto{}, nil, newAPIError(http.StatusUnauthorized, "bad authorization header")
+	}
+	if strings.HasPrefix(jwtToken, apiKeyPrefix) {
+		return a.keyUser(ctx, jwtToken)
+	}
+	token, err := parseToken(jwtToken)
+	if err != nil {
+		return userListDto{}, nil, newAPIError(http.StatusUnauthorized, "bad jwt token")
+	}
+	// a pass is no access token
+	if _, ok := token.Claims.(jwt.MapClaims)["use"]; ok {
+		return userListDto{}, nil, newAPIError(http.StatusUnauthorized, "bad jwt token")
+	}
+	salt, _ := token.Claims.(jwt.MapClaims)["sub"].(string)
+	user, err := a.user(ctx, salt)
+	return user, nil, err
+}
+
+// keyAllows limits api keys to the mail routes: reading, and deleting with
+// the read-delete scope. Other routes never take a key.
+func keyAllows(key store.ApiKey, permission, method, route string) error {
+	mails := permission == rbac.MailsRead || permission == rbac.MailsDelete
+	if mails && strings.HasPrefix(route, "/api/mails") {
+		switch method {
+		case http.MethodGet, http.MethodHead:
+			return nil
+		case http.MethodDelete:
+			if key.Scope == store.ApiKeyScopeReadDelete {
+				return nil
+			}
+		}
+	}
+	return newAPIError(http.StatusForbidden, "API anahtarı bu işlem için yetkili değil")
+}
+
+// cached returns the entry of name, loading it when it is missing or
+// expired.
+func (a *authorizer) cached(name string, load func() (cachedUser, error)) (cachedUser, error) {
+	a.mu.Lock()
+	entry, ok := a.cache[name]
+	a.mu.Unlock()
+	if ok && a.now().Before(entry.expires) {
+		return entry, nil
+	}
+
+	entry, err := load()
+	if err != nil {
+		return cachedUser{}, err
+	}
+	entry.expires = a.now().Add(a.ttl)
+	a.mu.Lock()
+	a.cache[name] = entry
+	a.mu.Unlock()
+	return entry, nil
+}
+
+func (a *authorizer) user(ctx context.Context, salt string) (userListDto, error) {
+	if salt == "" {
+		return userListDto{}, newAPIError(http.StatusUnauthorized, "Kullanıcı bulunamadı")
+	}
+	entry, err := a.cached("sub:"+salt, func() (cachedUser, error) {
+		found, err := a.users.FindUserBySalt(ctx, salt)
+		if err == store.ErrNotFound {
+			return cachedUser{}, newAPIError(http.StatusUnauthorized, "Kullanıcı bulunamadı")
+		}
+		if err != nil {
+			return cachedUser{}, err
+		}
+		user, err := a.withPermissions(ctx, *found)
+		return cachedUser{user: user}, err
+	})
+	return entry.user, err
+}
+
+func (a *authorizer) keyUser(ctx context.Context, raw string) (userListDto, *store.ApiKey, error) {
+	hash := hashToken(raw)
+	entry, err := a.cached("key:"+hash, func() (cachedUser, error) {
+		invalid := newAPIError(http.StatusUnauthorized, "Geçersiz API anahtarı")
+		key, err := a.keys.FindApiKeyByHash(ctx, hash)
+		if err == store.ErrNotFound {
+			return cachedUser{}, invalid
+		}
+		if err != nil {
+			return cachedUser{}, err
+		}
+		found, err := a.users.FindUser(ctx, key.UserId)
+		if err == store.ErrNotFound {
+			return cachedUser{}, invalid
+		}
+		if err != nil {
+			return cachedUser{}, err
+		}
+
+		// the last use is recorded when the key is loaded, so at most once
+		// per ttl
+		key.LastUsedAt = a.now().UTC()
+		if err := a.keys.TouchApiKey(ctx, key.Id, key.LastUsedAt); err != nil {
+			log.Println("Recording api key use failed:", err)
+		}
+		user, err := a.withPermissions(ctx, *found)
+		return cachedUser{user: user, key: key}, err
+	})
+	return entry.user, entry.key, err
+}
+
+// withPermissions returns the dto of a user with the permissions of their
+// role.
+func (a *authorizer) withPermissions(ctx context.Context, found store.User) (userListDto, error) {
+	user := newUserListDto(found)
+	permissions, err := rbac.Resolve(ctx, a.roles, found.Role)
+	user.Permissions = permissions
+	return user, err
+}
+
+// forget drops the cached users, called when a user, role or key changes
+// so new permissions or a deleted account apply to the next request.
+func (a *authorizer) forget() {
+	a.mu.Lock()
+	a.cache = map[string]cachedUser{}
+	a.mu.Unlock()
+}
@@ -0,0 +1,979 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"discord-smtp-server/attachment"
+	"discord-smtp-server/authn"
+	"discord-smtp-server/events"
+	"discord-smtp-server/mailer"
+	"discord-smtp-server/oidc"
+	"discord-smtp-server/password"
+	"discord-smtp-server/rbac"
+	"discord-smtp-server/smtp"
+	"discord-smtp-server/store"
+	gosmtp "github.com/emersion/go-smtp"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestMain(m *testing.M) {
+	// the router loads its templates relative to the repository root
+	if err := os.Chdir(".."); err != nil {
+		panic(err)
+	}
+	os.Setenv("JWT_SECRET", "test-secret")
+	gin.SetMode(gin.TestMode)
+	os.Exit(m.Run())
+}
+
+// newTestRouter returns a router on a memory store with an admin and a
+// watcher, and their tokens.
+func newTestRouter(t *testing.T) (*gin.Engine, *store.Memory, map[string]string) {
+	t.Helper()
+	return newTestRouterOn(t, events.NewBus())
+}
+
+func newTestRouterOn(t *testing.T, bus *events.Bus) (*gin.Engine, *store.Memory, map[string]string) {
+	t.Helper()
+	return newTestRouterWith(t, bus, nil, nil)
+}
+
+func newTestRouterWith(t *testing.T, bus *events.Bus, sso *oidc.Provider, logins authn.Authenticator) (*gin.Engine, *store.Memory, map[string]string) {
+	t.Helper()
+	db := store.NewMemory()
+	attachments, err := attachment.NewDir(t.TempDir())
+	if err != nil {
+		t.Fatalf("attachment.NewDir() error = %v", err)
+	}
+
+	tokens := map[string]string{}
+	for _, user := range []store.User{
+		{Username: "admin", Role: "admin", Salt: "admin-salt"},
+		{Username: "watcher", Role: "watcher", Salt: "watcher-salt"},
+	} {
+		if _, err := db.InsertUser(context.Background(), &user); err != nil {
+			t.Fatalf("InsertUser() error = %v", err)
+		}
+		token, err := signToken(user.Salt)
+		if err != nil {
+			t.Fatalf("signToken() error = %v", err)
+		}
+		tokens[user.Username] = token
+	}
+	passwords, err := password.New(password.Config{BcryptCost: bcrypt.MinCost})
+	if err != nil {
+		t.Fatalf("password.New() error = %v", err)
+	}
+	return NewRouter(db, attachments, bus, passwords, &mailer.Capture{Mails: db, Events: bus}, sso, logins), db, tokens
+}
+
+func serve(router *gin.Engine, method, path, token string) *httptest.ResponseRecorder {
+	return serveJSON(router, method, path, token, nil)
+}
+
+func serveJSON(router *gin.Engine, method, path, token string, body interface{}) *httptest.ResponseRecorder {
+	var r io.Reader
+	if body != nil {
+		b, _ := json.Marshal(body)
+		r = bytes.NewReader(b)
+	}
+	req := httptest.NewRequest(method, path, r)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestAuthorizer_require(t *testing.T) {
+	router, db, tokens := newTestRouter(t)
+	ctx := context.Background()
+	unknown, _ := signToken("deleted-salt")
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		token      string
+		wantStatus int
+	}{
+		{"No token", http.MethodGet, "/api/mails", "", http.StatusUnauthorized},
+		{"Bad token", http.MethodGet, "/api/mails", "not-a-jwt", http.StatusUnauthorized},
+		{"Unknown user", http.MethodGet, "/api/mails", unknown, http.StatusUnauthorized},
+		{"Watcher lists mails", http.MethodGet, "/api/mails", tokens["watcher"], http.StatusOK},
+		{"Watcher lists users", http.MethodGet, "/api/users", tokens["watcher"], http.StatusForbidden},
+		{"Watcher deletes a user", http.MethodDelete, "/api/users/1", tokens["watcher"], http.StatusForbidden},
+		{"Watcher creates a user", http.MethodPost, "/api/users", tokens["watcher"], http.StatusForbidden},
+		{"Watcher deletes a project", http.MethodDelete, "/api/projects/1", tokens["watcher"], http.StatusForbidden},
+		{"Admin lists users", http.MethodGet, "/api/users", tokens["admin"], http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := serve(router, tt.method, tt.path, tt.token); w.Code != tt.wantStatus {
+				t.Errorf("%s %s = %d %s, want %d", tt.method, tt.path, w.Code, w.Body, tt.wantStatus)
+			}
+		})
+	}
+
+	// refused requests never reach the handler
+	users, _ := db.ListUsers(ctx)
+	if len(users) != 2 {
+		t.Errorf("users = %v, want the admin and the watcher", users)
+	}
+}
+
+func TestDeleteAllMails_watcher(t *testing.T) {
+	router, db, tokens := newTestRouter(t)
+	ctx := context.Background()
+	db.InsertProject(ctx, &store.Project{Name: "Shop", Inbox: "shop", Members: []store.ProjectMember{{Username: "watcher", Role: store.ProjectRoleViewer}}})
+	db.InsertProject(ctx, &store.Project{Name: "Blog", Inbox: "blog"})
+	for _, inbox := range []string{"shop", "blog", ""} {
+		db.InsertMail(ctx, &store.Mail{Subject: inbox, Inbox: inbox})
+	}
+
+	// a viewer can not delete the mails of their project, nor of others
+	if w := serve(router, http.MethodDelete, "/api/mails", tokens["watcher"]); w.Code != http.StatusOK {
+		t.Fatalf("DELETE /api/mails = %d %s", w.Code, w.Body)
+	}
+	if mails, _ := db.ListMails(ctx, store.MailFilter{}); len(mails) != 3 {
+		t.Errorf("watcher deleted mails, %d left", len(mails))
+	}
+
+	if w := serve(router, http.MethodDelete, "/api/mails", tokens["admin"]); w.Code != http.StatusOK {
+		t.Fatalf("DELETE /api/mails = %d %s", w.Code, w.Body)
+	}
+	if mails, _ := db.ListMails(ctx, store.MailFilter{}); len(mails) != 0 {
+		t.Errorf("admin left %d mails", len(mails))
+	}
+}
+
+func TestListMails_userInbox(t *testing.T) {
+	router, db, tokens := newTestRouter(t)
+	ctx := context.Background()
+	if w := serveJSON(router, http.MethodPost, "/api/users", tokens["admin"], gin.H{"username": "ayse", "password": "Secret1!", "role": "watcher"}); w.Code != http.StatusOK {
+		t.Fatalf("POST /api/users = %d %s", w.Code, w.Body)
+	}
+	attachments, _ := attachment.NewDir(t.TempDir())
+	backend, err := smtp.NewBackend(smtp.Config{Mails: db, Users: db, Projects: db, Roles: db, Attachments: attachments})
+	if err != nil {
+		t.Fatalf("smtp.NewBackend() error = %v", err)
+	}
+
+	// ayse sends with her own credentials and finds the mail
+	session, err := backend.Login(nil, "ayse", "Secret1!")
+	if err != nil {
+		t.Fatalf("Backend.Login() error = %v", err)
+	}
+	session.Mail("app@example.com", gosmtp.MailOptions{})
+	session.Rcpt("mehmet@example.com")
+	if err := session.Data(strings.NewReader("Subject: Welcome\r\n\r\nhi\r\n")); err != nil {
+		t.Fatalf("Session.Data() error = %v", err)
+	}
+	mails, _ := db.ListMails(ctx, store.MailFilter{})
+	if len(mails) != 1 {
+		t.Fatalf("%d mails stored, want 1", len(mails))
+	}
+
+	user, _ := db.FindUserByUsername(ctx, "ayse")
+	ayse, _ := signToken(user.Salt)
+	tests := []struct {
+		name      string
+		token     string
+		wantMails int
+	}{
+		{"Sender", ayse, 1},
+		{"Another watcher", tokens["watcher"], 0},
+		{"Admin", tokens["admin"], 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(router, http.MethodGet, "/api/mails", tt.token)
+			var list struct {
+				Data []mailListDto `json:"data"`
+			}
+			json.Unmarshal(w.Body.Bytes(), &list)
+			if w.Code != http.StatusOK || len(list.Data) != tt.wantMails {
+				t.Errorf("GET /api/mails = %d %s, want %d mails", w.Code, w.Body, tt.wantMails)
+			}
+			wantStatus := http.StatusNotFound
+			if tt.wantMails > 0 {
+				wantStatus = http.StatusOK
+			}
+			if w := serve(router, http.MethodGet, "/api/mails/"+mails[0].Id, tt.token); w.Code != wantStatus {
+				t.Errorf("GET /api/mails/:id = %d, want %d", w.Code, wantStatus)
+			}
+		})
+	}
+}
+
+func TestIndex(t *testing.T) {
+	t.Setenv("SMTP_USERNAME", "demo")
+	t.Setenv("SMTP_PASSWORD", "smtp-secret")
+	router, _, _ := newTestRouter(t)
+
+	// the page is public and must not hand out the smtp password
+	w := serve(router, http.MethodGet, "/", "")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "demo") {
+		t.Fatalf("GET / = %d", w.Code)
+	}
+	if strings.Contains(w.Body.String(), "smtp-secret") {
+		t.Errorf("GET / shows SMTP_PASSWORD")
+	}
+}
+
+func TestMailStream(t *testing.T) {
+	router, _, tokens := newTestRouter(t)
+	w := serveJSON(router, http.MethodPost, "/api/mails/passes", tokens["watcher"], gin.H{"use": "stream"})
+	var body struct {
+		Data struct {
+			Pass string `json:"pass"`
+		} `json:"data"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &body)
+	if w.Code != http.StatusOK || body.Data.Pass == "" {
+		t.Fatalf("POST /api/mails/passes = %d %s", w.Code, w.Body)
+	}
+	frame, _ := signPass("watcher-salt", framePass("mail"))
+
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int
+	}{
+		{"Stream pass", "?pass=" + body.Data.Pass, http.StatusOK},
+		{"Frame pass", "?pass=" + frame, http.StatusUnauthorized},
+		{"Access token in the query", "?token=" + tokens["watcher"], http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// the stream runs until the client goes away
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			req := httptest.NewRequest(http.MethodGet, "/api/mails/stream"+tt.query, nil).WithContext(ctx)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			if w.Code != tt.wantStatus {
+				t.Errorf("GET /api/mails/stream%s = %d %s, want %d", tt.query, w.Code, w.Body, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestMailFrame(t *testing.T) {
+	router, db, tokens := newTestRouter(t)
+	ctx := context.Background()
+	db.InsertProject(ctx, &store.Project{Name: "Shop", Inbox: "shop", Members: []store.ProjectMember{{Username: "watcher", Role: store.ProjectRoleViewer}}})
+	shopMail := &store.Mail{Subject: "Order", Inbox: "shop", Html: "<html><body><p>Order</p></body></html>"}
+	blogMail := &store.Mail{Subject: "Post", Inbox: "blog", Html: "<html><body><p>Post</p></body></html>"}
+	db.InsertMail(ctx, shopMail)
+	db.InsertMail(ctx, blogMail)
+
+	pass := func(token, mailID string) (string, int) {
+		w := serveJSON(router, http.MethodPost, "/api/mails/passes", token, gin.H{"use": "frame", "mailid": mailID})
+		var body struct {
+			Data struct {
+				Pass string `json:"pass"`
+			} `json:"data"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &body)
+		return body.Data.Pass, w.Code
+	}
+	shopPass, code := pass(tokens["watcher"], shopMail.Id)
+	if code != http.StatusOK || shopPass == "" {
+		t.Fatalf("POST /api/mails/passes = %d", code)
+	}
+	if _, code := pass(tokens["watcher"], blogMail.Id); code != http.StatusNotFound {
+		t.Errorf("pass for a mail of another project = %d, want 404", code)
+	}
+	if w := serveJSON(router, http.MethodPost, "/api/mails/passes", tokens["watcher"], gin.H{"use": "admin"}); w.Code != http.StatusBadRequest {
+		t.Errorf("pass of an unknown use = %d, want 400", w.Code)
+	}
+
+	tests := []struct {
+		name       string
+		path       string
+		token      string
+		wantStatus int
+	}{
+		{"Without a pass", "/iframe/mails/" + shopMail.Id, "", http.StatusUnauthorized},
+		{"Pass", "/iframe/mails/" + shopMail.Id + "?pass=" + shopPass, "", http.StatusOK},
+		{"Pass of another mail", "/iframe/mails/" + blogMail.Id + "?pass=" + shopPass, tokens["admin"], http.StatusUnauthorized},
+		{"Access token as a pass", "/iframe/mails/" + shopMail.Id + "?pass=" + tokens["watcher"], "", http.StatusUnauthorized},
+		{"Access token in the query", "/iframe/mails/" + shopMail.Id + "?token=" + tokens["watcher"], "", http.StatusUnauthorized},
+		{"Pass as an access token", "/api/mails", shopPass, http.StatusUnauthorized},
+		{"Mail of another project", "/iframe/mails/" + blogMail.Id, tokens["watcher"], http.StatusNotFound},
+		{"Admin", "/iframe/mails/" + blogMail.Id + "?json=true", tokens["admin"], http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(router, http.MethodGet, tt.path, tt.token)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("GET %s = %d %s, want %d", tt.path, w.Code, w.Body, tt.wantStatus)
+			}
+			if strings.HasPrefix(tt.path, "/iframe/") && w.Code == http.StatusOK && w.Header().Get("Referrer-Policy") != "no-referrer" {
+				t.Errorf("Referrer-Policy = %q", w.Header().Get("Referrer-Policy"))
+			}
+		})
+	}
+}
+
+func TestAuthorizer_user(t *testing.T) {
+	db := store.NewMemory()
+	ctx := context.Background()
+	user := store.User{Username: "watcher", Role: "watcher", Salt: "salt"}
+	db.InsertUser(ctx, &user)
+
+	now := time.Now()
+	a := newAuthorizer(db, db, db, time.Minute)
+	a.now = func() time.Time { return now }
+
+	role := func() string {
+		got, err := a.user(ctx, "salt")
+		if err != nil {
+			t.Fatalf("authorizer.user() error = %v", err)
+		}
+		return got.Role
+	}
+	if got := role(); got != "watcher" {
+		t.Fatalf("role = %q, want watcher", got)
+	}
+
+	user.Role = "admin"
+	db.UpdateUser(ctx, &user)
+	if got := role(); got != "watcher" {
+		t.Errorf("role = %q, want the cached watcher", got)
+	}
+	now = now.Add(2 * time.Minute)
+	if got := role(); got != "admin" {
+		t.Errorf("role = %q after expiry, want admin", got)
+	}
+
+	user.Role = "watcher"
+	db.UpdateUser(ctx, &user)
+	a.forget()
+	if got := role(); got != "watcher" {
+		t.Errorf("role = %q after forget, want watcher", got)
+	}
+
+	if _, err := a.user(ctx, ""); err == nil {
+		t.Errorf("authorizer.user() without a subject succeeded")
+	}
+}
+
+type authenticatorFunc func(username, password string) (*store.User, error)
+
+func (f authenticatorFunc) Authenticate(ctx context.Context, username, password string) (*store.User, error) {
+	return f(username, password)
+}
+
+func TestLogin_authenticator(t *testing.T) {
+	errs := map[string]error{
+		"wrong":   authn.ErrInvalidCredentials,
+		"norole":  authn.ErrNoRole,
+		"taken":   authn.ErrUsernameTaken,
+		"offline": errors.New("directory is down"),
+	}
+	logins := authenticatorFunc(func(username, password string) (*store.User, error) {
+		if err := errs[password]; err != nil {
+			return nil, err
+		}
+		return &store.User{Username: username, Role: "watcher", Salt: username + "-salt"}, nil
+	})
+	router, _, _ := newTestRouterWith(t, events.NewBus(), nil, logins)
+
+	tests := []struct {
+		name       string
+		password   string
+		wantStatus int
+	}{
+		{"Accepted", "directory-secret", http.StatusOK},
+		{"Wrong password", "wrong", http.StatusUnauthorized},
+		{"No role", "norole", http.StatusForbidden},
+		{"Username taken", "taken", http.StatusConflict},
+		{"Directory down", "offline", http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serveJSON(router, http.MethodPost, "/api/login", "", gin.H{"username": "mehmet", "password": tt.password})
+			if w.Code != tt.wantStatus {
+				t.Errorf("login = %d %s, want %d", w.Code, w.Body, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestSessions(t *testing.T) {
+	router, db, tokens := newTestRouter(t)
+	ctx := context.Background()
+	hash, _ := bcrypt.GenerateFromPassword([]byte("Secret1!"), bcrypt.MinCost)
+	user := store.User{Username: "ayse", Role: "watcher", Salt: "ayse-salt", Password: string(hash)}
+	db.InsertUser(ctx, &user)
+
+	login := func() tokenPair {
+		t.Helper()
+		w := serveJSON(router, http.MethodPost, "/api/login", "", gin.H{"username": "ayse", "password": "Secret1!"})
+		var body struct {
+			Data struct {
+				tokenPair
+				User map[string]interface{} `json:"user"`
+			} `json:"data"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &body)
+		if w.Code != http.StatusOK || body.Data.Token == "" || body.Data.RefreshToken == "" {
+			t.Fatalf("login = %d %s", w.Code, w.Body)
+		}
+		if _, ok := body.Data.User["password"]; ok {
+			t.Errorf("login returned the password hash")
+		}
+		return body.Data.tokenPair
+	}
+	refresh := func(token string) (tokenPair, int) {
+		w := serveJSON(router, http.MethodPost, "/api/token/refresh", "", gin.H{"refreshtoken": token})
+		var body struct {
+			Data tokenPair `json:"data"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &body)
+		return body.Data, w.Code
+	}
+
+	first := login()
+	if first.ExpiresIn != int(accessTokenTTL/time.Second) {
+		t.Errorf("expiresin = %d", first.ExpiresIn)
+	}
+
+	// refresh tokens are single use
+	second, code := refresh(first.RefreshToken)
+	if code != http.StatusOK || second.RefreshToken == first.RefreshToken {
+		t.Fatalf("refresh = %d %+v", code, second)
+	}
+	if _, code := refresh(first.RefreshToken); code != http.StatusUnauthorized {
+		t.Errorf("reused refresh token = %d, want 401", code)
+	}
+	if w := serve(router, http.MethodGet, "/api/users/me", second.Token); w.Code != http.StatusOK {
+		t.Errorf("refreshed access token = %d", w.Code)
+	}
+
+	// logout ends the session
+	serveJSON(router, http.MethodPost, "/api/logout", "", gin.H{"refreshtoken": second.RefreshToken})
+	if _, code := refresh(second.RefreshToken); code != http.StatusUnauthorized {
+		t.Errorf("refresh after logout = %d, want 401", code)
+	}
+
+	// expired sessions are refused
+	session, _ := db.FindRefreshToken(ctx, hashToken(login().RefreshToken))
+	db.DeleteRefreshToken(ctx, session.Id)
+	expired, _ := randomToken()
+	db.InsertRefreshToken(ctx, &store.RefreshToken{Hash: hashToken(expired), UserId: user.Id, ExpiresAt: time.Now().Add(-time.Minute)})
+	if _, code := refresh(expired); code != http.StatusUnauthorized {
+		t.Errorf("expired refresh token = %d, want 401", code)
+	}
+
+	// an admin revokes every session, access tokens included
+	third := login()
+	if w := serve(router, http.MethodDelete, "/api/users/"+user.Id+"/sessions", tokens["watcher"]); w.Code != http.StatusForbidden {
+		t.Errorf("watcher revoking sessions = %d, want 403", w.Code)
+	}
+	if w := serve(router, http.MethodDelete, "/api/users/"+user.Id+"/sessions", tokens["admin"]); w.Code != http.StatusOK {
+		t.Fatalf("revoking sessions = %d %s", w.Code, w.Body)
+	}
+	if w := serve(router, http.MethodGet, "/api/users/me", third.Token); w.Code != http.StatusUnauthorized {
+		t.Errorf("revoked access token = %d, want 401", w.Code)
+	}
+	if _, code := refresh(third.RefreshToken); code != http.StatusUnauthorized {
+		t.Errorf("revoked refresh token = %d, want 401", code)
+	}
+
+	// a password set by an admin ends the sessions as well, and a new
+	// name keeps the projects of the user
+	shop := store.Project{Name: "Shop", Inbox: "shop", Members: []store.ProjectMember{{Username: "ayse", Role: store.ProjectRoleViewer}}}
+	db.InsertProject(ctx, &shop)
+	fourth := login()
+	w := serveJSON(router, http.MethodPut, "/api/users/"+user.Id, tokens["admin"], gin.H{"username": "ayse.k", "role": "watcher", "password": "Secret2!"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("PUT /api/users/:id = %d %s", w.Code, w.Body)
+	}
+	if w := serve(router, http.MethodGet, "/api/users/me", fourth.Token); w.Code != http.StatusUnauthorized {
+		t.Errorf("access token after a new password = %d, want 401", w.Code)
+	}
+	if _, code := refresh(fourth.RefreshToken); code != http.StatusUnauthorized {
+		t.Errorf("refresh token after a new password = %d, want 401", code)
+	}
+	if project, _ := db.FindProject(ctx, shop.Id); project.Members[0].Username != "ayse.k" {
+		t.Errorf("project members after the rename = %+v", project.Members)
+	}
+}
+
+func TestApiKeys(t *testing.T) {
+	router, db, tokens := newTestRouter(t)
+	ctx := context.Background()
+	shop := store.Project{Name: "Shop", Inbox: "shop", Members: []store.ProjectMember{{Username: "watcher", Role: store.ProjectRoleMaintainer}}}
+	db.InsertProject(ctx, &shop)
+	db.InsertProject(ctx, &store.Project{Name: "Blog", Inbox: "blog", Members: []store.ProjectMember{{Username: "watcher", Role: store.ProjectRoleMaintainer}}})
+	shopMail := store.Mail{Subject: "order", Inbox: "shop"}
+	db.InsertMail(ctx, &shopMail)
+	blogMail := store.Mail{Subject: "comment", Inbox: "blog"}
+	db.InsertMail(ctx, &blogMail)
+
+	create := func(token string, body gin.H) apiKeyDto {
+		t.Helper()
+		w := serveJSON(router, http.MethodPost, "/api/keys", token, body)
+		var resp struct {
+			Data apiKeyDto `json:"data"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		if w.Code != http.StatusOK || resp.Data.Key == "" {
+			t.Fatalf("POST /api/keys = %d %s", w.Code, w.Body)
+		}
+		return resp.Data
+	}
+	read := create(tokens["watcher"], gin.H{"name": "e2e", "projectid": shop.Id})
+	cleanup := create(tokens["watcher"], gin.H{"name": "cleanup", "scope": store.ApiKeyScopeReadDelete})
+	admin := create(tokens["admin"], gin.H{"name": "admin"})
+
+	if w := serveJSON(router, http.MethodPost, "/api/keys", tokens["watcher"], gin.H{"name": "bad", "scope": "write"}); w.Code != http.StatusBadRequest {
+		t.Errorf("POST /api/keys with an unknown scope = %d", w.Code)
+	}
+	if w := serveJSON(router, http.MethodPost, "/api/keys", tokens["watcher"], gin.H{"name": "bad", "projectid": "missing"}); w.Code != http.StatusBadRequest {
+		t.Errorf("POST /api/keys with an unknown project = %d", w.Code)
+	}
+
+	// a project key lists the mails of its project only
+	w := serve(router, http.MethodGet, "/api/mails", read.Key)
+	var list struct {
+		Data []mailListDto `json:"data"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &list)
+	if w.Code != http.StatusOK || len(list.Data) != 1 || list.Data[0].Id != shopMail.Id {
+		t.Errorf("GET /api/mails with a project key = %d %s", w.Code, w.Body)
+	}
+	if w := serve(router, http.MethodGet, "/api/mails/"+blogMail.Id, read.Key); w.Code != http.StatusNotFound {
+		t.Errorf("GET of a mail outside the key's project = %d, want 404", w.Code)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/mails/"+shopMail.Id, nil)
+	req.Header.Set("X-Api-Key", read.Key)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("GET with X-Api-Key = %d %s", rec.Code, rec.Body)
+	}
+	// a read key leaves the mail unread, its user marks it read
+	if mail, _ := db.FindMail(ctx, shopMail.Id); mail.IsRead != 0 {
+		t.Errorf("mail fetched with a read key has IsRead = %d, want 0", mail.IsRead)
+	}
+	serve(router, http.MethodGet, "/api/mails/"+shopMail.Id, tokens["watcher"])
+	if mail, _ := db.FindMail(ctx, shopMail.Id); mail.IsRead != 1 {
+		t.Errorf("mail fetched by its user has IsRead = %d, want 1", mail.IsRead)
+	}
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		key        string
+		wantStatus int
+	}{
+		{"Read key deletes", http.MethodDelete, "/api/mails/" + shopMail.Id, read.Key, http.StatusForbidden},
+		{"Key creates a key", http.MethodPost, "/api/keys", cleanup.Key, http.StatusForbidden},
+		{"Key deletes a key", http.MethodDelete, "/api/keys/" + read.Id, cleanup.Key, http.StatusForbidden},
+		{"Admin key on an admin route", http.MethodGet, "/api/users", admin.Key, http.StatusForbidden},
+		{"Unknown key", http.MethodGet, "/api/mails", "mt_unknown", http.StatusUnauthorized},
+		{"Read-delete key deletes", http.MethodDelete, "/api/mails/" + shopMail.Id, cleanup.Key, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := serve(router, tt.method, tt.path, tt.key); w.Code != tt.wantStatus {
+				t.Errorf("%s %s = %d %s, want %d", tt.method, tt.path, w.Code, w.Body, tt.wantStatus)
+			}
+		})
+	}
+
+	key, _ := db.FindApiKey(ctx, read.Id)
+	if key.LastUsedAt.IsZero() || key.Hash == read.Key {
+		t.Errorf("stored key = %+v", key)
+	}
+
+	// other users can not delete the key, its owner can
+	if w := serve(router, http.MethodDelete, "/api/keys/"+admin.Id, tokens["watcher"]); w.Code != http.StatusNotFound {
+		t.Errorf("deleting another user's key = %d, want 404", w.Code)
+	}
+	if w := serve(router, http.MethodDelete, "/api/keys/"+read.Id, tokens["watcher"]); w.Code != http.StatusOK {
+		t.Fatalf("DELETE /api/keys = %d %s", w.Code, w.Body)
+	}
+	if w := serve(router, http.MethodGet, "/api/mails", read.Key); w.Code != http.StatusUnauthorized {
+		t.Errorf("deleted key = %d, want 401", w.Code)
+	}
+}
+
+func TestWebhookSecrets(t *testing.T) {
+	router, db, tokens := newTestRouter(t)
+	ctx := context.Background()
+	hook := gin.H{"kind": "webhook", "url": "https://hooks.example.com/mail", "secret": "hook-secret"}
+
+	// the secret comes back once, when it is set
+	w := serveJSON(router, http.MethodPut, "/api/users/me/webhooks", tokens["watcher"], gin.H{"webhooks": []gin.H{hook}})
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "hook-secret") {
+		t.Fatalf("PUT /api/users/me/webhooks = %d %s", w.Code, w.Body)
+	}
+	watcher, _ := db.FindUserByUsername(ctx, "watcher")
+	for _, path := range []string{"/api/users", "/api/users/" + watcher.Id} {
+		if w := serve(router, http.MethodGet, path, tokens["admin"]); w.Code != http.StatusOK || strings.Contains(w.Body.String(), "hook-secret") {
+			t.Errorf("GET %s = %d %s, want no secret", path, w.Code, w.Body)
+		}
+	}
+	if w := serve(router, http.MethodGet, "/api/users/me", tokens["watcher"]); strings.Contains(w.Body.String(), "hook-secret") {
+		t.Errorf("GET /api/users/me shows the secret: %s", w.Body)
+	}
+
+	// a webhook sent again without its secret keeps it
+	delete(hook, "secret")
+	serveJSON(router, http.MethodPut, "/api/users/me/webhooks", tokens["watcher"], gin.H{"webhooks": []gin.H{hook}})
+	serveJSON(router, http.MethodPut, "/api/users/"+watcher.Id, tokens["admin"], gin.H{"username": "watcher", "role": "watcher", "webhooks": []gin.H{hook}})
+	if user, _ := db.FindUser(ctx, watcher.Id); len(user.Webhooks) != 1 || user.Webhooks[0].Secret != "hook-secret" {
+		t.Errorf("stored webhooks = %+v", user.Webhooks)
+	}
+}
+
+func TestPasswords(t *testing.T) {
+	router, db, tokens := newTestRouter(t)
+	ctx := context.Background()
+	login := func(username, pass string) int {
+		return serveJSON(router, http.MethodPost, "/api/login", "", gin.H{"username": username, "password": pass}).Code
+	}
+
+	w := serveJSON(router, http.MethodPost, "/api/users", tokens["admin"], gin.H{"username": "ayse", "password": "secret", "role": "watcher"})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("weak password = %d, want 400", w.Code)
+	}
+	w = serveJSON(router, http.MethodPost, "/api/users", tokens["admin"], gin.H{"username": "ayse", "password": "Secret1!", "role": "watcher"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("POST /api/users = %d %s", w.Code, w.Body)
+	}
+	if code := login("ayse", "Secret1!"); code != http.StatusOK {
+		t.Errorf("login of a new user = %d", code)
+	}
+
+	// a changed password has to work, it used to be stored as a byte count
+	user, _ := db.FindUserByUsername(ctx, "ayse")
+	w = serveJSON(router, http.MethodPut, "/api/users/"+user.Id, tokens["admin"], gin.H{"username": "ayse", "password": "Changed2?", "role": "watcher"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("PUT /api/users/:id = %d %s", w.Code, w.Body)
+	}
+	if code := login("ayse", "Changed2?"); code != http.StatusOK {
+		t.Errorf("login after a password change = %d", code)
+	}
+	if code := login("ayse", "Secret1!"); code != http.StatusUnauthorized {
+		t.Errorf("login with the old password = %d, want 401", code)
+	}
+
+	// hashes of another cost are replaced on login
+	hash, _ := bcrypt.GenerateFromPassword([]byte("Changed2?"), bcrypt.MinCost+1)
+	db.UpdateUserPassword(ctx, user.Id, string(hash))
+	if code := login("ayse", "Changed2?"); code != http.StatusOK {
+		t.Errorf("login with an old hash = %d", code)
+	}
+	if user, _ := db.FindUser(ctx, user.Id); user.Password == string(hash) {
+		t.Errorf("login did not rehash the password")
+	} else if cost, _ := bcrypt.Cost([]byte(user.Password)); cost != bcrypt.MinCost {
+		t.Errorf("rehashed cost = %d, want %d", cost, bcrypt.MinCost)
+	}
+
+	db.UpdateUserPassword(ctx, user.Id, "9")
+	if code := login("ayse", "Changed2?"); code != http.StatusUnauthorized {
+		t.Errorf("login with a broken hash = %d, want 401", code)
+	}
+}
+
+func TestUpdateMe(t *testing.T) {
+	router, db, tokens := newTestRouter(t)
+	ctx := context.Background()
+	w := serveJSON(router, http.MethodPost, "/api/users", tokens["admin"], gin.H{"username": "ayse", "password": "Secret1!", "role": "watcher"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("POST /api/users = %d %s", w.Code, w.Body)
+	}
+	w = serveJSON(router, http.MethodPost, "/api/login", "", gin.H{"username": "ayse", "password": "Secret1!"})
+	var login struct {
+		Data struct {
+			Token        string `json:"token"`
+			RefreshToken string `json:"refreshtoken"`
+		} `json:"data"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &login)
+
+	tests := []struct {
+		name       string
+		body       gin.H
+		wantStatus int
+	}{
+		{"Invalid email", gin.H{"emails": []string{"not an address"}}, http.StatusBadRequest},
+		{"Wrong current password", gin.H{"currentpassword": "Wrong1!?", "password": "Changed2?"}, http.StatusForbidden},
+		{"Weak password", gin.H{"currentpassword": "Secret1!", "password": "changed"}, http.StatusBadRequest},
+		{"Emails", gin.H{"emails": []string{"ayse@example.com"}}, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := serveJSON(router, http.MethodPut, "/api/users/me", login.Data.Token, tt.body); w.Code != tt.wantStatus {
+				t.Errorf("PUT /api/users/me = %d %s, want %d", w.Code, w.Body, tt.wantStatus)
+			}
+		})
+	}
+	if user, _ := db.FindUserByUsername(ctx, "ayse"); len(user.Emails) != 1 || user.Role != "watcher" {
+		t.Errorf("updated user = %+v", user)
+	}
+
+	// the password change ends the old sessions and returns new tokens
+	w = serveJSON(router, http.MethodPut, "/api/users/me", login.Data.Token, gin.H{"currentpassword": "Secret1!", "password": "Changed2?"})
+	var changed struct {
+		Data struct {
+			Token string `json:"token"`
+		} `json:"data"`
+	}
+	if json.Unmarshal(w.Body.Bytes(), &changed); w.Code != http.StatusOK || changed.Data.Token == "" {
+		t.Fatalf("password change = %d %s", w.Code, w.Body)
+	}
+	if w := serve(router, http.MethodGet, "/api/users/me", login.Data.Token); w.Code != http.StatusUnauthorized {
+		t.Errorf("old token after a password change = %d, want 401", w.Code)
+	}
+	if w := serveJSON(router, http.MethodPost, "/api/token/refresh", "", gin.H{"refreshtoken": login.Data.RefreshToken}); w.Code != http.StatusUnauthorized {
+		t.Errorf("old refresh token after a password change = %d, want 401", w.Code)
+	}
+	if w := serve(router, http.MethodGet, "/api/users/me", changed.Data.Token); w.Code != http.StatusOK {
+		t.Errorf("new token = %d", w.Code)
+	}
+	if w := serveJSON(router, http.MethodPost, "/api/login", "", gin.H{"username": "ayse", "password": "Changed2?"}); w.Code != http.StatusOK {
+		t.Errorf("login with the new password = %d", w.Code)
+	}
+
+	// directory and sign-on users have no password here to change
+	for _, user := range []store.User{
+		{Username: "mehmet", Role: "watcher", Salt: "mehmet-salt", Subject: authn.LDAPSubject + "uid=mehmet,ou=people,dc=example,dc=com"},
+		{Username: "zeynep", Role: "watcher", Salt: "zeynep-salt", Subject: "oidc-subject"},
+	} {
+		db.InsertUser(ctx, &user)
+		token, _ := signToken(user.Salt)
+		w := serveJSON(router, http.MethodPut, "/api/users/me", token, gin.H{"currentpassword": "", "password": "Changed2?"})
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "yönetiliyor") {
+			t.Errorf("password change of %s = %d %s, want 400", user.Username, w.Code, w.Body)
+		}
+	}
+}
+
+func TestPasswordReset(t *testing.T) {
+	router, db, tokens := newTestRouter(t)
+	ctx := context.Background()
+	serveJSON(router, http.MethodPost, "/api/users", tokens["admin"], gin.H{"username": "ayse", "password": "Secret1!", "role": "watcher", "emails": []string{"ayse@example.com"}})
+
+	// without DASHBOARD_URL there is no trusted base for the link
+	t.Setenv("DASHBOARD_URL", "")
+	if w := serveJSON(router, http.MethodPost, "/api/password/forgot", "", gin.H{"username": "ayse"}); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("forgot without DASHBOARD_URL = %d, want 503", w.Code)
+	}
+	t.Setenv("DASHBOARD_URL", "https://mailtracker.example.com/")
+
+	// unknown accounts get the same answer and no mail
+	if w := serveJSON(router, http.MethodPost, "/api/password/forgot", "", gin.H{"username": "nobody"}); w.Code != http.StatusOK {
+		t.Errorf("forgot of an unknown user = %d, want 200", w.Code)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/api/password/forgot", strings.NewReader(`{"username":"AYSE@example.com"}`))
+	req.Host = "attacker.example"
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("forgot = %d %s", w.Code, w.Body)
+	}
+	mails, _ := db.ListMails(ctx, store.MailFilter{})
+	if len(mails) != 1 || mails[0].Rcpt != "ayse@example.com" {
+		t.Fatalf("reset mails = %+v", mails)
+	}
+	sent, _ := db.FindMail(ctx, mails[0].Id)
+	token := regexp.MustCompile(`https://mailtracker\.example\.com/\?reset=(\S+)`).FindStringSubmatch(sent.Text)
+	if token == nil || strings.Contains(sent.Text, "attacker.example") {
+		t.Fatalf("reset mail does not link to DASHBOARD_URL: %s", sent.Text)
+	}
+
+	// the captured mail is ayse's, mails:all does not reach it
+	if sent.Inbox != store.UserInbox("ayse") {
+		t.Errorf("reset mail inbox = %q, want %q", sent.Inbox, store.UserInbox("ayse"))
+	}
+	auditors := store.Role{Name: "auditors", Permissions: []string{rbac.MailsRead, rbac.MailsAll}}
+	db.InsertRole(ctx, &auditors)
+	db.InsertUser(ctx, &store.User{Username: "ali", Role: "auditors", Salt: "ali-salt"})
+	ali, _ := signToken("ali-salt")
+	for _, token := range []string{ali, tokens["admin"]} {
+		w := serve(router, http.MethodGet, "/api/mails", token)
+		var list struct {
+			Data []mailListDto `json:"data"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &list)
+		if w.Code != http.StatusOK || len(list.Data) != 0 {
+			t.Errorf("GET /api/mails of another user = %d %s, want no mails", w.Code, w.Body)
+		}
+		if w := serve(router, http.MethodGet, "/api/mails/"+sent.Id, token); w.Code != http.StatusNotFound {
+			t.Errorf("GET of another user's reset mail = %d, want 404", w.Code)
+		}
+	}
+
+	reset := func(token, pass string) int {
+		return serveJSON(router, http.MethodPost, "/api/password/reset", "", gin.H{"token": token, "password": pass}).Code
+	}
+	if code := reset("unknown", "Changed2?"); code != http.StatusBadRequest {
+		t.Errorf("unknown token = %d, want 400", code)
+	}
+	if code := reset(token[1], "weak"); code != http.StatusBadRequest {
+		t.Errorf("weak password = %d, want 400", code)
+	}
+	if code := reset(token[1], "Changed2?"); code != http.StatusOK {
+		t.Fatalf("reset = %d", code)
+	}
+	if code := reset(token[1], "Changed3?"); code != http.StatusBadRequest {
+		t.Errorf("second use of a token = %d, want 400", code)
+	}
+	if w := serveJSON(router, http.MethodPost, "/api/login", "", gin.H{"username": "ayse", "password": "Changed2?"}); w.Code != http.StatusOK {
+		t.Errorf("login after the reset = %d", w.Code)
+	}
+
+	// expired tokens are refused
+	user, _ := db.FindUserByUsername(ctx, "ayse")
+	db.InsertPasswordReset(ctx, &store.PasswordReset{Hash: hashToken("expired"), UserId: user.Id, ExpiresAt: time.Now().Add(-time.Minute)})
+	if code := reset("expired", "Changed3?"); code != http.StatusBadRequest {
+		t.Errorf("expired token = %d, want 400", code)
+	}
+}
+
+func TestRoles(t *testing.T) {
+	router, db, tokens := newTestRouter(t)
+	ctx := context.Background()
+
+	tests := []struct {
+		name       string
+		body       gin.H
+		wantStatus int
+	}{
+		{"Custom role", gin.H{"name": "support", "permissions": []string{"tickets:manage"}}, http.StatusOK},
+		{"Taken name", gin.H{"name": "support"}, http.StatusBadRequest},
+		{"Built-in name", gin.H{"name": "admin"}, http.StatusBadRequest},
+		{"Unknown permission", gin.H{"name": "auditor", "permissions": []string{"mails:write"}}, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := serveJSON(router, http.MethodPost, "/api/roles", tokens["admin"], tt.body); w.Code != tt.wantStatus {
+				t.Errorf("POST /api/roles = %d %s, want %d", w.Code, w.Body, tt.wantStatus)
+			}
+		})
+	}
+	if w := serveJSON(router, http.MethodPost, "/api/roles", tokens["watcher"], gin.H{"name": "mine"}); w.Code != http.StatusForbidden {
+		t.Errorf("watcher creates a role = %d, want 403", w.Code)
+	}
+
+	w := serveJSON(router, http.MethodPost, "/api/users", tokens["admin"], gin.H{"username": "ayse", "password": "Secret1!", "role": "owner"})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("user with an unknown role = %d, want 400", w.Code)
+	}
+	w = serveJSON(router, http.MethodPost, "/api/users", tokens["admin"], gin.H{"username": "ayse", "password": "Secret1!", "role": "support"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("user with a custom role = %d %s", w.Code, w.Body)
+	}
+	user, _ := db.FindUserByUsername(ctx, "ayse")
+	token, _ := signToken(user.Salt)
+
+	// routes check the permissions of the role
+	for path, want := range map[string]int{
+		"/api/tickets":          http.StatusOK,
+		"/api/users":            http.StatusForbidden,
+		"/api/mails":            http.StatusForbidden,
+		"/api/smtp-credentials": http.StatusForbidden,
+	} {
+		if w := serve(router, http.MethodGet, path, token); w.Code != want {
+			t.Errorf("GET %s = %d, want %d", path, w.Code, want)
+		}
+	}
+	var me struct {
+		Data userListDto `json:"data"`
+	}
+	json.Unmarshal(serve(router, http.MethodGet, "/api/users/me", token).Body.Bytes(), &me)
+	if len(me.Data.Permissions) != 1 || me.Data.Permissions[0] != "tickets:manage" {
+		t.Errorf("permissions of the current user = %v", me.Data.Permissions)
+	}
+
+	role, _ := db.FindRoleByName(ctx, "support")
+	if w := serveJSON(router, http.MethodPut, "/api/roles/"+role.Id, tokens["admin"], gin.H{"permissions": []string{"tickets:manage", "mails:read"}}); w.Code != http.StatusOK {
+		t.Fatalf("PUT /api/roles/:id = %d %s", w.Code, w.Body)
+	}
+	if w := serve(router, http.MethodGet, "/api/mails", token); w.Code != http.StatusOK {
+		t.Errorf("GET /api/mails after the role changed = %d", w.Code)
+	}
+	if w := serveJSON(router, http.MethodPut, "/api/roles/admin", tokens["admin"], gin.H{"permissions": []string{}}); w.Code != http.StatusBadRequest {
+		t.Errorf("changing a built-in role = %d, want 400", w.Code)
+	}
+
+	if w := serve(router, http.MethodDelete, "/api/roles/"+role.Id, tokens["admin"]); w.Code != http.StatusBadRequest {
+		t.Errorf("deleting a role in use = %d, want 400", w.Code)
+	}
+	db.DeleteUser(ctx, user.Id)
+	if w := serve(router, http.MethodDelete, "/api/roles/"+role.Id, tokens["admin"]); w.Code != http.StatusOK {
+		t.Errorf("DELETE /api/roles/:id = %d %s", w.Code, w.Body)
+	}
+	var roles struct {
+		Data []roleDto `json:"data"`
+	}
+	json.Unmarshal(serve(router, http.MethodGet, "/api/roles", tokens["admin"]).Body.Bytes(), &roles)
+	if len(roles.Data) != 2 || !roles.Data[0].Builtin {
+		t.Errorf("GET /api/roles = %+v, want the built-in roles", roles.Data)
+	}
+}
+
+func TestRoles_escalation(t *testing.T) {
+	router, db, _ := newTestRouter(t)
+	ctx := context.Background()
+	manager := store.Role{Name: "manager", Permissions: []string{rbac.UsersManage, rbac.MailsRead, rbac.MailsDelete}}
+	db.InsertRole(ctx, &manager)
+	mira := store.User{Username: "mira", Role: "manager", Salt: "mira-salt"}
+	db.InsertUser(ctx, &mira)
+	admin, _ := db.FindUserByUsername(ctx, "admin")
+	watcher, _ := db.FindUserByUsername(ctx, "watcher")
+	token, _ := signToken(mira.Salt)
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		body       gin.H
+		wantStatus int
+	}{
+		{"Role with more permissions", http.MethodPost, "/api/roles", gin.H{"name": "root", "permissions": []string{rbac.UsersManage, rbac.SettingsManage}}, http.StatusForbidden},
+		{"Role within own permissions", http.MethodPost, "/api/roles", gin.H{"name": "readers", "permissions": []string{rbac.MailsRead}}, http.StatusOK},
+		{"Widening own role", http.MethodPut, "/api/roles/" + manager.Id, gin.H{"permissions": []string{rbac.UsersManage, rbac.MailsRead, rbac.MailsDelete, rbac.MailsAll}}, http.StatusForbidden},
+		{"Creating an admin", http.MethodPost, "/api/users", gin.H{"username": "root", "password": "Secret1!", "role": "admin"}, http.StatusForbidden},
+		{"Making self admin", http.MethodPut, "/api/users/" + mira.Id, gin.H{"username": "mira", "role": "admin"}, http.StatusForbidden},
+		{"Changing an admin", http.MethodPut, "/api/users/" + admin.Id, gin.H{"username": "admin", "role": "readers", "password": "Secret1!"}, http.StatusForbidden},
+		{"Role within own permissions to a user", http.MethodPut, "/api/users/" + watcher.Id, gin.H{"username": "watcher", "role": "readers"}, http.StatusOK},
+		{"Revoking the sessions of an admin", http.MethodDelete, "/api/users/" + admin.Id + "/sessions", nil, http.StatusForbidden},
+		{"Deleting an admin", http.MethodDelete, "/api/users/" + admin.Id, nil, http.StatusForbidden},
+		{"Deleting a user within own permissions", http.MethodDelete, "/api/users/" + watcher.Id, nil, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := serveJSON(router, tt.method, tt.path, token, tt.body); w.Code != tt.wantStatus {
+				t.Errorf("%s %s = %d %s, want %d", tt.method, tt.path, w.Code, w.Body, tt.wantStatus)
+			}
+		})
+	}
+	if user, _ := db.FindUser(ctx, mira.Id); user.Role != "manager" {
+		t.Errorf("role of mira = %q, want manager", user.Role)
+	}
+	if user, _ := db.FindUser(ctx, admin.Id); user == nil || user.Role != "admin" || user.Salt != admin.Salt {
+		t.Errorf("admin = %+v, want it unchanged", user)
+	}
+}
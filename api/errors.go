@@ -0,0 +1,58 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"github.com/getsentry/raven-go"
+	"github.com/gin-gonic/gin"
+	"log"
+	"net/http"
+)
+
+// apiError is answered to the client as {"message": "..."} with its status.
+// Err is the cause, it is logged and sent to Sentry but never shown.
+type apiError struct {
+	Status  int
+	Message string
+	Err     error
+}
+
+func newAPIError(status int, message string) *apiError {
+	return &apiError{Status: status, Message: message}
+}
+
+func (e *apiError) Error() string {
+	if e.Err != nil {
+		return e.Message + ": " + e.Err.Error()
+	}
+	return e.Message
+}
+
+func (e *apiError) Unwrap() error {
+	return e.Err
+}
+
+// abortWithError ends the request with err. Errors that are not an
+// apiError are internal: they are reported and answered with 500, or 408
+// when the request context ran out.
+func abortWithError(c *gin.Context, err error) {
+	var apiErr *apiError
+	switch {
+	case errors.As(err, &apiErr):
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, context.Canceled):
+		apiErr = &apiError{Status: http.StatusRequestTimeout, Message: "Request Timeout", Err: err}
+	default:
+		apiErr = &apiError{Status: http.StatusInternalServerError, Message: "Hata oluştu", Err: err}
+	}
+
+	if apiErr.Status >= http.StatusInternalServerError {
+		log.Println(c.Request.Method, c.Request.URL.Path+":", err)
+		raven.CaptureError(err, map[string]string{
+			"method": c.Request.Method,
+			"route":  c.FullPath(),
+		})
+	}
+	c.AbortWithStatusJSON(apiErr.Status, gin.H{
+		"message": apiErr.Message,
+	})
+}
@@ -0,0 +1,71 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestAbortWithError(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		name        string
+		err         error
+		wantStatus  int
+		wantMessage string
+	}{
+		{"Api error", newAPIError(http.StatusBadRequest, "Proje adı boş olamaz"), http.StatusBadRequest, "Proje adı boş olamaz"},
+		{"Wrapped api error", fmt.Errorf("validating: %w", newAPIError(http.StatusNotFound, "Mail not found")), http.StatusNotFound, "Mail not found"},
+		{"Timed out store call", fmt.Errorf("find: %w", context.DeadlineExceeded), http.StatusRequestTimeout, "Request Timeout"},
+		{"Internal error is not shown", errors.New("connection refused"), http.StatusInternalServerError, "Hata oluştu"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest(http.MethodGet, "/api/mails", nil)
+
+			abortWithError(c, tt.err)
+
+			var body struct {
+				Message string `json:"message"`
+			}
+			json.Unmarshal(w.Body.Bytes(), &body)
+			if w.Code != tt.wantStatus || body.Message != tt.wantMessage {
+				t.Errorf("abortWithError() = %d %q, want %d %q", w.Code, body.Message, tt.wantStatus, tt.wantMessage)
+			}
+			if !c.IsAborted() {
+				t.Errorf("abortWithError() did not abort the request")
+			}
+		})
+	}
+}
+
+func TestTimeoutMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(timeoutMiddleware())
+	deadlines := map[string]bool{}
+	handler := func(c *gin.Context) {
+		_, deadlines[c.FullPath()] = c.Request.Context().Deadline()
+		c.Status(http.StatusOK)
+	}
+	router.GET("/api/mails", handler)
+	router.GET("/api/mails/stream", handler)
+
+	for _, path := range []string{"/api/mails", "/api/mails/stream"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+	if !deadlines["/api/mails"] {
+		t.Errorf("request context of /api/mails has no deadline")
+	}
+	if deadlines["/api/mails/stream"] {
+		t.Errorf("streaming route got a deadline")
+	}
+}
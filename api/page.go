@@ -0,0 +1,91 @@
+package api
+
+import (
+	"discord-smtp-server/store"
+	"github.com/gin-gonic/gin"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 500
+)
+
+// parsePage reads limit, page (from 1), sort and order of a listing. sorts
+// are the fields it can be sorted by, the first is the default. The order
+// defaults to newest first for createdat and ascending for the others.
+func parsePage(c *gin.Context, sorts ...string) (store.Page, error) {
+	page := store.Page{Limit: defaultPageLimit, Sort: sorts[0]}
+	if value := c.Query("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxPageLimit {
+			return page, newAPIError(http.StatusBadRequest, "limit must be a number from 1 to "+strconv.Itoa(maxPageLimit))
+		}
+		page.Limit = limit
+	}
+	if value := c.Query("page"); value != "" {
+		number, err := strconv.Atoi(value)
+		if err != nil || number < 1 {
+			return page, newAPIError(http.StatusBadRequest, "page must be a number from 1")
+		}
+		// the offset and the end of the page must fit in an int
+		if number-1 > (math.MaxInt-page.Limit)/page.Limit {
+			return page, newAPIError(http.StatusBadRequest, "page is too large")
+		}
+		page.Offset = (number - 1) * page.Limit
+	}
+	if value := c.Query("sort"); value != "" {
+		for _, field := range sorts {
+			if value == field {
+				page.Sort = value
+			}
+		}
+		if page.Sort != value {
+			return page, newAPIError(http.StatusBadRequest, "sort must be one of "+strings.Join(sorts, ", "))
+		}
+	}
+	switch c.Query("order") {
+	case "":
+		page.Desc = page.Sort == "createdat"
+	case "asc":
+	case "desc":
+		page.Desc = true
+	default:
+		return page, newAPIError(http.StatusBadRequest, "order must be asc or desc")
+	}
+	return page, nil
+}
+
+// pageBounds sorts a listing the store returns whole and gives the bounds
+// of the page in it, less orders the i-th and j-th document ascending.
+func pageBounds(docs interface{}, n int, page store.Page, less func(i, j int) bool) (int, int) {
+	sort.SliceStable(docs, func(i, j int) bool {
+		if page.Desc {
+			return less(j, i)
+		}
+		return less(i, j)
+	})
+	start, end := page.Offset, n
+	if start > end {
+		start = end
+	}
+	if start+page.Limit < end {
+		end = start + page.Limit
+	}
+	return start, end
+}
+
+// pageResponse is the envelope of a listing, with the page number and the
+// count of every document matching the request.
+func pageResponse(data interface{}, page store.Page, total int) gin.H {
+	return gin.H{
+		"data":  data,
+		"total": total,
+		"page":  page.Offset/page.Limit + 1,
+		"limit": page.Limit,
+	}
+}
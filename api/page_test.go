@@ -0,0 +1,80 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"discord-smtp-server/store"
+)
+
+func TestListings_page(t *testing.T) {
+	router, db, tokens := newTestRouter(t)
+	ctx := context.Background()
+
+	var mails []string
+	for i, subject := range []string{"banana", "Apple", "cherry"} {
+		mail := store.Mail{Subject: subject, IsRead: i % 2, CreatedAt: time.Date(2023, 7, 19, i, 0, 0, 0, time.UTC)}
+		db.InsertMail(ctx, &mail)
+		mails = append(mails, mail.Id)
+	}
+	var tickets []string
+	for i, subject := range []string{"Bounce", "Attachment"} {
+		ticket := store.Ticket{Username: "watcher", Subject: subject, CreatedAt: time.Date(2023, 7, 19, i, 0, 0, 0, time.UTC)}
+		db.InsertTicket(ctx, &ticket)
+		tickets = append(tickets, ticket.Id)
+	}
+
+	tests := []struct {
+		name       string
+		path       string
+		wantStatus int
+		want       []string
+		wantTotal  int
+		wantUnread int
+	}{
+		{"Mails newest first", "/api/mails?limit=2", http.StatusOK, []string{mails[2], mails[1]}, 3, 2},
+		{"Second page of mails", "/api/mails?limit=2&page=2", http.StatusOK, []string{mails[0]}, 3, 2},
+		{"Mails by subject", "/api/mails?sort=subject&order=desc&limit=1", http.StatusOK, []string{mails[2]}, 3, 2},
+		{"Counts follow the search", "/api/mails?subject=an", http.StatusOK, []string{mails[0]}, 1, 1},
+		{"Tickets oldest first", "/api/tickets?order=asc", http.StatusOK, []string{tickets[0], tickets[1]}, 2, 2},
+		{"Tickets by subject", "/api/tickets?sort=subject&limit=1", http.StatusOK, []string{tickets[1]}, 2, 2},
+		{"Users by username", "/api/users?limit=1&page=2", http.StatusOK, []string{"watcher"}, 2, 0},
+		{"Unknown sort", "/api/mails?sort=password", http.StatusBadRequest, nil, 0, 0},
+		{"Limit too large", "/api/users?limit=100000", http.StatusBadRequest, nil, 0, 0},
+		{"Bad page", "/api/tickets?page=0", http.StatusBadRequest, nil, 0, 0},
+		{"Page too large", "/api/mails?page=9223372036854775807", http.StatusBadRequest, nil, 0, 0},
+		{"Last page that fits", "/api/users?limit=1&page=9223372036854775807", http.StatusOK, nil, 2, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(router, http.MethodGet, tt.path, tokens["admin"])
+
+			var body struct {
+				Data []struct {
+					Id       string `json:"id"`
+					Username string `json:"username"`
+				} `json:"data"`
+				Total  int `json:"total"`
+				Unread int `json:"unread"`
+			}
+			json.Unmarshal(w.Body.Bytes(), &body)
+			var got []string
+			for _, doc := range body.Data {
+				if strings.HasPrefix(tt.path, "/api/users") {
+					got = append(got, doc.Username)
+				} else {
+					got = append(got, doc.Id)
+				}
+			}
+			if w.Code != tt.wantStatus || !reflect.DeepEqual(got, tt.want) || body.Total != tt.wantTotal || body.Unread != tt.wantUnread {
+				t.Errorf("GET %s = %d %v total %d unread %d, want %d %v total %d unread %d",
+					tt.path, w.Code, got, body.Total, body.Unread, tt.wantStatus, tt.want, tt.wantTotal, tt.wantUnread)
+			}
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package api
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"discord-smtp-server/mailer"
+	"discord-smtp-server/store"
+)
+
+// resetLink returns the link on DASHBOARD_URL that opens the reset form
+// for token. It is never taken from the request, whose Host header the
+// client chooses, so resets are off without DASHBOARD_URL.
+func resetLink(token string) (string, bool) {
+	base := strings.TrimRight(os.Getenv("DASHBOARD_URL"), "/")
+	if base == "" {
+		return "", false
+	}
+	return base + "/?reset=" + url.QueryEscape(token), true
+}
+
+// resetMessage is the mail that carries a reset link to the first
+// address of the user, or the username when it has none. A captured one
+// goes to the user's own inbox, which no one else can read.
+func resetMessage(user *store.User, link string) mailer.Message {
+	to := user.Username
+	if len(user.Emails) > 0 {
+		to = user.Emails[0]
+	}
+	from := os.Getenv("MAIL_FROM")
+	if from == "" {
+		host := os.Getenv("HOST")
+		if host == "" {
+			host = "localhost"
+		}
+		from = "MailTracker <noreply@" + host + ">"
+	}
+	return mailer.Message{
+		From:    from,
+		To:      to,
+		Subject: "MailTracker parola sıfırlama",
+		Inbox:   store.UserInbox(user.Username),
+		Text: "Merhaba " + user.Username + ",\n\n" +
+			"MailTracker parolanızı sıfırlamak için aşağıdaki bağlantıyı açın. Bağlantı " +
+			strconv.Itoa(int(passwordResetTTL.Hours())) + " saat boyunca ve bir kez geçerlidir.\n\n" +
+			link + "\n\n" +
+			"Bu isteği siz yapmadıysanız bu maili yok sayabilirsiniz.\n",
+	}
+}
+
+// findResetUser finds the user a reset was asked for by username or by
+// one of the emails.
+func findResetUser(ctx context.Context, name string) (*store.User, error) {
+	user, err := st.FindUserByUsername(ctx, name)
+	if err != store.ErrNotFound {
+		return user, err
+	}
+	users, err := st.ListUsers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for i := range users {
+		for _, email := range users[i].Emails {
+			if strings.EqualFold(email, name) {
+				return &users[i], nil
+			}
+		}
+	}
+	return nil, store.ErrNotFound
+}
@@ -0,0 +1,61 @@
+package api
+
+import (
+	"discord-smtp-server/store"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// parseMailSearch adds the search parameters of the request to the filter
+// of its scope. The scope only narrows, an inbox outside of it finds
+// nothing.
+func parseMailSearch(c *gin.Context, filter store.MailFilter) (store.MailFilter, error) {
+	filter.Subject = c.Query("subject")
+	filter.From = c.Query("from")
+	filter.To = c.Query("to")
+	filter.Rcpt = c.Query("rcpt")
+	filter.Cc = c.Query("cc")
+	filter.Text = c.Query("q")
+	filter.Inbox = c.Query("inbox")
+
+	var err error
+	if filter.After, err = queryTime(c, "after"); err != nil {
+		return filter, err
+	}
+	if filter.Before, err = queryTime(c, "before"); err != nil {
+		return filter, err
+	}
+	if filter.Read, err = queryBool(c, "read"); err != nil {
+		return filter, err
+	}
+	if filter.HasAttachments, err = queryBool(c, "attachments"); err != nil {
+		return filter, err
+	}
+	return filter, nil
+}
+
+func queryTime(c *gin.Context, key string) (time.Time, error) {
+	value := c.Query(key)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, newAPIError(http.StatusBadRequest, key+" must be an RFC 3339 time")
+	}
+	return t, nil
+}
+
+func queryBool(c *gin.Context, key string) (*bool, error) {
+	value := c.Query(key)
+	if value == "" {
+		return nil, nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return nil, newAPIError(http.StatusBadRequest, key+" must be true or false")
+	}
+	return &b, nil
+}
@@ -0,0 +1,60 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"discord-smtp-server/store"
+)
+
+func TestListMails_search(t *testing.T) {
+	router, db, tokens := newTestRouter(t)
+	ctx := context.Background()
+	db.InsertProject(ctx, &store.Project{Name: "Shop", Inbox: "shop", Members: []store.ProjectMember{{Username: "watcher", Role: store.ProjectRoleViewer}}})
+
+	welcome := store.Mail{Subject: "Welcome", From: "app@example.com", To: "ayse@example.com", Text: "Confirm your account", Inbox: "shop", CreatedAt: time.Date(2023, 7, 19, 10, 0, 0, 0, time.UTC)}
+	invoice := store.Mail{Subject: "Invoice (July)", From: "billing@example.com", To: "mehmet@example.com", Inbox: "shop", IsRead: 1,
+		Attachments: []store.Attachment{{Id: "a1", Filename: "invoice.pdf"}}, CreatedAt: time.Date(2023, 7, 20, 10, 0, 0, 0, time.UTC)}
+	hidden := store.Mail{Subject: "Welcome", From: "app@example.com", To: "ayse@example.com", Inbox: "blog", CreatedAt: time.Date(2023, 7, 19, 10, 0, 0, 0, time.UTC)}
+	for _, mail := range []*store.Mail{&welcome, &invoice, &hidden} {
+		db.InsertMail(ctx, mail)
+	}
+
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int
+		want       []string
+	}{
+		{"Subject", "subject=welcome", http.StatusOK, []string{welcome.Id}},
+		{"Subject taken literally", "subject=(july)", http.StatusOK, []string{invoice.Id}},
+		{"From and to", "from=app@&to=ayse", http.StatusOK, []string{welcome.Id}},
+		{"Body", "q=account", http.StatusOK, []string{welcome.Id}},
+		{"Date range", "after=2023-07-20T00:00:00Z&before=2023-07-21T00:00:00Z", http.StatusOK, []string{invoice.Id}},
+		{"Unread", "read=false", http.StatusOK, []string{welcome.Id}},
+		{"With attachments", "attachments=true", http.StatusOK, []string{invoice.Id}},
+		{"Inbox of another project", "inbox=blog", http.StatusOK, nil},
+		{"Bad date", "after=yesterday", http.StatusBadRequest, nil},
+		{"Bad flag", "read=maybe", http.StatusBadRequest, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(router, http.MethodGet, "/api/mails?"+tt.query, tokens["watcher"])
+
+			var body struct {
+				Data []mailListDto `json:"data"`
+			}
+			json.Unmarshal(w.Body.Bytes(), &body)
+			var got []string
+			for _, mail := range body.Data {
+				got = append(got, mail.Id)
+			}
+			if w.Code != tt.wantStatus || len(got) != len(tt.want) || (len(got) > 0 && got[0] != tt.want[0]) {
+				t.Errorf("GET /api/mails?%s = %d %v, want %d %v", tt.query, w.Code, got, tt.wantStatus, tt.want)
+			}
+		})
+	}
+}
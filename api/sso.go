@@ -0,0 +1,86 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"time"
+
+	"discord-smtp-server/authn"
+	"discord-smtp-server/oidc"
+	"discord-smtp-server/store"
+	"github.com/golang-jwt/jwt"
+)
+
+const (
+	// ssoCookie keeps the state, nonce and PKCE verifier of a sign-on
+	// between the login and the callback.
+	ssoCookie  = "mailtracker_sso"
+	ssoFlowTTL = 10 * time.Minute
+	// ssoTimeout bounds the calls to the provider and the store in the
+	// callback, which skips the request timeout.
+	ssoTimeout = 30 * time.Second
+)
+
+type ssoFlow struct {
+	State    string
+	Nonce    string
+	Verifier string
+}
+
+// signSSOFlow packs the flow into a signed cookie value. It has no
+// subject, so it never passes as an access token.
+func signSSOFlow(flow ssoFlow) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"typ":      "sso",
+		"exp":      time.Now().Add(ssoFlowTTL).Unix(),
+		"state":    flow.State,
+		"nonce":    flow.Nonce,
+		"verifier": flow.Verifier,
+	})
+	return token.SignedString([]byte(os.Getenv("JWT_SECRET")))
+}
+
+func parseSSOFlow(value string) (ssoFlow, error) {
+	token, err := parseToken(value)
+	if err != nil {
+		return ssoFlow{}, err
+	}
+	claims, _ := token.Claims.(jwt.MapClaims)
+	if claims["typ"] != "sso" {
+		return ssoFlow{}, errors.New("not a sign-on cookie")
+	}
+	var flow ssoFlow
+	flow.State, _ = claims["state"].(string)
+	flow.Nonce, _ = claims["nonce"].(string)
+	flow.Verifier, _ = claims["verifier"].(string)
+	return flow, nil
+}
+
+// provisionSSOUser returns the user of a sign-on, creating it on the first
+// one. The groups decide the role on every sign-on.
+func provisionSSOUser(ctx context.Context, sso *oidc.Provider, claims *oidc.Claims) (*store.User, error) {
+	role, err := sso.Role(claims)
+	if err == oidc.ErrNoRole {
+		return nil, newAPIError(http.StatusForbidden, "Hesabınızın MailTracker erişimi yok")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := validateRole(ctx, role); err != nil {
+		return nil, err
+	}
+
+	user, err := authn.Provision(ctx, st, authn.Account{
+		Subject:       claims.Subject,
+		Username:      claims.Username(),
+		Email:         claims.Email,
+		EmailVerified: claims.EmailVerified,
+		Role:          role,
+	})
+	if err == authn.ErrUsernameTaken {
+		return nil, newAPIError(http.StatusConflict, "Kullanıcı adı zaten kullanılıyor")
+	}
+	return user, err
+}
@@ -0,0 +1,161 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"discord-smtp-server/events"
+	"discord-smtp-server/oidc"
+	"discord-smtp-server/oidc/oidctest"
+	"discord-smtp-server/rbac"
+	"discord-smtp-server/store"
+)
+
+func TestSSO(t *testing.T) {
+	mock := oidctest.NewProvider("mailtracker", "secret")
+	defer mock.Close()
+	ctx := context.Background()
+	sso, err := oidc.New(ctx, oidc.Config{
+		Issuer:       mock.URL,
+		ClientID:     mock.ClientID,
+		ClientSecret: mock.ClientSecret,
+		RedirectURL:  "http://mailtracker.test/api/oidc/callback",
+		Roles:        []rbac.RoleMapping{{Group: "mail-admins", Role: "admin"}, {Group: "qa", Role: "watcher"}},
+	})
+	if err != nil {
+		t.Fatalf("oidc.New() error = %v", err)
+	}
+	router, db, _ := newTestRouterWith(t, events.NewBus(), sso, nil)
+
+	// signIn runs the browser side of a sign-on and returns the fragment
+	// of the dashboard url it ends on
+	signIn := func(claims map[string]interface{}) url.Values {
+		t.Helper()
+		mock.SetUser(claims)
+		w := serve(router, http.MethodGet, "/api/oidc/login", "")
+		if w.Code != http.StatusFound || len(w.Result().Cookies()) != 1 {
+			t.Fatalf("login = %d %v", w.Code, w.Result().Cookies())
+		}
+		cookie := w.Result().Cookies()[0]
+		callback, err := mock.Authorize(w.Header().Get("Location"))
+		if err != nil {
+			t.Fatalf("Authorize() error = %v", err)
+		}
+
+		req := httptest.NewRequest(http.MethodGet, callback.RequestURI(), nil)
+		req.AddCookie(cookie)
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		location := w.Header().Get("Location")
+		if w.Code != http.StatusFound || !strings.HasPrefix(location, "/#") {
+			t.Fatalf("callback = %d %q", w.Code, location)
+		}
+		fragment, _ := url.ParseQuery(strings.TrimPrefix(location, "/#"))
+		return fragment
+	}
+
+	fragment := signIn(map[string]interface{}{"sub": "u-1", "preferred_username": "ayse", "email": "ayse@example.com", "groups": []string{"qa"}})
+	if w := serve(router, http.MethodGet, "/api/users/me", fragment.Get("token")); w.Code != http.StatusOK {
+		t.Fatalf("GET /api/users/me with the sign-on token = %d %s", w.Code, w.Body)
+	}
+	user, err := db.FindUserBySubject(ctx, "u-1")
+	if err != nil || user.Username != "ayse" || user.Role != "watcher" || len(user.Emails) != 1 {
+		t.Fatalf("provisioned user = %+v, %v", user, err)
+	}
+
+	// the groups decide the role again on every sign-on
+	signIn(map[string]interface{}{"sub": "u-1", "preferred_username": "ayse", "groups": []string{"qa", "mail-admins"}})
+	if user, _ := db.FindUserBySubject(ctx, "u-1"); user.Role != "admin" {
+		t.Errorf("role after the second sign-on = %q, want admin", user.Role)
+	}
+	if users, _ := db.ListUsers(ctx); len(users) != 3 {
+		t.Errorf("%d users after signing on twice, want 3", len(users))
+	}
+
+	// a local user is linked by a verified email of theirs, not by name
+	if got := signIn(map[string]interface{}{"sub": "u-2", "preferred_username": "admin", "groups": []string{"mail-admins"}}); got.Get("ssoerror") == "" {
+		t.Errorf("taking over the local admin by name gave %v", got)
+	}
+	watcher, _ := db.FindUserByUsername(ctx, "watcher")
+	watcher.Emails = []string{"watcher@example.com"}
+	db.UpdateUser(ctx, watcher)
+	signIn(map[string]interface{}{"sub": "u-2", "preferred_username": "watcher", "email": "Watcher@example.com", "email_verified": true, "groups": []string{"qa"}})
+	if user, _ := db.FindUserByUsername(ctx, "watcher"); user.Subject != "u-2" {
+		t.Errorf("local watcher subject = %q, want u-2", user.Subject)
+	}
+	if got := signIn(map[string]interface{}{"sub": "u-3", "preferred_username": "ayse", "groups": []string{"qa"}}); got.Get("ssoerror") == "" {
+		t.Errorf("taking over a linked username gave %v", got)
+	}
+
+	if got := signIn(map[string]interface{}{"sub": "u-4", "preferred_username": "mehmet", "groups": []string{"sales"}}); got.Get("ssoerror") == "" || got.Get("token") != "" {
+		t.Errorf("user in no mapped group gave %v", got)
+	}
+	if _, err := db.FindUserBySubject(ctx, "u-4"); err != store.ErrNotFound {
+		t.Errorf("refused user was created, err = %v", err)
+	}
+}
+
+func TestSSO_callback(t *testing.T) {
+	mock := oidctest.NewProvider("mailtracker", "secret")
+	defer mock.Close()
+	sso, err := oidc.New(context.Background(), oidc.Config{
+		Issuer:       mock.URL,
+		ClientID:     mock.ClientID,
+		ClientSecret: mock.ClientSecret,
+		RedirectURL:  "http://mailtracker.test/api/oidc/callback",
+		DefaultRole:  "watcher",
+	})
+	if err != nil {
+		t.Fatalf("oidc.New() error = %v", err)
+	}
+	router, _, _ := newTestRouterWith(t, events.NewBus(), sso, nil)
+	login := serve(router, http.MethodGet, "/api/oidc/login", "")
+	cookie := login.Result().Cookies()[0]
+	callback, err := mock.Authorize(login.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("Authorize() error = %v", err)
+	}
+	forged, _ := signSSOFlow(ssoFlow{State: "other", Nonce: "n", Verifier: "v"})
+
+	tests := []struct {
+		name   string
+		query  string
+		cookie *http.Cookie
+	}{
+		{"No cookie", callback.RawQuery, nil},
+		{"Other state", callback.RawQuery, &http.Cookie{Name: ssoCookie, Value: forged}},
+		{"Access token as cookie", callback.RawQuery, &http.Cookie{Name: ssoCookie, Value: mustSignToken(t, "admin-salt")}},
+		{"Provider error", "error=access_denied&state=" + callback.Query().Get("state"), cookie},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/oidc/callback?"+tt.query, nil)
+			if tt.cookie != nil {
+				req.AddCookie(tt.cookie)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			if location := w.Header().Get("Location"); w.Code != http.StatusFound || !strings.HasPrefix(location, "/#ssoerror=") {
+				t.Errorf("callback = %d %q, want a sign-on error", w.Code, location)
+			}
+		})
+	}
+
+	disabled, _, _ := newTestRouter(t)
+	if w := serve(disabled, http.MethodGet, "/api/oidc/login", ""); w.Code != http.StatusNotFound {
+		t.Errorf("login without sign-on = %d, want 404", w.Code)
+	}
+}
+
+func mustSignToken(t *testing.T, salt string) string {
+	t.Helper()
+	token, err := signToken(salt)
+	if err != nil {
+		t.Fatalf("signToken() error = %v", err)
+	}
+	return token
+}
@@ -0,0 +1,57 @@
+package api
+
+import (
+	"discord-smtp-server/store"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"time"
+)
+
+const (
+	defaultWaitTimeout = 30 * time.Second
+	maxWaitTimeout     = 2 * time.Minute
+)
+
+// waitQuery is the mail a test waits for on /api/mails/wait. Without since
+// only mails arriving during the wait match.
+type waitQuery struct {
+	to      string
+	subject string
+	since   time.Time
+	timeout time.Duration
+}
+
+func parseWaitQuery(c *gin.Context) (waitQuery, error) {
+	query := waitQuery{
+		to:      c.Query("to"),
+		subject: c.Query("subject"),
+		timeout: defaultWaitTimeout,
+	}
+	if value := c.Query("timeout"); value != "" {
+		timeout, err := time.ParseDuration(value)
+		if err != nil || timeout <= 0 {
+			return query, newAPIError(http.StatusBadRequest, "timeout must be a duration like 30s")
+		}
+		query.timeout = timeout
+	}
+	if query.timeout > maxWaitTimeout {
+		query.timeout = maxWaitTimeout
+	}
+	if value := c.Query("since"); value != "" {
+		since, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return query, newAPIError(http.StatusBadRequest, "since must be an RFC 3339 time")
+		}
+		query.since = since
+	}
+	return query, nil
+}
+
+// matches compares case insensitively, to is looked up in the To, Cc and
+// envelope recipient of the mail and subject is a part of the subject.
+func (q waitQuery) matches(mail store.Mail) bool {
+	if q.to != "" && !store.ContainsFold(mail.To, q.to) && !store.ContainsFold(mail.Cc, q.to) && !store.ContainsFold(mail.Rcpt, q.to) {
+		return false
+	}
+	return q.subject == "" || store.ContainsFold(mail.Subject, q.subject)
+}
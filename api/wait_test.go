@@ -0,0 +1,83 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"discord-smtp-server/events"
+	"discord-smtp-server/store"
+)
+
+func TestWaitForMail(t *testing.T) {
+	bus := events.NewBus()
+	router, db, tokens := newTestRouterOn(t, bus)
+	ctx := context.Background()
+	db.InsertProject(ctx, &store.Project{Name: "Shop", Inbox: "shop", Members: []store.ProjectMember{{Username: "watcher", Role: store.ProjectRoleViewer}}})
+
+	since := time.Now().UTC()
+	stored := store.Mail{Subject: "Reset your password", To: "ayse@example.com", Inbox: "shop", Text: "Your code is 123456", CreatedAt: since.Add(time.Second)}
+	db.InsertMail(ctx, &stored)
+	old := store.Mail{Subject: "Old news", To: "ayse@example.com", Inbox: "shop", CreatedAt: since.Add(-time.Hour)}
+	db.InsertMail(ctx, &old)
+
+	tests := []struct {
+		name       string
+		query      string
+		publish    []store.Mail
+		wantStatus int
+		wantId     string
+		wantText   string
+	}{
+		{
+			"Arriving mail",
+			"to=AYSE@example.com&subject=welcome&timeout=5s",
+			[]store.Mail{
+				{Id: "other", Subject: "Welcome", To: "mehmet@example.com", Inbox: "shop"},
+				{Id: "hidden", Subject: "Welcome", To: "ayse@example.com", Inbox: "blog"},
+				{Id: "arrived", Subject: "Welcome aboard", To: "Ayse <ayse@example.com>", Inbox: "shop", Text: "Hello"},
+			},
+			http.StatusOK,
+			"arrived",
+			"Hello",
+		},
+		{"Stored since", "to=ayse@example.com&subject=password&since=" + since.Format(time.RFC3339), nil, http.StatusOK, stored.Id, "Your code is 123456"},
+		{"Stored before since", "subject=old&timeout=100ms&since=" + since.Format(time.RFC3339), nil, http.StatusRequestTimeout, "", ""},
+		{"Stored without since", "subject=password&timeout=100ms", nil, http.StatusRequestTimeout, "", ""},
+		{"Mail of another project", "subject=welcome&timeout=100ms", []store.Mail{{Subject: "Welcome", Inbox: "blog"}}, http.StatusRequestTimeout, "", ""},
+		{"Bad timeout", "timeout=soon", nil, http.StatusBadRequest, "", ""},
+		{"Bad since", "since=yesterday", nil, http.StatusBadRequest, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// publish until the request is answered, the handler has to
+			// subscribe first
+			publish := tt.publish
+			done := make(chan struct{})
+			go func() {
+				for {
+					for _, mail := range publish {
+						bus.Publish(mail)
+					}
+					select {
+					case <-done:
+						return
+					case <-time.After(20 * time.Millisecond):
+					}
+				}
+			}()
+			w := serve(router, http.MethodGet, "/api/mails/wait?"+tt.query, tokens["watcher"])
+			close(done)
+
+			var body struct {
+				Data mailDto `json:"data"`
+			}
+			json.Unmarshal(w.Body.Bytes(), &body)
+			if w.Code != tt.wantStatus || body.Data.Id != tt.wantId || body.Data.Text != tt.wantText {
+				t.Errorf("GET /api/mails/wait = %d %s, want %d %q with %q", w.Code, w.Body, tt.wantStatus, tt.wantId, tt.wantText)
+			}
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package attachment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// kinds of attachment storage
+const (
+	StorageGridFS = "gridfs"
+	StorageDir    = "dir"
+)
+
+var ErrNotFound = errors.New("attachment not found")
+
+// validID keeps ids usable as file names for the directory storage.
+var validID = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+type Storage interface {
+	Save(ctx context.Context, id, filename string, r io.Reader) error
+	Open(ctx context.Context, id string) (io.ReadCloser, error)
+	Delete(ctx context.Context, id string) error
+}
+
+// New returns the storage selected by kind, defaulting to GridFS in db or
+// to a local directory when there is no Mongo database.
+func New(kind string, db *mongo.Database, dir string) (Storage, error) {
+	switch kind {
+	case "":
+		if db == nil {
+			if dir == "" {
+				dir = "attachments"
+			}
+			return NewDir(dir)
+		}
+		return NewGridFS(db)
+	case StorageGridFS:
+		if db == nil {
+			return nil, errors.New("gridfs attachment storage needs the mongo store")
+		}
+		return NewGridFS(db)
+	case StorageDir:
+		return NewDir(dir)
+	}
+	return nil, fmt.Errorf("unknown attachment storage %q", kind)
+}
+
+type GridFS struct {
+	bucket *gridfs.Bucket
+}
+
+func NewGridFS(db *mongo.Database) (*GridFS, error) {
+	bucket, err := gridfs.NewBucket(db, options.GridFSBucket().SetName("attachments"))
+	if err != nil {
+		return nil, err
+	}
+	return &GridFS{
+		bucket: bucket,
+	}, nil
+}
+
+func (g *GridFS) Save(ctx context.Context, id, filename string, r io.Reader) error {
+	return g.bucket.UploadFromStreamWithID(id, filename, r)
+}
+
+func (g *GridFS) Open(ctx context.Context, id string) (io.ReadCloser, error) {
+	stream, err := g.bucket.OpenDownloadStream(id)
+	if err == gridfs.ErrFileNotFound {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return stream, nil
+}
+
+func (g *GridFS) Delete(ctx context.Context, id string) error {
+	err := g.bucket.DeleteContext(ctx, id)
+	if err == gridfs.ErrFileNotFound {
+		return nil
+	}
+	return err
+}
+
+type Dir struct {
+	path string
+}
+
+func NewDir(path string) (*Dir, error) {
+	if path == "" {
+		return nil, errors.New("attachment directory is not set")
+	}
+	if err := os.MkdirAll(path, 0o755); err != nil {
+		return nil, err
+	}
+	return &Dir{
+		path: path,
+	}, nil
+}
+
+func (d *Dir) file(id string) (string, error) {
+	if !validID.MatchString(id) {
+		return "", fmt.Errorf("invalid attachment id %q", id)
+	}
+	return filepath.Join(d.path, id), nil
+}
+
+func (d *Dir) Save(ctx context.Context, id, filename string, r io.Reader) error {
+	name, err := d.file(id)
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (d *Dir) Open(ctx context.Context, id string) (io.ReadCloser, error) {
+	name, err := d.file(id)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(name)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	return f, err
+}
+
+func (d *Dir) Delete(ctx context.Context, id string) error {
+	name, err := d.file(id)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(name)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,66 @@
+package attachment
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestDir(t *testing.T) {
+	ctx := context.Background()
+	d, err := NewDir(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewDir() error = %v", err)
+	}
+
+	if err := d.Save(ctx, "644a1f", "invoice.pdf", strings.NewReader("%PDF")); err != nil {
+		t.Fatalf("Dir.Save() error = %v", err)
+	}
+	r, err := d.Open(ctx, "644a1f")
+	if err != nil {
+		t.Fatalf("Dir.Open() error = %v", err)
+	}
+	got, _ := io.ReadAll(r)
+	r.Close()
+	if string(got) != "%PDF" {
+		t.Errorf("Dir.Open() content = %q, want %q", got, "%PDF")
+	}
+
+	if err := d.Delete(ctx, "644a1f"); err != nil {
+		t.Fatalf("Dir.Delete() error = %v", err)
+	}
+	if _, err := d.Open(ctx, "644a1f"); err != ErrNotFound {
+		t.Errorf("Dir.Open() after delete error = %v, want %v", err, ErrNotFound)
+	}
+	if err := d.Delete(ctx, "644a1f"); err != nil {
+		t.Errorf("Dir.Delete() missing file error = %v", err)
+	}
+}
+
+func TestDir_invalidID(t *testing.T) {
+	d, err := NewDir(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewDir() error = %v", err)
+	}
+	tests := []string{"", "../etc/passwd", "a/b", "a.b"}
+	for _, id := range tests {
+		t.Run(id, func(t *testing.T) {
+			if err := d.Save(context.Background(), id, "x", strings.NewReader("x")); err == nil {
+				t.Errorf("Dir.Save(%q) expected error", id)
+			}
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+	if _, err := New("s3", nil, ""); err == nil {
+		t.Errorf("New() expected error for unknown storage")
+	}
+	if _, err := New(StorageDir, nil, ""); err == nil {
+		t.Errorf("New() expected error for empty directory")
+	}
+	if _, err := New(StorageDir, nil, t.TempDir()); err != nil {
+		t.Errorf("New() error = %v", err)
+	}
+}
@@ -0,0 +1,186 @@
+// Package authn checks the username and password of the dashboard and
+// smtp logins, against the users of the store or a directory.
+package authn
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"discord-smtp-server/password"
+	"discord-smtp-server/store"
+)
+
+var (
+	// ErrInvalidCredentials is returned for an unknown username or a
+	// wrong password.
+	ErrInvalidCredentials = errors.New("invalid username or password")
+	// ErrNoRole is returned for directory users in none of the mapped
+	// groups when there is no default role.
+	ErrNoRole = errors.New("user is in none of the mapped groups")
+	// ErrUsernameTaken is returned when an outside account has the
+	// username of a user it is not linked to.
+	ErrUsernameTaken = errors.New("username is taken by another user")
+)
+
+// LDAPSubject starts the subject of the users a directory login links.
+const LDAPSubject = "ldap:"
+
+// Authenticator checks a username and password and returns the
+// MailTracker user they belong to.
+type Authenticator interface {
+	Authenticate(ctx context.Context, username, password string) (*store.User, error)
+}
+
+// Local checks the password hashes of the users in the store.
+type Local struct {
+	Users     store.UserStore
+	Passwords *password.Hasher
+}
+
+func (l *Local) Authenticate(ctx context.Context, username, plain string) (*store.User, error) {
+	user, err := l.Users.FindUserByUsername(ctx, username)
+	if err == store.ErrNotFound {
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rehash, err := l.Passwords.Verify(user.Password, plain)
+	// users of a single sign-on or a directory may have no password at all
+	if err == password.ErrInvalidHash && (user.Subject == "" || user.Password != "") {
+		log.Println("Stored password hash of", user.Username, "is invalid")
+	}
+	if err != nil {
+		return nil, ErrInvalidCredentials
+	}
+	// move the hash to the configured algorithm and cost while the
+	// password is at hand
+	if rehash {
+		hash, err := l.Passwords.Hash(plain)
+		if err == nil {
+			err = l.Users.UpdateUserPassword(ctx, user.Id, hash)
+		}
+		if err != nil {
+			log.Println("Rehashing the password of", user.Username, "failed:", err)
+		}
+	}
+	return user, nil
+}
+
+// Chain asks its authenticators in order and returns the first user one of
+// them accepts. When none does, the first error other than
+// ErrInvalidCredentials is returned, so an unreachable directory is told
+// apart from a wrong password.
+type Chain []Authenticator
+
+func (c Chain) Authenticate(ctx context.Context, username, password string) (*store.User, error) {
+	var failed error
+	for _, a := range c {
+		user, err := a.Authenticate(ctx, username, password)
+		if err == nil {
+			return user, nil
+		}
+		if err != ErrInvalidCredentials && failed == nil {
+			failed = err
+		}
+	}
+	if failed != nil {
+		return nil, failed
+	}
+	return nil, ErrInvalidCredentials
+}
+
+// Account is a user of an identity provider or directory.
+type Account struct {
+	// Subject identifies the account at its source, it is stored with
+	// the user.
+	Subject  string
+	Username string
+	Email    string
+	// EmailVerified tells whether the source vouches for Email, only then
+	// it links the account to an existing user.
+	EmailVerified bool
+	Role          string
+}
+
+// Provision returns the user of an outside account, creating it on the
+// first login. The role of the account replaces the one of the user on
+// every login. An existing user with the username is linked to the account
+// only when the verified email of the account is one of theirs, so an
+// outside account cannot take over a local admin by name.
+func Provision(ctx context.Context, users store.UserStore, account Account) (*store.User, error) {
+	user, err := users.FindUserBySubject(ctx, account.Subject)
+	if err == store.ErrNotFound {
+		user, err = users.FindUserByUsername(ctx, account.Username)
+		if err == nil && (user.Subject != "" || !ownsEmail(user, account)) {
+			return nil, ErrUsernameTaken
+		}
+		if err == nil {
+			err = users.UpdateUserSubject(ctx, user.Id, account.Subject)
+			user.Subject = account.Subject
+		}
+	}
+	if err == store.ErrNotFound {
+		salt, err := NewSalt()
+		if err != nil {
+			return nil, err
+		}
+		user = &store.User{
+			Username:  account.Username,
+			Role:      account.Role,
+			Salt:      salt,
+			Subject:   account.Subject,
+			CreatedAt: time.Now().UTC(),
+		}
+		if account.Email != "" {
+			user.Emails = []string{account.Email}
+		}
+		if _, err := users.InsertUser(ctx, user); err != nil {
+			return nil, err
+		}
+		return user, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	addEmail := len(user.Emails) == 0 && account.Email != ""
+	if user.Role != account.Role || addEmail {
+		user.Role = account.Role
+		if addEmail {
+			user.Emails = []string{account.Email}
+		}
+		if err := users.UpdateUser(ctx, user); err != nil {
+			return nil, err
+		}
+	}
+	return user, nil
+}
+
+func ownsEmail(user *store.User, account Account) bool {
+	if !account.EmailVerified || account.Email == "" {
+		return false
+	}
+	for _, email := range user.Emails {
+		if strings.EqualFold(email, account.Email) {
+			return true
+		}
+	}
+	return false
+}
+
+// NewSalt returns a token subject for a user, changing it revokes their
+// tokens.
+func NewSalt() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,139 @@
+package authn
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"discord-smtp-server/password"
+	"discord-smtp-server/store"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestLocal_Authenticate(t *testing.T) {
+	ctx := context.Background()
+	db := store.NewMemory()
+	old, _ := bcrypt.GenerateFromPassword([]byte("ayse-secret"), bcrypt.MinCost)
+	db.InsertUser(ctx, &store.User{Username: "ayse", Password: string(old), Role: "watcher"})
+	db.InsertUser(ctx, &store.User{Username: "sso", Subject: "u-1", Role: "watcher"})
+	passwords, _ := password.New(password.Config{BcryptCost: bcrypt.MinCost + 1})
+	l := &Local{Users: db, Passwords: passwords}
+
+	tests := []struct {
+		name     string
+		username string
+		password string
+		wantErr  error
+	}{
+		{"Password", "ayse", "ayse-secret", nil},
+		{"Wrong password", "ayse", "wrong", ErrInvalidCredentials},
+		{"Unknown user", "nobody", "ayse-secret", ErrInvalidCredentials},
+		{"User without a password", "sso", "", ErrInvalidCredentials},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user, err := l.Authenticate(ctx, tt.username, tt.password)
+			if err != tt.wantErr {
+				t.Fatalf("Local.Authenticate() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && user.Username != tt.username {
+				t.Errorf("Local.Authenticate() = %q, want %q", user.Username, tt.username)
+			}
+		})
+	}
+
+	// the login moved the hash to the configured cost
+	user, _ := db.FindUserByUsername(ctx, "ayse")
+	if cost, _ := bcrypt.Cost([]byte(user.Password)); cost != bcrypt.MinCost+1 {
+		t.Errorf("cost after login = %d, want %d", cost, bcrypt.MinCost+1)
+	}
+}
+
+type authenticatorFunc func(username, password string) (*store.User, error)
+
+func (f authenticatorFunc) Authenticate(ctx context.Context, username, password string) (*store.User, error) {
+	return f(username, password)
+}
+
+func TestChain_Authenticate(t *testing.T) {
+	down := errors.New("directory is down")
+	accept := func(name string) Authenticator {
+		return authenticatorFunc(func(username, password string) (*store.User, error) {
+			if password != name {
+				return nil, ErrInvalidCredentials
+			}
+			return &store.User{Username: username + "@" + name}, nil
+		})
+	}
+	fail := authenticatorFunc(func(string, string) (*store.User, error) { return nil, down })
+
+	tests := []struct {
+		name     string
+		chain    Chain
+		password string
+		want     string
+		wantErr  error
+	}{
+		{"First accepts", Chain{accept("ldap"), accept("local")}, "ldap", "ayse@ldap", nil},
+		{"Second accepts", Chain{accept("ldap"), accept("local")}, "local", "ayse@local", nil},
+		{"Fallback past a failure", Chain{fail, accept("local")}, "local", "ayse@local", nil},
+		{"None accepts", Chain{accept("ldap"), accept("local")}, "wrong", "", ErrInvalidCredentials},
+		{"Failure is reported", Chain{fail, accept("local")}, "wrong", "", down},
+		{"Empty", Chain{}, "local", "", ErrInvalidCredentials},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user, err := tt.chain.Authenticate(context.Background(), "ayse", tt.password)
+			if err != tt.wantErr {
+				t.Fatalf("Chain.Authenticate() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && user.Username != tt.want {
+				t.Errorf("Chain.Authenticate() = %q, want %q", user.Username, tt.want)
+			}
+		})
+	}
+}
+
+func TestProvision(t *testing.T) {
+	ctx := context.Background()
+	db := store.NewMemory()
+	db.InsertUser(ctx, &store.User{Username: "admin", Role: "admin", Salt: "admin-salt", Emails: []string{"admin@example.com"}})
+
+	// the first login creates the user
+	user, err := Provision(ctx, db, Account{Subject: "s-1", Username: "ayse", Email: "ayse@example.com", Role: "watcher"})
+	if err != nil {
+		t.Fatalf("Provision() error = %v", err)
+	}
+	if user.Id == "" || user.Salt == "" || user.Role != "watcher" || strings.Join(user.Emails, ",") != "ayse@example.com" {
+		t.Fatalf("created user = %+v", user)
+	}
+
+	// later ones update the role
+	if _, err := Provision(ctx, db, Account{Subject: "s-1", Username: "ayse", Role: "admin"}); err != nil {
+		t.Fatalf("Provision() error = %v", err)
+	}
+	if found, _ := db.FindUserBySubject(ctx, "s-1"); found.Role != "admin" || found.Id != user.Id {
+		t.Errorf("user after the second login = %+v", found)
+	}
+
+	tests := []struct {
+		name    string
+		account Account
+		wantErr error
+	}{
+		{"Name of a local user", Account{Subject: "s-2", Username: "admin", Email: "admin@example.com", Role: "admin"}, ErrUsernameTaken},
+		{"Name of a linked user", Account{Subject: "s-3", Username: "ayse", Email: "ayse@example.com", EmailVerified: true, Role: "admin"}, ErrUsernameTaken},
+		{"Verified email of a local user", Account{Subject: "s-4", Username: "admin", Email: "Admin@example.com", EmailVerified: true, Role: "watcher"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Provision(ctx, db, tt.account); err != tt.wantErr {
+				t.Errorf("Provision() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+	if admin, _ := db.FindUserByUsername(ctx, "admin"); admin.Subject != "s-4" || admin.Salt != "admin-salt" {
+		t.Errorf("linked admin = %+v", admin)
+	}
+}
@@ -0,0 +1,218 @@
+package authn
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"discord-smtp-server/rbac"
+	"discord-smtp-server/store"
+	"github.com/go-ldap/ldap/v3"
+)
+
+// LDAP checks passwords by binding to a directory as the user. The user
+// entry is found with a search, as BindDN when it is set, and the groups
+// of the entry decide the role. Users are created in Users on their first
+// login.
+type LDAP struct {
+	// URL is the ldap:// or ldaps:// url of the server.
+	URL string
+	// StartTLS upgrades an ldap:// connection before binding.
+	StartTLS bool
+	TLS      *tls.Config
+	// BindDN and BindPassword are the service account the searches run
+	// as, they are anonymous when BindDN is empty.
+	BindDN       string
+	BindPassword string
+	BaseDN       string
+	// UserFilter finds the entry of a username, which replaces its %s
+	// escaped. (uid=%s) when empty.
+	UserFilter string
+	// UsernameAttribute holds the username stored for the user, uid when
+	// empty. The username of the login is used when the entry has none.
+	UsernameAttribute string
+	// EmailAttribute holds the email of the user, mail when empty.
+	EmailAttribute string
+	// TrustEmail lets the email of the entry link the account to an
+	// existing user, see Provision. Only set it when users can not change
+	// their own email in the directory.
+	TrustEmail bool
+	// GroupAttribute lists the group dns of the user entry, memberOf when
+	// empty.
+	GroupAttribute string
+	// GroupFilter, when set, also searches GroupBaseDN for the groups of
+	// the user, with the user dn replacing its %s, for servers without
+	// memberOf like (member=%s).
+	GroupBaseDN string
+	GroupFilter string
+	// Roles map the groups to roles, a group matches by its dn or the
+	// value of its first rdn, like cn=mail-admins,ou=groups,dc=example,dc=com
+	// or mail-admins.
+	Roles []rbac.RoleMapping
+	// DefaultRole is given to users in none of the mapped groups, they
+	// are refused with ErrNoRole when it is empty.
+	DefaultRole string
+	Users       store.UserStore
+	// RoleStore checks that the mapped role exists, as a built-in or
+	// custom role.
+	RoleStore store.RoleStore
+	// Timeout bounds a login, 10 seconds when zero.
+	Timeout time.Duration
+}
+
+func (l *LDAP) Authenticate(ctx context.Context, username, password string) (*store.User, error) {
+	// an empty password makes the bind unauthenticated, which servers
+	// accept for any dn
+	if username == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+	timeout := l.Timeout
+	if timeout == 0 {
+		timeout = 10 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	conn, err := ldap.DialURL(l.URL, ldap.DialWithDialer(&net.Dialer{Timeout: timeout}), ldap.DialWithTLSConfig(l.TLS))
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetTimeout(timeout)
+	if l.StartTLS {
+		if err := conn.StartTLS(l.startTLSConfig()); err != nil {
+			return nil, fmt.Errorf("ldap starttls: %w", err)
+		}
+	}
+	if err := l.bindService(conn); err != nil {
+		return nil, err
+	}
+
+	filter := l.UserFilter
+	if filter == "" {
+		filter = "(uid=%s)"
+	}
+	usernameAttribute := or(l.UsernameAttribute, "uid")
+	emailAttribute := or(l.EmailAttribute, "mail")
+	groupAttribute := or(l.GroupAttribute, "memberOf")
+	// a second entry makes the login ambiguous, the size limit keeps the
+	// search short when the filter matches many
+	found, err := conn.Search(ldap.NewSearchRequest(
+		l.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, 0, false,
+		strings.Replace(filter, "%s", ldap.EscapeFilter(username), -1),
+		[]string{usernameAttribute, emailAttribute, groupAttribute}, nil,
+	))
+	if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+		return nil, fmt.Errorf("ldap user search: %w", err)
+	}
+	if len(found.Entries) == 0 {
+		return nil, ErrInvalidCredentials
+	}
+	if len(found.Entries) > 1 {
+		return nil, fmt.Errorf("ldap user search: more than one entry for %q", username)
+	}
+	entry := found.Entries[0]
+
+	if err := conn.Bind(entry.DN, password); ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+		return nil, ErrInvalidCredentials
+	} else if err != nil {
+		return nil, err
+	}
+
+	groups := entry.GetEqualFoldAttributeValues(groupAttribute)
+	if l.GroupFilter != "" {
+		// the user may not be allowed to search the groups
+		if err := l.bindService(conn); err != nil {
+			return nil, err
+		}
+		found, err := conn.Search(ldap.NewSearchRequest(
+			or(l.GroupBaseDN, l.BaseDN), ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+			strings.Replace(l.GroupFilter, "%s", ldap.EscapeFilter(entry.DN), -1),
+			[]string{"cn"}, nil,
+		))
+		if err != nil {
+			return nil, fmt.Errorf("ldap group search: %w", err)
+		}
+		for _, group := range found.Entries {
+			groups = append(groups, group.DN)
+		}
+	}
+	role, err := l.role(ctx, groups)
+	if err != nil {
+		return nil, err
+	}
+
+	account := Account{
+		Subject:       LDAPSubject + strings.ToLower(entry.DN),
+		Username:      or(entry.GetEqualFoldAttributeValue(usernameAttribute), username),
+		Email:         entry.GetEqualFoldAttributeValue(emailAttribute),
+		EmailVerified: l.TrustEmail,
+		Role:          role,
+	}
+	return Provision(ctx, l.Users, account)
+}
+
+// startTLSConfig is TLS with the server name of URL when it has none, the
+// handshake of StartTLS does not fill it in like dialing ldaps:// does.
+func (l *LDAP) startTLSConfig() *tls.Config {
+	cfg := &tls.Config{}
+	if l.TLS != nil {
+		cfg = l.TLS.Clone()
+	}
+	if cfg.ServerName == "" {
+		if u, err := url.Parse(l.URL); err == nil {
+			cfg.ServerName = u.Hostname()
+		}
+	}
+	return cfg
+}
+
+func (l *LDAP) bindService(conn *ldap.Conn) error {
+	if l.BindDN == "" {
+		return nil
+	}
+	if err := conn.Bind(l.BindDN, l.BindPassword); err != nil {
+		return fmt.Errorf("ldap service bind: %w", err)
+	}
+	return nil
+}
+
+// role maps the group dns to a role, matching the mappings by dn or by
+// the value of the first rdn.
+func (l *LDAP) role(ctx context.Context, dns []string) (string, error) {
+	var groups []string
+	for _, dn := range dns {
+		groups = append(groups, dn)
+		rdn := strings.SplitN(dn, ",", 2)[0]
+		if eq := strings.IndexByte(rdn, '='); eq >= 0 {
+			groups = append(groups, rdn[eq+1:])
+		}
+	}
+	role := rbac.MapGroups(l.Roles, groups)
+	if role == "" {
+		role = l.DefaultRole
+	}
+	if role == "" {
+		return "", ErrNoRole
+	}
+	if _, ok := rbac.Builtin(role); ok || l.RoleStore == nil {
+		return role, nil
+	}
+	if _, err := l.RoleStore.FindRoleByName(ctx, role); err == store.ErrNotFound {
+		return "", fmt.Errorf("ldap role %q does not exist", role)
+	} else if err != nil {
+		return "", err
+	}
+	return role, nil
+}
+
+func or(value, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
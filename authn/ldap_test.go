@@ -0,0 +1,112 @@
+package authn
+
+import (
+	"context"
+	"testing"
+
+	"discord-smtp-server/ldap/ldaptest"
+	"discord-smtp-server/rbac"
+	"discord-smtp-server/store"
+)
+
+func TestLDAP_Authenticate(t *testing.T) {
+	d := ldaptest.NewDirectory()
+	defer d.Close()
+	d.Add(ldaptest.Entry{DN: "cn=mailtracker,ou=services,dc=example,dc=com"}, "service-secret")
+	person := func(uid string, groups ...string) ldaptest.Entry {
+		return ldaptest.Entry{DN: "uid=" + uid + ",ou=people,dc=example,dc=com", Attributes: map[string][]string{
+			"objectClass": {"inetOrgPerson"},
+			"uid":         {uid},
+			"mail":        {uid + "@example.com"},
+			"memberOf":    groups,
+		}}
+	}
+	d.Add(person("ayse", "cn=qa,ou=groups,dc=example,dc=com"), "ayse-secret")
+	d.Add(person("mehmet", "cn=mail-admins,ou=groups,dc=example,dc=com", "cn=qa,ou=groups,dc=example,dc=com"), "mehmet-secret")
+	d.Add(person("zeynep", "cn=sales,ou=groups,dc=example,dc=com"), "zeynep-secret")
+	d.Add(person("deniz", "cn=support,ou=groups,dc=example,dc=com"), "deniz-secret")
+	d.Add(person("admin", "cn=qa,ou=groups,dc=example,dc=com"), "admin-secret")
+	d.Add(ldaptest.Entry{DN: "cn=support,ou=groups,dc=example,dc=com", Attributes: map[string][]string{
+		"cn":     {"support"},
+		"member": {"uid=zeynep,ou=people,dc=example,dc=com"},
+	}}, "")
+
+	ctx := context.Background()
+	db := store.NewMemory()
+	db.InsertRole(ctx, &store.Role{Name: "support", Permissions: []string{rbac.MailsRead, rbac.TicketsManage}})
+	db.InsertUser(ctx, &store.User{Username: "admin", Role: "admin", Salt: "admin-salt"})
+	newLDAP := func() *LDAP {
+		return &LDAP{
+			URL:          d.URL,
+			BindDN:       "cn=mailtracker,ou=services,dc=example,dc=com",
+			BindPassword: "service-secret",
+			BaseDN:       "ou=people,dc=example,dc=com",
+			UserFilter:   "(&(objectClass=inetOrgPerson)(uid=%s))",
+			Roles: []rbac.RoleMapping{
+				{Group: "cn=mail-admins,ou=groups,dc=example,dc=com", Role: "admin"},
+				{Group: "qa", Role: "watcher"},
+				{Group: "support", Role: "support"},
+				{Group: "sales", Role: "missing"},
+			},
+			Users:     db,
+			RoleStore: db,
+		}
+	}
+
+	tests := []struct {
+		name        string
+		ldap        func(l *LDAP)
+		username    string
+		password    string
+		wantRole    string
+		wantErr     error
+		wantAnyErr  bool
+		wantNoBinds bool
+	}{
+		{"Group cn", nil, "ayse", "ayse-secret", "watcher", nil, false, false},
+		{"First mapping wins", nil, "mehmet", "mehmet-secret", "admin", nil, false, false},
+		{"Wrong password", nil, "ayse", "mehmet-secret", "", ErrInvalidCredentials, false, false},
+		{"Unauthenticated bind", nil, "ayse", "", "", ErrInvalidCredentials, false, true},
+		{"Unknown user", nil, "nobody", "ayse-secret", "", ErrInvalidCredentials, false, false},
+		{"Filter injection", nil, "*", "ayse-secret", "", ErrInvalidCredentials, false, false},
+		{"Mapped role does not exist", nil, "zeynep", "zeynep-secret", "", nil, true, false},
+		{"Group search", func(l *LDAP) { l.GroupBaseDN, l.GroupFilter = "ou=groups,dc=example,dc=com", "(member=%s)" }, "zeynep", "zeynep-secret", "support", nil, false, false},
+		{"No mapped group", func(l *LDAP) { l.Roles = l.Roles[:1] }, "ayse", "ayse-secret", "", ErrNoRole, false, false},
+		{"Default role", func(l *LDAP) { l.Roles, l.DefaultRole = nil, "watcher" }, "deniz", "deniz-secret", "watcher", nil, false, false},
+		{"Wrong service password", func(l *LDAP) { l.BindPassword = "wrong" }, "ayse", "ayse-secret", "", nil, true, false},
+		{"Name of a local user", nil, "admin", "admin-secret", "", ErrUsernameTaken, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newLDAP()
+			if tt.ldap != nil {
+				tt.ldap(l)
+			}
+			binds := d.Binds()
+			user, err := l.Authenticate(ctx, tt.username, tt.password)
+			if tt.wantNoBinds && d.Binds() != binds {
+				t.Errorf("Authenticate() bound to the directory")
+			}
+			if tt.wantAnyErr {
+				if err == nil || err == ErrInvalidCredentials {
+					t.Errorf("LDAP.Authenticate() error = %v, want a failure", err)
+				}
+				return
+			}
+			if err != tt.wantErr {
+				t.Fatalf("LDAP.Authenticate() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if user.Username != tt.username || user.Role != tt.wantRole || user.Subject != "ldap:uid="+tt.username+",ou=people,dc=example,dc=com" {
+				t.Errorf("LDAP.Authenticate() = %+v, want %s with role %s", user, tt.username, tt.wantRole)
+			}
+		})
+	}
+
+	users, _ := db.ListUsers(ctx)
+	if len(users) != 5 {
+		t.Errorf("%d users after the logins, want admin and four directory users", len(users))
+	}
+}
@@ -1,12 +1,18 @@
 package discord
 
 import (
+	"errors"
 	"fmt"
 	"github.com/bwmarrin/discordgo"
-	"log"
 	"strings"
 )
 
+var (
+	ErrGuildNotFound   = errors.New("guild not found")
+	ErrChannelNotFound = errors.New("channel not found")
+	ErrWebhookNotFound = errors.New("webhook not found")
+)
+
 type Client struct {
 	client *discordgo.Session
 }
@@ -25,7 +31,7 @@ func NewClient(token string) (*Client, error) {
 func (c *Client) GetGuildID(guildName string) (*string, error) {
 	guilds, err := c.client.UserGuilds(50, "", "")
 	if err != nil {
-		log.Print(err)
+		return nil, err
 	}
 
 	for _, guild := range guilds {
@@ -35,7 +41,7 @@ func (c *Client) GetGuildID(guildName string) (*string, error) {
 		}
 	}
 
-	return nil, err
+	return nil, ErrGuildNotFound
 }
 
 func (c *Client) GetChannelID(guildID, channelName string) (*string, error) {
@@ -50,9 +56,7 @@ func (c *Client) GetChannelID(guildID, channelName string) (*string, error) {
 		}
 	}
 
-	log.Print("failed to find channel")
-
-	return nil, err
+	return nil, ErrChannelNotFound
 }
 
 func (c *Client) GetWebhook(username, channelID string) (*string, error) {
@@ -72,7 +76,5 @@ func (c *Client) GetWebhook(username, channelID string) (*string, error) {
 		}
 	}
 
-	log.Print("failed to build webhook")
-
-	return nil, err
+	return nil, ErrWebhookNotFound
 }
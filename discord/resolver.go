@@ -0,0 +1,106 @@
+package discord
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"discord-smtp-server/email"
+)
+
+// lookup is the part of Client the resolver needs.
+type lookup interface {
+	GetGuildID(guildName string) (*string, error)
+	GetChannelID(guildID, channelName string) (*string, error)
+	GetWebhook(username, channelID string) (*string, error)
+}
+
+type resolved struct {
+	webhook string
+	err     error
+	expires time.Time
+}
+
+// Resolver maps user@channel.guild addresses to the webhook named user in
+// the channel of the guild, caching both found and missing webhooks.
+type Resolver struct {
+	client lookup
+	ttl    time.Duration
+	now    func() time.Time
+
+	mu    sync.Mutex
+	cache map[string]resolved
+}
+
+func NewResolver(client *Client, ttl time.Duration) *Resolver {
+	return newResolver(client, ttl)
+}
+
+func newResolver(client lookup, ttl time.Duration) *Resolver {
+	return &Resolver{
+		client: client,
+		ttl:    ttl,
+		now:    time.Now,
+		cache:  map[string]resolved{},
+	}
+}
+
+func (r *Resolver) Resolve(address string) (string, error) {
+	parsed, err := email.Parse(address)
+	if err != nil {
+		// an address in another form never names a webhook
+		return "", fmt.Errorf("%w: %v", ErrWebhookNotFound, err)
+	}
+	key := strings.ToLower(address)
+
+	r.mu.Lock()
+	entry, ok := r.cache[key]
+	r.mu.Unlock()
+	if ok && r.now().Before(entry.expires) {
+		return entry.webhook, entry.err
+	}
+
+	webhook, err := r.lookup(parsed)
+	if err != nil && !IsNotFound(err) {
+		// discord is unreachable, try again on the next recipient
+		return "", err
+	}
+	r.mu.Lock()
+	r.cache[key] = resolved{
+		webhook: webhook,
+		err:     err,
+		expires: r.now().Add(r.ttl),
+	}
+	r.mu.Unlock()
+
+	return webhook, err
+}
+
+func (r *Resolver) lookup(parsed *email.EmailAddress) (string, error) {
+	guildID, err := r.client.GetGuildID(parsed.TLD)
+	if err != nil {
+		return "", err
+	}
+
+	channelID, err := r.client.GetChannelID(*guildID, parsed.Domain)
+	if err != nil {
+		return "", err
+	}
+
+	webhook, err := r.client.GetWebhook(parsed.User, *channelID)
+	if err != nil {
+		return "", err
+	}
+
+	return *webhook, nil
+}
+
+// IsNotFound tells whether err says the address names no webhook, as
+// opposed to discord failing to answer.
+func IsNotFound(err error) bool {
+	return errors.Is(err, ErrGuildNotFound) ||
+		errors.Is(err, ErrChannelNotFound) ||
+		errors.Is(err, ErrWebhookNotFound)
+}
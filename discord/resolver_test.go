@@ -0,0 +1,94 @@
+package discord
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+type fakeLookup struct {
+	guilds   map[string]string
+	channels map[string]string
+	webhooks map[string]string
+	calls    int
+}
+
+func (f *fakeLookup) GetGuildID(guildName string) (*string, error) {
+	f.calls++
+	if id, ok := f.guilds[guildName]; ok {
+		return &id, nil
+	}
+	return nil, ErrGuildNotFound
+}
+
+func (f *fakeLookup) GetChannelID(guildID, channelName string) (*string, error) {
+	if id, ok := f.channels[guildID+"/"+channelName]; ok {
+		return &id, nil
+	}
+	return nil, ErrChannelNotFound
+}
+
+func (f *fakeLookup) GetWebhook(username, channelID string) (*string, error) {
+	if url, ok := f.webhooks[channelID+"/"+username]; ok {
+		return &url, nil
+	}
+	return nil, ErrWebhookNotFound
+}
+
+func TestResolver_Resolve(t *testing.T) {
+	client := &fakeLookup{
+		guilds:   map[string]string{"acme": "g1"},
+		channels: map[string]string{"g1/alerts": "c1"},
+		webhooks: map[string]string{"c1/bot": "https://discord/c1/bot"},
+	}
+	tests := []struct {
+		name    string
+		address string
+		want    string
+		wantErr error
+	}{
+		{"Known recipient", "bot@alerts.acme", "https://discord/c1/bot", nil},
+		{"Unknown guild", "bot@alerts.other", "", ErrGuildNotFound},
+		{"Unknown channel", "bot@general.acme", "", ErrChannelNotFound},
+		{"Unknown webhook", "someone@alerts.acme", "", ErrWebhookNotFound},
+		{"Malformed address", "not an address", "", ErrWebhookNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newResolver(client, time.Minute)
+			got, err := r.Resolve(tt.address)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Resolver.Resolve() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("Resolver.Resolve() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolver_cache(t *testing.T) {
+	client := &fakeLookup{
+		guilds:   map[string]string{"acme": "g1"},
+		channels: map[string]string{"g1/alerts": "c1"},
+		webhooks: map[string]string{"c1/bot": "https://discord/c1/bot"},
+	}
+	now := time.Now()
+	r := newResolver(client, time.Minute)
+	r.now = func() time.Time { return now }
+
+	r.Resolve("bot@alerts.acme")
+	r.Resolve("BOT@alerts.acme")
+	r.Resolve("nobody@alerts.acme")
+	r.Resolve("nobody@alerts.acme")
+	if client.calls != 2 {
+		t.Errorf("lookups = %d, want 2", client.calls)
+	}
+
+	now = now.Add(2 * time.Minute)
+	r.Resolve("bot@alerts.acme")
+	if client.calls != 3 {
+		t.Errorf("lookups after expiry = %d, want 3", client.calls)
+	}
+}
@@ -0,0 +1,231 @@
+package discord
+
+import (
+	"bytes"
+	"context"
+	"discord-smtp-server/store"
+	"encoding/json"
+	"fmt"
+	"github.com/bwmarrin/discordgo"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// discord limits, see https://discord.com/developers/docs/resources/channel#embed-object-embed-limits
+const (
+	maxTitle      = 256
+	maxFieldValue = 1024
+	previewLength = 1000
+	embedColor    = 0x5865f2
+)
+
+// Webhook posts mail notifications to discord webhooks as embeds.
+type Webhook struct {
+	poster       *Poster
+	dashboardURL string
+	attachEml    bool
+}
+
+// NewWebhook creates a notifier linking mails to the dashboard at
+// dashboardURL. When attachEml is set the raw message is sent along as an
+// .eml file.
+func NewWebhook(dashboardURL string, attachEml bool) *Webhook {
+	return &Webhook{
+		poster:       NewPoster(),
+		dashboardURL: strings.TrimRight(dashboardURL, "/"),
+		attachEml:    attachEml,
+	}
+}
+
+// Send posts the mail to the webhook url.
+func (w *Webhook) Send(ctx context.Context, url string, mail store.Mail) error {
+	contentType, body, err := w.payload(mail)
+	if err != nil {
+		return err
+	}
+	return w.poster.Post(ctx, url, contentType, body, nil)
+}
+
+// Poster posts to webhooks, waiting out rate limits and retrying server
+// errors. The notifiers of other services use it too.
+type Poster struct {
+	Client     *http.Client
+	MaxRetries int
+	// Sleep waits before a retry.
+	Sleep func(ctx context.Context, d time.Duration) error
+}
+
+// NewPoster returns a poster with a 30 second timeout that retries three
+// times.
+func NewPoster() *Poster {
+	return &Poster{
+		Client:     &http.Client{Timeout: 30 * time.Second},
+		MaxRetries: 3,
+		Sleep:      sleep,
+	}
+}
+
+// Post sends the body to the url with the headers of header added.
+func (p *Poster) Post(ctx context.Context, url, contentType string, body []byte, header http.Header) error {
+	for attempt := 0; ; attempt++ {
+		wait, err := p.post(ctx, url, contentType, body, header)
+		if err == nil {
+			return nil
+		}
+		if wait < 0 || attempt >= p.MaxRetries {
+			return err
+		}
+		if err := p.Sleep(ctx, wait); err != nil {
+			return err
+		}
+	}
+}
+
+// post sends one request. On failure it returns how long to wait before
+// retrying, or a negative duration when retrying will not help.
+func (p *Poster) post(ctx context.Context, url, contentType string, body []byte, header http.Header) (time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return -1, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	for key, values := range header {
+		req.Header[key] = values
+	}
+
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return time.Second, err
+	}
+	defer resp.Body.Close()
+	msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+
+	switch {
+	case resp.StatusCode < 300:
+		return 0, nil
+	case resp.StatusCode == http.StatusTooManyRequests:
+		return retryAfter(resp.Header, msg), fmt.Errorf("webhook rate limited")
+	case resp.StatusCode >= 500:
+		return time.Second, fmt.Errorf("webhook failed: %s", resp.Status)
+	}
+	return -1, fmt.Errorf("webhook failed: %s %s", resp.Status, msg)
+}
+
+// retryAfter reads the wait from the Retry-After header, falling back to
+// the retry_after field of the body.
+func retryAfter(h http.Header, body []byte) time.Duration {
+	if seconds, err := strconv.ParseFloat(h.Get("Retry-After"), 64); err == nil {
+		return time.Duration(seconds * float64(time.Second))
+	}
+	var limit struct {
+		RetryAfter float64 `json:"retry_after"`
+	}
+	if json.Unmarshal(body, &limit) == nil && limit.RetryAfter > 0 {
+		return time.Duration(limit.RetryAfter * float64(time.Second))
+	}
+	return time.Second
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (w *Webhook) payload(mail store.Mail) (string, []byte, error) {
+	params := discordgo.WebhookParams{
+		Embeds: []*discordgo.MessageEmbed{w.embed(mail)},
+	}
+	payload, err := json.Marshal(params)
+	if err != nil {
+		return "", nil, err
+	}
+	if !w.attachEml || mail.Data == "" {
+		return "application/json", payload, nil
+	}
+
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	if err := mw.WriteField("payload_json", string(payload)); err != nil {
+		return "", nil, err
+	}
+	fw, err := mw.CreateFormFile("file", emlName(mail))
+	if err != nil {
+		return "", nil, err
+	}
+	if _, err := io.WriteString(fw, mail.Data); err != nil {
+		return "", nil, err
+	}
+	if err := mw.Close(); err != nil {
+		return "", nil, err
+	}
+	return mw.FormDataContentType(), buf.Bytes(), nil
+}
+
+func (w *Webhook) embed(mail store.Mail) *discordgo.MessageEmbed {
+	subject := mail.Subject
+	if subject == "" {
+		subject = "(no subject)"
+	}
+	embed := &discordgo.MessageEmbed{
+		Title:       Truncate(subject, maxTitle),
+		Description: Truncate(mail.PlainText(), previewLength),
+		Color:       embedColor,
+		Fields: []*discordgo.MessageEmbedField{
+			{Name: "From", Value: Truncate(OrDash(mail.From), maxFieldValue), Inline: true},
+			{Name: "To", Value: Truncate(OrDash(mail.To), maxFieldValue), Inline: true},
+		},
+	}
+	if mail.Cc != "" {
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
+			Name: "Cc", Value: Truncate(mail.Cc, maxFieldValue), Inline: true,
+		})
+	}
+	if len(mail.Attachments) > 0 {
+		embed.Footer = &discordgo.MessageEmbedFooter{
+			Text: fmt.Sprintf("%d attachment(s)", len(mail.Attachments)),
+		}
+	}
+	if w.dashboardURL != "" && mail.Id != "" {
+		embed.URL = w.dashboardURL + "/?mail=" + mail.Id
+	}
+	if !mail.CreatedAt.IsZero() {
+		embed.Timestamp = mail.CreatedAt.Format(time.RFC3339)
+	}
+	return embed
+}
+
+// Truncate cuts s to n runes, ending it with an ellipsis when it is cut.
+func Truncate(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	runes := []rune(s)
+	return string(runes[:n-1]) + "…"
+}
+
+// OrDash stands a dash in for an empty field.
+func OrDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func emlName(mail store.Mail) string {
+	if mail.Id != "" {
+		return mail.Id + ".eml"
+	}
+	return "mail.eml"
+}
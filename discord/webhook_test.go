@@ -0,0 +1,115 @@
+package discord
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"discord-smtp-server/store"
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestWebhook_Send(t *testing.T) {
+	mail := store.Mail{
+		Id:        "64b7f0c2a1b2c3d4e5f60718",
+		Subject:   "Welcome",
+		From:      "from@example.com",
+		To:        "to@example.com",
+		Html:      "<style>p{}</style><p>Hello <b>there</b></p>",
+		Data:      "Subject: Welcome\r\n\r\nHello there\r\n",
+		CreatedAt: time.Date(2023, 7, 19, 10, 0, 0, 0, time.UTC),
+	}
+	tests := []struct {
+		name      string
+		attachEml bool
+		statuses  []int
+		wantPosts int
+		wantErr   bool
+	}{
+		{"Embed", false, []int{204}, 1, false},
+		{"Embed with eml file", true, []int{200}, 1, false},
+		{"Rate limited then sent", false, []int{429, 204}, 2, false},
+		{"Server errors exhaust retries", false, []int{502, 502, 502, 502}, 4, true},
+		{"Bad request is not retried", false, []int{400}, 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			posts := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				status := tt.statuses[posts]
+				posts++
+
+				var payload []byte
+				if tt.attachEml {
+					payload = []byte(r.FormValue("payload_json"))
+					file, header, err := r.FormFile("file")
+					if err != nil {
+						t.Errorf("FormFile() error = %v", err)
+					} else if eml, _ := ioutil.ReadAll(file); header.Filename != mail.Id+".eml" || string(eml) != mail.Data {
+						t.Errorf("file = %q %q", header.Filename, eml)
+					}
+				} else {
+					payload, _ = ioutil.ReadAll(r.Body)
+				}
+
+				var params discordgo.WebhookParams
+				if err := json.Unmarshal(payload, &params); err != nil || len(params.Embeds) != 1 {
+					t.Errorf("payload = %s", payload)
+				} else if embed := params.Embeds[0]; embed.Title != "Welcome" ||
+					embed.Description != "Hello there" ||
+					embed.URL != "https://mail.example.com/?mail="+mail.Id ||
+					embed.Timestamp != "2023-07-19T10:00:00Z" {
+					t.Errorf("embed = %+v", embed)
+				}
+
+				if status == http.StatusTooManyRequests {
+					w.Header().Set("Retry-After", "0.5")
+				}
+				w.WriteHeader(status)
+			}))
+			defer server.Close()
+
+			w := NewWebhook("https://mail.example.com/", tt.attachEml)
+			var waits []time.Duration
+			w.poster.Sleep = func(ctx context.Context, d time.Duration) error {
+				waits = append(waits, d)
+				return nil
+			}
+			err := w.Send(context.Background(), server.URL, mail)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Webhook.Send() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if posts != tt.wantPosts {
+				t.Errorf("posts = %d, want %d", posts, tt.wantPosts)
+			}
+			if tt.statuses[0] == http.StatusTooManyRequests && (len(waits) == 0 || waits[0] != 500*time.Millisecond) {
+				t.Errorf("waits = %v, want 500ms from Retry-After", waits)
+			}
+		})
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		name string
+		s    string
+		n    int
+		want string
+	}{
+		{"Short", "merhaba", 10, "merhaba"},
+		{"Multi-byte", "şşşşş", 3, "şş…"},
+		{"Exact", strings.Repeat("a", 4), 4, "aaaa"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Truncate(tt.s, tt.n); got != tt.want {
+				t.Errorf("Truncate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package events
+
+import (
+	"sync"
+
+	"discord-smtp-server/store"
+)
+
+// subscriberBuffer is how many mails a slow subscriber may fall behind
+// before new mails are dropped for it.
+const subscriberBuffer = 16
+
+// Bus fans stored mails out to the API subscribers of the same process.
+type Bus struct {
+	mu          sync.RWMutex
+	subscribers map[chan store.Mail]struct{}
+}
+
+func NewBus() *Bus {
+	return &Bus{
+		subscribers: map[chan store.Mail]struct{}{},
+	}
+}
+
+// Publish delivers mail to every subscriber without blocking.
+func (b *Bus) Publish(mail store.Mail) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for ch := range b.subscribers {
+		select {
+		case ch <- mail:
+		default:
+		}
+	}
+}
+
+// Subscribe returns a channel of published mails and a function that
+// stops the subscription and closes the channel.
+func (b *Bus) Subscribe() (<-chan store.Mail, func()) {
+	ch := make(chan store.Mail, subscriberBuffer)
+	b.mu.Lock()
+	b.subscribers[ch] = struct{}{}
+	b.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subscribers, ch)
+			b.mu.Unlock()
+			close(ch)
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package events
+
+import (
+	"testing"
+
+	"discord-smtp-server/store"
+)
+
+func TestBus(t *testing.T) {
+	bus := NewBus()
+	first, stopFirst := bus.Subscribe()
+	second, stopSecond := bus.Subscribe()
+	defer stopSecond()
+
+	bus.Publish(store.Mail{Id: "1", Subject: "hello"})
+	for _, ch := range []<-chan store.Mail{first, second} {
+		if got := <-ch; got.Id != "1" {
+			t.Errorf("received %+v, want mail 1", got)
+		}
+	}
+
+	stopFirst()
+	stopFirst()
+	if _, ok := <-first; ok {
+		t.Errorf("channel still open after stop")
+	}
+	bus.Publish(store.Mail{Id: "2"})
+	if got := <-second; got.Id != "2" {
+		t.Errorf("received %+v, want mail 2", got)
+	}
+}
+
+func TestBus_slowSubscriber(t *testing.T) {
+	bus := NewBus()
+	ch, stop := bus.Subscribe()
+	defer stop()
+
+	for i := 0; i < subscriberBuffer+5; i++ {
+		bus.Publish(store.Mail{})
+	}
+	if len(ch) != subscriberBuffer {
+		t.Errorf("buffered %d mails, want %d", len(ch), subscriberBuffer)
+	}
+}
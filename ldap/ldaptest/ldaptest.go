@@ -0,0 +1,305 @@
+// Package ldaptest runs a small LDAP directory for the tests of the
+// directory logins. It answers simple binds and searches, which is all the
+// client needs, and is not meant for anything else.
+package ldaptest
+
+import (
+	"net"
+	"strings"
+	"sync"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+)
+
+// Entry is an entry of the directory.
+type Entry struct {
+	DN         string
+	Attributes map[string][]string
+}
+
+// Values returns the values of the attribute, whose name is not case
+// sensitive.
+func (e *Entry) Values(name string) []string {
+	for attr, values := range e.Attributes {
+		if strings.EqualFold(attr, name) {
+			return values
+		}
+	}
+	return nil
+}
+
+// Directory is a local LDAP server for tests. Like many real servers it
+// accepts unauthenticated binds, a dn with an empty password.
+type Directory struct {
+	// URL is the ldap:// url of the server.
+	URL string
+
+	l         net.Listener
+	mu        sync.Mutex
+	entries   []Entry
+	passwords map[string]string
+	// binds counts the binds with a password, successful or not
+	binds int
+}
+
+// NewDirectory starts an empty directory, close it when done.
+func NewDirectory() *Directory {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+	d := &Directory{URL: "ldap://" + l.Addr().String(), l: l, passwords: map[string]string{}}
+	go d.serve()
+	return d
+}
+
+// Add adds an entry, binding as it takes password unless that is empty.
+func (d *Directory) Add(entry Entry, password string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.entries = append(d.entries, entry)
+	if password != "" {
+		d.passwords[strings.ToLower(entry.DN)] = password
+	}
+}
+
+// Binds returns the number of binds with a password so far.
+func (d *Directory) Binds() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.binds
+}
+
+func (d *Directory) Close() {
+	d.l.Close()
+}
+
+func (d *Directory) serve() {
+	for {
+		conn, err := d.l.Accept()
+		if err != nil {
+			return
+		}
+		go d.serveConn(conn)
+	}
+}
+
+func (d *Directory) serveConn(conn net.Conn) {
+	defer conn.Close()
+	for {
+		msg, err := ber.ReadPacket(conn)
+		if err != nil || len(msg.Children) < 2 {
+			return
+		}
+		id := msg.Children[0].Value
+		op := msg.Children[1]
+
+		var responses []*ber.Packet
+		switch op.Tag {
+		case ldap.ApplicationBindRequest:
+			var code uint16 = ldap.LDAPResultUnwillingToPerform
+			if len(op.Children) == 3 && op.Children[2].ClassType == ber.ClassContext && op.Children[2].Tag == 0 {
+				code = d.bind(value(op.Children[1]), value(op.Children[2]))
+			}
+			responses = append(responses, result(ldap.ApplicationBindResponse, code))
+		case ldap.ApplicationSearchRequest:
+			entries, code := d.search(op)
+			for _, entry := range entries {
+				responses = append(responses, entryPacket(entry))
+			}
+			responses = append(responses, result(ldap.ApplicationSearchResultDone, code))
+		case ldap.ApplicationExtendedRequest:
+			responses = append(responses, result(ldap.ApplicationExtendedResponse, ldap.LDAPResultProtocolError))
+		default:
+			// an unbind or an operation the directory does not know
+			return
+		}
+		for _, resp := range responses {
+			packet := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+			packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, "MessageID"))
+			packet.AppendChild(resp)
+			if _, err := conn.Write(packet.Bytes()); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (d *Directory) bind(dn, password string) uint16 {
+	if password == "" {
+		// anonymous or unauthenticated
+		return ldap.LDAPResultSuccess
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.binds++
+	if want, ok := d.passwords[strings.ToLower(dn)]; !ok || want != password {
+		return ldap.LDAPResultInvalidCredentials
+	}
+	return ldap.LDAPResultSuccess
+}
+
+// search answers a search request, whose fields are the base dn, scope,
+// deref, size limit, time limit, types only, filter and attributes.
+func (d *Directory) search(op *ber.Packet) ([]Entry, uint16) {
+	if len(op.Children) < 8 {
+		return nil, ldap.LDAPResultProtocolError
+	}
+	base := strings.ToLower(value(op.Children[0]))
+	scope, _ := op.Children[1].Value.(int64)
+	sizeLimit, _ := op.Children[3].Value.(int64)
+	filter := op.Children[6]
+	var attributes []string
+	for _, attr := range op.Children[7].Children {
+		attributes = append(attributes, value(attr))
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var found []Entry
+	for _, entry := range d.entries {
+		dn := strings.ToLower(entry.DN)
+		var inScope bool
+		switch scope {
+		case ldap.ScopeBaseObject:
+			inScope = dn == base
+		case ldap.ScopeSingleLevel:
+			inScope = strings.HasSuffix(dn, ","+base) && !strings.Contains(strings.TrimSuffix(dn, ","+base), ",")
+		default:
+			inScope = dn == base || base == "" || strings.HasSuffix(dn, ","+base)
+		}
+		if !inScope || !match(filter, &entry) {
+			continue
+		}
+		if sizeLimit > 0 && len(found) == int(sizeLimit) {
+			return found, ldap.LDAPResultSizeLimitExceeded
+		}
+		found = append(found, selectAttributes(entry, attributes))
+	}
+	return found, ldap.LDAPResultSuccess
+}
+
+// match tells whether the entry matches the filter. Attribute names and
+// values compare case-insensitively, like the caseIgnore rules of most
+// directory attributes.
+func match(filter *ber.Packet, entry *Entry) bool {
+	switch filter.Tag {
+	case ldap.FilterAnd:
+		for _, child := range filter.Children {
+			if !match(child, entry) {
+				return false
+			}
+		}
+		return true
+	case ldap.FilterOr:
+		for _, child := range filter.Children {
+			if match(child, entry) {
+				return true
+			}
+		}
+		return false
+	case ldap.FilterNot:
+		return len(filter.Children) == 1 && !match(filter.Children[0], entry)
+	case ldap.FilterPresent:
+		return len(entry.Values(value(filter))) > 0
+	}
+	if len(filter.Children) != 2 {
+		return false
+	}
+	for _, v := range entry.Values(value(filter.Children[0])) {
+		v = strings.ToLower(v)
+		switch filter.Tag {
+		case ldap.FilterEqualityMatch, ldap.FilterApproxMatch:
+			if v == strings.ToLower(value(filter.Children[1])) {
+				return true
+			}
+		case ldap.FilterGreaterOrEqual:
+			if v >= strings.ToLower(value(filter.Children[1])) {
+				return true
+			}
+		case ldap.FilterLessOrEqual:
+			if v <= strings.ToLower(value(filter.Children[1])) {
+				return true
+			}
+		case ldap.FilterSubstrings:
+			if matchSubstrings(filter.Children[1].Children, v) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func matchSubstrings(parts []*ber.Packet, v string) bool {
+	for _, part := range parts {
+		s := strings.ToLower(value(part))
+		switch part.Tag {
+		case ldap.FilterSubstringsInitial:
+			if !strings.HasPrefix(v, s) {
+				return false
+			}
+			v = v[len(s):]
+		case ldap.FilterSubstringsFinal:
+			return strings.HasSuffix(v, s)
+		default:
+			at := strings.Index(v, s)
+			if at < 0 {
+				return false
+			}
+			v = v[at+len(s):]
+		}
+	}
+	return true
+}
+
+func selectAttributes(entry Entry, names []string) Entry {
+	if len(names) == 0 {
+		return entry
+	}
+	selected := Entry{DN: entry.DN, Attributes: map[string][]string{}}
+	for _, name := range names {
+		if name == "*" {
+			return entry
+		}
+		if values := entry.Values(name); values != nil {
+			selected.Attributes[name] = values
+		}
+	}
+	return selected
+}
+
+// value reads a string, universal ones are decoded by the ber package and
+// context specific ones are left as bytes.
+func value(p *ber.Packet) string {
+	if s, ok := p.Value.(string); ok {
+		return s
+	}
+	return p.Data.String()
+}
+
+func result(tag ber.Tag, code uint16) *ber.Packet {
+	packet := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "Result")
+	packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, int64(code), "Result Code"))
+	packet.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Matched DN"))
+	packet.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Diagnostic Message"))
+	return packet
+}
+
+func entryPacket(entry Entry) *ber.Packet {
+	packet := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "Search Result Entry")
+	packet.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, entry.DN, "DN"))
+	attributes := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attributes")
+	for name, values := range entry.Attributes {
+		attribute := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attribute")
+		attribute.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, "Type"))
+		set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "Values")
+		for _, v := range values {
+			set.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, v, "Value"))
+		}
+		attribute.AppendChild(set)
+		attributes.AppendChild(attribute)
+	}
+	packet.AppendChild(attributes)
+	return packet
+}
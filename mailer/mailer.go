@@ -0,0 +1,137 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"discord-smtp-server/events"
+	"discord-smtp-server/store"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"time"
+)
+
+// Message is a mail MailTracker writes itself, like a password reset.
+type Message struct {
+	From    string
+	To      string
+	Subject string
+	Text    string
+	// Inbox files the message when it is captured instead of sent.
+	Inbox string
+}
+
+// Sender delivers the mails MailTracker writes.
+type Sender interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// Bytes formats the message as a plain text mail sent at date.
+func (m Message) Bytes(date time.Time) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", m.From)
+	fmt.Fprintf(&b, "To: %s\r\n", m.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", m.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: quoted-printable\r\n")
+	b.WriteString("\r\n")
+	w := quotedprintable.NewWriter(&b)
+	w.Write([]byte(m.Text))
+	w.Close()
+	return b.Bytes()
+}
+
+// address returns the bare address of a header value like
+// "MailTracker <noreply@example.com>".
+func address(value string) (string, error) {
+	parsed, err := mail.ParseAddress(value)
+	if err != nil {
+		return "", err
+	}
+	return parsed.Address, nil
+}
+
+// Relay sends through an outbound smtp server, with STARTTLS when the
+// server offers it and PLAIN auth when Username is set.
+type Relay struct {
+	Addr     string
+	Username string
+	Password string
+}
+
+func (r *Relay) Send(ctx context.Context, msg Message) error {
+	from, err := address(msg.From)
+	if err != nil {
+		return fmt.Errorf("invalid sender %q: %w", msg.From, err)
+	}
+	to, err := address(msg.To)
+	if err != nil {
+		return fmt.Errorf("invalid recipient %q: %w", msg.To, err)
+	}
+	var auth smtp.Auth
+	if r.Username != "" {
+		host, _, err := net.SplitHostPort(r.Addr)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", r.Username, r.Password, host)
+	}
+
+	// net/smtp takes no context, the send runs on and its result is
+	// dropped when ctx ends first
+	done := make(chan error, 1)
+	go func() {
+		done <- smtp.SendMail(r.Addr, auth, from, []string{to}, msg.Bytes(time.Now()))
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Capture stores the messages as received mails instead of sending them,
+// in the inbox of the message.
+type Capture struct {
+	Mails  store.MailStore
+	Events *events.Bus
+}
+
+func (c *Capture) Send(ctx context.Context, msg Message) error {
+	if c.Mails == nil {
+		return errors.New("capture needs a mail store")
+	}
+	now := time.Now().UTC()
+	rcpt, err := address(msg.To)
+	if err != nil {
+		rcpt = msg.To
+	}
+	mail := store.Mail{
+		Subject:     msg.Subject,
+		Data:        string(msg.Bytes(now)),
+		To:          msg.To,
+		From:        msg.From,
+		Body:        msg.Text,
+		Text:        msg.Text,
+		Rcpt:        rcpt,
+		Inbox:       msg.Inbox,
+		MimeVersion: "1.0",
+		ContentType: "text/plain; charset=utf-8",
+		Date:        now.Truncate(time.Second),
+		CreatedAt:   now,
+	}
+	if _, err := c.Mails.InsertMail(ctx, &mail); err != nil {
+		return err
+	}
+	if c.Events != nil {
+		c.Events.Publish(mail)
+	}
+	return nil
+}
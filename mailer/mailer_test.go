@@ -0,0 +1,143 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net"
+	"net/mail"
+	"testing"
+	"time"
+
+	"discord-smtp-server/events"
+	"discord-smtp-server/store"
+	"github.com/emersion/go-smtp"
+)
+
+func TestMessage_Bytes(t *testing.T) {
+	msg := Message{From: "MailTracker <noreply@example.com>", To: "ayse@example.com", Subject: "Parola sıfırlama", Text: "Bağlantı: https://example.com/?reset=abc"}
+	date := time.Date(2023, 7, 19, 10, 0, 0, 0, time.UTC)
+
+	parsed, err := mail.ReadMessage(bytes.NewReader(msg.Bytes(date)))
+	if err != nil {
+		t.Fatalf("mail.ReadMessage() error = %v", err)
+	}
+	subject, _ := new(mime.WordDecoder).DecodeHeader(parsed.Header.Get("Subject"))
+	if subject != msg.Subject || parsed.Header.Get("To") != msg.To {
+		t.Errorf("headers = %v", parsed.Header)
+	}
+	if got, _ := parsed.Header.Date(); !got.Equal(date) {
+		t.Errorf("Date = %v, want %v", got, date)
+	}
+}
+
+func TestCapture_Send(t *testing.T) {
+	db := store.NewMemory()
+	bus := events.NewBus()
+	published, stop := bus.Subscribe()
+	defer stop()
+
+	c := &Capture{Mails: db, Events: bus}
+	msg := Message{From: "MailTracker <noreply@example.com>", To: "Ayşe <ayse@example.com>", Subject: "Parola sıfırlama", Text: "hi", Inbox: "user:ayse"}
+	if err := c.Send(context.Background(), msg); err != nil {
+		t.Fatalf("Capture.Send() error = %v", err)
+	}
+
+	mails, _ := db.ListMails(context.Background(), store.MailFilter{})
+	if len(mails) != 1 || mails[0].Subject != msg.Subject || mails[0].Rcpt != "ayse@example.com" || mails[0].Inbox != msg.Inbox {
+		t.Fatalf("captured mails = %+v", mails)
+	}
+	select {
+	case mail := <-published:
+		if mail.Id != mails[0].Id {
+			t.Errorf("published %q, want %q", mail.Id, mails[0].Id)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("captured mail was not published")
+	}
+}
+
+func TestRelay_Send(t *testing.T) {
+	received := make(chan receivedMail, 1)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen() error = %v", err)
+	}
+	server := smtp.NewServer(&relayBackend{received})
+	server.Domain = "localhost"
+	go server.Serve(l)
+	defer server.Close()
+
+	tests := []struct {
+		name     string
+		msg      Message
+		wantErr  bool
+		wantFrom string
+		wantTo   string
+	}{
+		{"Sent", Message{From: "MailTracker <noreply@example.com>", To: "ayse@example.com", Subject: "Reset", Text: "hi"}, false, "noreply@example.com", "ayse@example.com"},
+		{"No recipient address", Message{From: "noreply@example.com", To: "ayse", Subject: "Reset"}, true, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &Relay{Addr: l.Addr().String()}
+			err := r.Send(context.Background(), tt.msg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Relay.Send() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			got := <-received
+			if got.from != tt.wantFrom || got.to != tt.wantTo {
+				t.Errorf("relay received %s -> %s, want %s -> %s", got.from, got.to, tt.wantFrom, tt.wantTo)
+			}
+		})
+	}
+}
+
+type receivedMail struct {
+	from string
+	to   string
+}
+
+type relayBackend struct {
+	received chan receivedMail
+}
+
+func (b *relayBackend) Login(state *smtp.ConnectionState, username, password string) (smtp.Session, error) {
+	return nil, smtp.ErrAuthUnsupported
+}
+
+func (b *relayBackend) AnonymousLogin(state *smtp.ConnectionState) (smtp.Session, error) {
+	return &relaySession{backend: b}, nil
+}
+
+type relaySession struct {
+	backend *relayBackend
+	mail    receivedMail
+}
+
+func (s *relaySession) Mail(from string, opts smtp.MailOptions) error {
+	s.mail.from = from
+	return nil
+}
+
+func (s *relaySession) Rcpt(to string) error {
+	s.mail.to = to
+	return nil
+}
+
+func (s *relaySession) Data(r io.Reader) error {
+	ioutil.ReadAll(r)
+	s.backend.received <- s.mail
+	return nil
+}
+
+func (s *relaySession) Reset() {}
+
+func (s *relaySession) Logout() error {
+	return nil
+}
@@ -1,40 +1,337 @@
 package main
 
 import (
-	_ "discord-smtp-server/tzinit"
 	"github.com/getsentry/raven-go"
+	// zone data for TIMEZONE in images without it
+	_ "time/tzdata"
 )
 
 import (
+	"context"
+	"discord-smtp-server/api"
+	"discord-smtp-server/attachment"
+	"discord-smtp-server/authn"
+	"discord-smtp-server/discord"
+	"discord-smtp-server/events"
+	"discord-smtp-server/mailer"
+	"discord-smtp-server/notify"
+	"discord-smtp-server/oidc"
+	"discord-smtp-server/password"
+	"discord-smtp-server/rbac"
 	"discord-smtp-server/smtp"
-	gosmtp "github.com/emersion/go-smtp"
+	"discord-smtp-server/store"
+	"flag"
+	"fmt"
 	"github.com/joho/godotenv"
+	"go.mongodb.org/mongo-driver/mongo"
 	"log"
+	"net/http"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 )
 
+const usage = `Usage: mailtracker <command> [flags]
+
+Commands:
+  smtp    run the SMTP server (default)
+  api     run the API and dashboard
+  serve   run both in one process sharing a store (alias: all)
+  migrate convert the data of older versions, run it once after upgrading
+  check-passwords
+          list the users whose stored password hash can never match
+
+Run "mailtracker <command> -h" for the flags of a command.
+`
+
+// envFlag is a command line flag that overrides an environment variable.
+type envFlag struct {
+	name  string
+	env   string
+	usage string
+}
+
+var (
+	commonFlags = []envFlag{
+		{"store", "STORE", "store kind: mongo, bolt or memory"},
+		{"mongo-uri", "MONGO_URI", "mongo connection string"},
+		{"mongo-db", "MONGO_TABLE_NAME", "mongo database name"},
+		{"bolt-path", "BOLT_PATH", "bolt database file"},
+		{"attachment-storage", "ATTACHMENT_STORAGE", "attachment storage: gridfs or dir"},
+		{"attachment-dir", "ATTACHMENT_DIR", "attachment directory for the dir storage"},
+		{"sentry-dsn", "SENTRY_DSN", "sentry dsn"},
+		{"host", "HOST", "public host name"},
+		{"smtp-port", "SMTP_PORT", "smtp listen port"},
+		{"smtp-username", "SMTP_USERNAME", "smtp auth username"},
+		{"smtp-password", "SMTP_PASSWORD", "smtp auth password"},
+		{"smtp-tls-cert", "SMTP_TLS_CERT", "tls certificate file, enables STARTTLS"},
+		{"smtp-tls-key", "SMTP_TLS_KEY", "tls key file"},
+		{"smtp-tls-self-signed", "SMTP_TLS_SELF_SIGNED", "enable STARTTLS with a generated self-signed certificate, for development"},
+		{"smtps-port", "SMTPS_PORT", "implicit tls listen port, usually 465"},
+		{"password-hash", "PASSWORD_HASH", "hash for new passwords: bcrypt (default) or argon2id"},
+		{"password-bcrypt-cost", "PASSWORD_BCRYPT_COST", "bcrypt cost (default 10)"},
+		{"password-argon2-time", "PASSWORD_ARGON2_TIME", "argon2id passes (default 1)"},
+		{"password-argon2-memory", "PASSWORD_ARGON2_MEMORY", "argon2id memory in KiB (default 65536)"},
+		{"dashboard-url", "DASHBOARD_URL", "public dashboard url linked from notifications and password reset mails"},
+		{"timezone", "TIMEZONE", "time zone of the logs and server formatted times, like Europe/Istanbul (default: the system zone)"},
+	}
+	smtpFlags = []envFlag{
+		{"discord-webhook", "DISCORD_WEBHOOK", "fallback discord webhook for new mails"},
+		{"discord-token", "DISCORD_TOKEN", "discord bot token, routes user@channel.guild recipients to webhooks"},
+		{"discord-reject-unknown", "DISCORD_REJECT_UNKNOWN", "reject recipients without a webhook instead of using the fallback"},
+		{"discord-cache-ttl", "DISCORD_CACHE_TTL", "how long webhook lookups are cached (default 5m)"},
+		{"discord-attach-eml", "DISCORD_ATTACH_EML", "attach the original message as an .eml file to notifications"},
+		{"notify-mailboxes", "NOTIFY_MAILBOXES", "json file of slack, teams, discord and webhook targets per recipient address"},
+	}
+	apiFlags = []envFlag{
+		{"port", "PORT", "api listen port"},
+		{"jwt-secret", "JWT_SECRET", "secret used to sign api tokens"},
+		{"mail-relay", "MAIL_RELAY", "host:port of the smtp server password reset mails are sent through, they are captured into the admin inbox when empty"},
+		{"mail-relay-username", "MAIL_RELAY_USERNAME", "mail relay auth username"},
+		{"mail-relay-password", "MAIL_RELAY_PASSWORD", "mail relay auth password"},
+		{"mail-from", "MAIL_FROM", "sender of password reset mails (default: MailTracker <noreply@HOST>)"},
+		{"oidc-issuer", "OIDC_ISSUER", "openid connect provider url, enables single sign-on"},
+		{"oidc-client-id", "OIDC_CLIENT_ID", "client id registered at the provider"},
+		{"oidc-client-secret", "OIDC_CLIENT_SECRET", "client secret registered at the provider"},
+		{"oidc-redirect-url", "OIDC_REDIRECT_URL", "callback registered at the provider (default: DASHBOARD_URL/api/oidc/callback)"},
+		{"oidc-scopes", "OIDC_SCOPES", "comma separated scopes (default: openid,profile,email)"},
+		{"oidc-groups-claim", "OIDC_GROUPS_CLAIM", "id token claim with the groups of the user (default: groups)"},
+		{"oidc-role-map", "OIDC_ROLE_MAP", "group=role pairs separated by commas, the first group of the user wins"},
+		{"oidc-default-role", "OIDC_DEFAULT_ROLE", "role of users in no mapped group, none refuses them (default: watcher)"},
+	}
+	ldapFlags = []envFlag{
+		{"ldap-url", "LDAP_URL", "ldap:// or ldaps:// url of a directory the dashboard and smtp logins are checked against"},
+		{"ldap-start-tls", "LDAP_START_TLS", "upgrade ldap:// connections with StartTLS"},
+		{"ldap-bind-dn", "LDAP_BIND_DN", "service account the user searches run as (default: anonymous)"},
+		{"ldap-bind-password", "LDAP_BIND_PASSWORD", "service account password"},
+		{"ldap-base-dn", "LDAP_BASE_DN", "where users are searched"},
+		{"ldap-user-filter", "LDAP_USER_FILTER", "filter finding the user, %s is the username (default: (uid=%s))"},
+		{"ldap-username-attribute", "LDAP_USERNAME_ATTRIBUTE", "attribute with the stored username (default: uid)"},
+		{"ldap-email-attribute", "LDAP_EMAIL_ATTRIBUTE", "attribute with the email of the user (default: mail)"},
+		{"ldap-trust-email", "LDAP_TRUST_EMAIL", "link directory users to existing users with the same username and email"},
+		{"ldap-group-attribute", "LDAP_GROUP_ATTRIBUTE", "attribute of the user with their group dns (default: memberOf)"},
+		{"ldap-group-base-dn", "LDAP_GROUP_BASE_DN", "where groups are searched with LDAP_GROUP_FILTER (default: LDAP_BASE_DN)"},
+		{"ldap-group-filter", "LDAP_GROUP_FILTER", "filter finding the groups of a user, %s is the user dn, like (member=%s)"},
+		{"ldap-role-map", "LDAP_ROLE_MAP", "group=role pairs separated by commas, groups by cn, the first mapping the user is in wins"},
+		{"ldap-default-role", "LDAP_DEFAULT_ROLE", "role of users in no mapped group, none refuses them (default: watcher)"},
+		{"ldap-local-users", "LDAP_LOCAL_USERS", "false stops checking the passwords of local users after the directory (default: true)"},
+	}
+)
+
 func main() {
-	err := godotenv.Load()
+	command := "smtp"
+	args := os.Args[1:]
+	if len(args) > 0 && args[0] != "" && args[0][0] != '-' {
+		command, args = args[0], args[1:]
+	}
+
+	switch command {
+	case "smtp":
+		setup(command, args, commonFlags, smtpFlags, ldapFlags)
+		st, attachments := openStore()
+		runSMTP(st, attachments, nil)
+	case "api":
+		setup(command, args, commonFlags, apiFlags, ldapFlags)
+		st, attachments := openStore()
+		bus := events.NewBus()
+		go watchMails(st, bus)
+		runAPI(st, attachments, bus)
+	case "serve", "all":
+		setup(command, args, commonFlags, smtpFlags, apiFlags, ldapFlags)
+		st, attachments := openStore()
+		bus := events.NewBus()
+		go runSMTP(st, attachments, bus)
+		runAPI(st, attachments, bus)
+	case "migrate":
+		setup(command, args, commonFlags)
+		st, _ := openStore()
+		migrate(st)
+	case "check-passwords":
+		setup(command, args, commonFlags)
+		st, _ := openStore()
+		checkPasswords(st)
+	case "help", "-h", "--help":
+		fmt.Print(usage)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", command, usage)
+		os.Exit(2)
+	}
+}
+
+// setup parses the command flags, copies the ones given into the
+// environment and then loads the .env file, which never overrides them.
+func setup(command string, args []string, groups ...[]envFlag) {
+	fs := flag.NewFlagSet(command, flag.ExitOnError)
+	envFile := fs.String("env", ".env", "environment file to load")
+	values := map[string]*string{}
+	for _, group := range groups {
+		for _, f := range group {
+			values[f.name] = fs.String(f.name, "", f.usage+" ($"+f.env+")")
+		}
+	}
+	fs.Parse(args)
+
+	fs.Visit(func(set *flag.Flag) {
+		for _, group := range groups {
+			for _, f := range group {
+				if f.name == set.Name {
+					os.Setenv(f.env, *values[f.name])
+				}
+			}
+		}
+	})
+
+	err := godotenv.Load(*envFile)
 	if err != nil {
-		log.Fatal("Error loading .env file")
+		if !os.IsNotExist(err) || *envFile != ".env" {
+			log.Fatal("Error loading .env file")
+		}
+		log.Println("No .env file, using the environment and flags")
 	}
+
 	err = raven.SetDSN(os.Getenv("SENTRY_DSN"))
 	if err != nil {
 		log.Fatal("Error setting sentry dsn")
+	}
+
+	// times are stored in UTC, the zone only changes how they are shown
+	if os.Getenv("TIMEZONE") != "" {
+		location, err := time.LoadLocation(os.Getenv("TIMEZONE"))
+		if err != nil {
+			log.Fatal("Invalid TIMEZONE: ", err)
+		}
+		time.Local = location
+	}
+}
+
+func openStore() (store.Store, attachment.Storage) {
+	st, err := store.Open(context.TODO(), store.Config{
+		Kind:     os.Getenv("STORE"),
+		MongoURI: os.Getenv("MONGO_URI"),
+		Database: os.Getenv("MONGO_TABLE_NAME"),
+		BoltPath: os.Getenv("BOLT_PATH"),
+	})
+	if err != nil {
+		raven.CaptureErrorAndWait(err, nil)
+		log.Fatal(err)
+	}
+	var db *mongo.Database
+	if m, ok := st.(*store.Mongo); ok {
+		db = m.Database()
+	}
+	attachments, err := attachment.New(os.Getenv("ATTACHMENT_STORAGE"), db, os.Getenv("ATTACHMENT_DIR"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	return st, attachments
+}
+
+// migrate converts the createdat text of older versions into times.
+func migrate(st store.Store) {
+	migrator, ok := st.(store.Migrator)
+	if !ok {
+		log.Println("Nothing to migrate in a", os.Getenv("STORE"), "store")
 		return
 	}
-	backend, err := smtp.NewBackend(
-		os.Getenv("MONGO_URI"),
-		os.Getenv("DISCORD_WEBHOOK"),
-		os.Getenv("SMTP_USERNAME"),
-		os.Getenv("SMTP_PASSWORD"),
-	)
+	changed, err := migrator.MigrateTimestamps(context.Background())
+	if err != nil {
+		log.Fatal("Migrating timestamps failed: ", err)
+	}
+	log.Println("Migrated the timestamps of", changed, "documents")
+}
+
+// checkPasswords lists the users whose password hash is broken, they can
+// only log in again after an admin sets a new password. It exits with 1
+// when there are any.
+func checkPasswords(st store.Store) {
+	users, err := st.ListUsers(context.Background())
 	if err != nil {
 		log.Fatal(err)
 	}
+	broken := 0
+	for _, user := range users {
+		// users of the single sign-on or the directory may have no
+		// password
+		if user.Subject != "" && user.Password == "" {
+			continue
+		}
+		if !password.Valid(user.Password) {
+			fmt.Println(user.Id, user.Username)
+			broken++
+		}
+	}
+	if broken > 0 {
+		log.Println(broken, "of", len(users), "users have an invalid password hash")
+		os.Exit(1)
+	}
+	log.Println("The password hashes of all", len(users), "users are valid")
+}
 
-	server := gosmtp.NewServer(backend)
+// passwordHasher returns the hasher configured by the PASSWORD_*
+// variables.
+func passwordHasher() *password.Hasher {
+	cfg := password.Config{Algorithm: os.Getenv("PASSWORD_HASH")}
+	for _, v := range []struct {
+		env   string
+		value func(n uint64)
+		bits  int
+	}{
+		{"PASSWORD_BCRYPT_COST", func(n uint64) { cfg.BcryptCost = int(n) }, 8},
+		{"PASSWORD_ARGON2_TIME", func(n uint64) { cfg.Argon2Time = uint32(n) }, 32},
+		{"PASSWORD_ARGON2_MEMORY", func(n uint64) { cfg.Argon2Memory = uint32(n) }, 32},
+	} {
+		if os.Getenv(v.env) == "" {
+			continue
+		}
+		n, err := strconv.ParseUint(os.Getenv(v.env), 10, v.bits)
+		if err != nil {
+			log.Fatal("Invalid ", v.env, ": ", err)
+		}
+		v.value(n)
+	}
+	hasher, err := password.New(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return hasher
+}
+
+func runSMTP(st store.Store, attachments attachment.Storage, bus *events.Bus) {
+	var mailboxes notify.Mailboxes
+	if os.Getenv("NOTIFY_MAILBOXES") != "" {
+		var err error
+		mailboxes, err = notify.LoadMailboxes(os.Getenv("NOTIFY_MAILBOXES"))
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	backend, err := smtp.NewBackend(smtp.Config{
+		Mails:         st,
+		Users:         st,
+		Credentials:   st,
+		Authenticator: authenticator(st),
+		Passwords:     passwordHasher(),
+		Projects:      st,
+		Roles:         st,
+		Quarantine:    st,
+		Attachments:   attachments,
+		Events:        bus,
+		Resolver:      webhookResolver(),
+		RejectUnknown: os.Getenv("DISCORD_REJECT_UNKNOWN") == "true",
+		Webhook:       os.Getenv("DISCORD_WEBHOOK"),
+		Mailboxes:     mailboxes,
+		Notify: notify.Options{
+			DashboardURL: os.Getenv("DASHBOARD_URL"),
+			AttachEml:    os.Getenv("DISCORD_ATTACH_EML") == "true",
+		},
+		Username: os.Getenv("SMTP_USERNAME"),
+		Password: os.Getenv("SMTP_PASSWORD"),
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	port := ":1025"
 	if os.Getenv("SMTP_PORT") != "" {
@@ -46,16 +343,185 @@ func main() {
 		host = os.Getenv("HOST")
 	}
 
-	server.Addr = port
-	server.Domain = host
-	server.ReadTimeout = 10 * time.Second
-	server.WriteTimeout = 10 * time.Second
-	server.MaxMessageBytes = 1024 * 1024
-	server.MaxRecipients = 50
-	server.AllowInsecureAuth = true
+	tlsConfig, err := smtp.TLSConfig(
+		os.Getenv("SMTP_TLS_CERT"),
+		os.Getenv("SMTP_TLS_KEY"),
+		os.Getenv("SMTP_TLS_SELF_SIGNED") == "true",
+		host,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
 
+	if os.Getenv("SMTPS_PORT") != "" {
+		if tlsConfig == nil {
+			log.Fatal("SMTPS_PORT needs SMTP_TLS_CERT and SMTP_TLS_KEY, or SMTP_TLS_SELF_SIGNED")
+		}
+		tlsServer := smtp.NewServer(backend, ":"+os.Getenv("SMTPS_PORT"), host, tlsConfig)
+		go func() {
+			log.Println("Starting implicit TLS server at", tlsServer.Addr)
+			if err := tlsServer.ListenAndServeTLS(); err != nil {
+				log.Fatal(err)
+			}
+		}()
+	}
+
+	server := smtp.NewServer(backend, port, host, tlsConfig)
+	if tlsConfig != nil {
+		log.Println("STARTTLS enabled, AUTH needs TLS")
+	}
 	log.Println("Starting server at", server.Addr)
 	if err := server.ListenAndServe(); err != nil {
 		log.Fatal(err)
 	}
 }
+
+func webhookResolver() smtp.WebhookResolver {
+	token := os.Getenv("DISCORD_TOKEN")
+	if token == "" {
+		return nil
+	}
+
+	client, err := discord.NewClient(token)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ttl := 5 * time.Minute
+	if os.Getenv("DISCORD_CACHE_TTL") != "" {
+		ttl, err = time.ParseDuration(os.Getenv("DISCORD_CACHE_TTL"))
+		if err != nil {
+			log.Fatal("Invalid DISCORD_CACHE_TTL: ", err)
+		}
+	}
+
+	return discord.NewResolver(client, ttl)
+}
+
+func runAPI(st store.Store, attachments attachment.Storage, bus *events.Bus) {
+	log.Default().SetFlags(log.LstdFlags | log.Lshortfile)
+	log.Println("Starting server at", os.Getenv("HOST")+":"+os.Getenv("PORT"))
+	log.Println("Sentry DSN: " + os.Getenv("SENTRY_DSN"))
+
+	http.DefaultClient.Timeout = time.Minute * 10
+	router := api.NewRouter(st, attachments, bus, passwordHasher(), mailSender(st, bus), oidcProvider(), authenticator(st))
+	if err := router.Run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// mailSender returns the sender of the mails MailTracker writes, the
+// MAIL_RELAY server or else the store itself.
+func mailSender(st store.Store, bus *events.Bus) mailer.Sender {
+	if os.Getenv("MAIL_RELAY") == "" {
+		return &mailer.Capture{Mails: st, Events: bus}
+	}
+	return &mailer.Relay{
+		Addr:     os.Getenv("MAIL_RELAY"),
+		Username: os.Getenv("MAIL_RELAY_USERNAME"),
+		Password: os.Getenv("MAIL_RELAY_PASSWORD"),
+	}
+}
+
+// authenticator checks the logins against the LDAP_URL directory and then
+// the local users, or only the local users without a directory.
+func authenticator(st store.Store) authn.Authenticator {
+	local := &authn.Local{Users: st, Passwords: passwordHasher()}
+	if os.Getenv("LDAP_URL") == "" {
+		return local
+	}
+	roles, err := rbac.ParseRoleMap(os.Getenv("LDAP_ROLE_MAP"))
+	if err != nil {
+		log.Fatal("Invalid LDAP_ROLE_MAP: ", err)
+	}
+	directory := &authn.LDAP{
+		URL:               os.Getenv("LDAP_URL"),
+		StartTLS:          os.Getenv("LDAP_START_TLS") == "true",
+		BindDN:            os.Getenv("LDAP_BIND_DN"),
+		BindPassword:      os.Getenv("LDAP_BIND_PASSWORD"),
+		BaseDN:            os.Getenv("LDAP_BASE_DN"),
+		UserFilter:        os.Getenv("LDAP_USER_FILTER"),
+		UsernameAttribute: os.Getenv("LDAP_USERNAME_ATTRIBUTE"),
+		EmailAttribute:    os.Getenv("LDAP_EMAIL_ATTRIBUTE"),
+		TrustEmail:        os.Getenv("LDAP_TRUST_EMAIL") == "true",
+		GroupAttribute:    os.Getenv("LDAP_GROUP_ATTRIBUTE"),
+		GroupBaseDN:       os.Getenv("LDAP_GROUP_BASE_DN"),
+		GroupFilter:       os.Getenv("LDAP_GROUP_FILTER"),
+		Roles:             roles,
+		DefaultRole:       os.Getenv("LDAP_DEFAULT_ROLE"),
+		Users:             st,
+		RoleStore:         st,
+	}
+	switch directory.DefaultRole {
+	case "":
+		directory.DefaultRole = rbac.RoleWatcher
+	case "none":
+		directory.DefaultRole = ""
+	}
+	if os.Getenv("LDAP_LOCAL_USERS") == "false" {
+		return directory
+	}
+	return authn.Chain{directory, local}
+}
+
+// oidcProvider returns the single sign-on provider configured by the
+// OIDC_* variables, nil when OIDC_ISSUER is empty.
+func oidcProvider() *oidc.Provider {
+	if os.Getenv("OIDC_ISSUER") == "" {
+		return nil
+	}
+	roles, err := rbac.ParseRoleMap(os.Getenv("OIDC_ROLE_MAP"))
+	if err != nil {
+		log.Fatal("Invalid OIDC_ROLE_MAP: ", err)
+	}
+	cfg := oidc.Config{
+		Issuer:       os.Getenv("OIDC_ISSUER"),
+		ClientID:     os.Getenv("OIDC_CLIENT_ID"),
+		ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+		RedirectURL:  os.Getenv("OIDC_REDIRECT_URL"),
+		GroupsClaim:  os.Getenv("OIDC_GROUPS_CLAIM"),
+		Roles:        roles,
+		DefaultRole:  os.Getenv("OIDC_DEFAULT_ROLE"),
+	}
+	if cfg.RedirectURL == "" {
+		if os.Getenv("DASHBOARD_URL") == "" {
+			log.Fatal("OIDC_REDIRECT_URL or DASHBOARD_URL is required for single sign-on")
+		}
+		cfg.RedirectURL = strings.TrimSuffix(os.Getenv("DASHBOARD_URL"), "/") + "/api/oidc/callback"
+	}
+	if os.Getenv("OIDC_SCOPES") != "" {
+		cfg.Scopes = strings.Split(os.Getenv("OIDC_SCOPES"), ",")
+	}
+	switch cfg.DefaultRole {
+	case "":
+		cfg.DefaultRole = rbac.RoleWatcher
+	case "none":
+		cfg.DefaultRole = ""
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	provider, err := oidc.New(ctx, cfg)
+	if err != nil {
+		log.Fatal("OIDC provider: ", err)
+	}
+	log.Println("Single sign-on through", cfg.Issuer)
+	return provider
+}
+
+// watchMails publishes mails stored by a separate smtp process, when the
+// store can report them.
+func watchMails(st store.Store, bus *events.Bus) {
+	watcher, ok := st.(store.MailWatcher)
+	if !ok {
+		return
+	}
+	mails, err := watcher.WatchMails(context.Background())
+	if err != nil {
+		log.Println("Mail stream disabled:", err)
+		return
+	}
+	for mail := range mails {
+		bus.Publish(mail)
+	}
+}
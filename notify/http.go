@@ -0,0 +1,76 @@
+package notify
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"discord-smtp-server/store"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// SignatureHeader carries the hex hmac-sha256 of the body, keyed with the
+// webhook secret.
+const SignatureHeader = "X-MailTracker-Signature"
+
+// HTTP posts a json event to any url, signed when a secret is set.
+type HTTP struct {
+	URL     string
+	Secret  string
+	Options Options
+}
+
+type mailEvent struct {
+	Event string       `json:"event"`
+	Mail  mailEventDto `json:"mail"`
+}
+
+type mailEventDto struct {
+	Id          string             `json:"id"`
+	Subject     string             `json:"subject"`
+	From        string             `json:"from"`
+	To          string             `json:"to"`
+	Cc          string             `json:"cc"`
+	Rcpt        string             `json:"rcpt"`
+	Text        string             `json:"text"`
+	Url         string             `json:"url,omitempty"`
+	Attachments []store.Attachment `json:"attachments"`
+	CreatedAt   time.Time          `json:"createdat"`
+}
+
+func (h *HTTP) Notify(ctx context.Context, mail store.Mail) error {
+	body, err := json.Marshal(mailEvent{
+		Event: "mail.received",
+		Mail: mailEventDto{
+			Id:          mail.Id,
+			Subject:     mail.Subject,
+			From:        mail.From,
+			To:          mail.To,
+			Cc:          mail.Cc,
+			Rcpt:        mail.Rcpt,
+			Text:        mail.PlainText(),
+			Url:         h.Options.mailURL(mail),
+			Attachments: mail.Attachments,
+			CreatedAt:   mail.CreatedAt,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	header := http.Header{}
+	if h.Secret != "" {
+		header.Set(SignatureHeader, "sha256="+Sign(h.Secret, body))
+	}
+	return poster.Post(ctx, h.URL, "application/json", body, header)
+}
+
+// Sign returns the hex hmac-sha256 of body, receivers compare it with
+// the value after "sha256=" in SignatureHeader.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,132 @@
+package notify
+
+import (
+	"context"
+	"discord-smtp-server/discord"
+	"discord-smtp-server/store"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// kinds of notifiers
+const (
+	KindDiscord = "discord"
+	KindSlack   = "slack"
+	KindTeams   = "teams"
+	KindWebhook = "webhook"
+)
+
+// Notifier tells a chat or service about a new mail.
+type Notifier interface {
+	Notify(ctx context.Context, mail store.Mail) error
+}
+
+// Options are shared by every notifier.
+type Options struct {
+	// DashboardURL is the public address of the api, mails link to
+	// DashboardURL/?mail=:id, which opens them in the dashboard.
+	DashboardURL string
+	// AttachEml sends the original message along where the service
+	// accepts files.
+	AttachEml bool
+}
+
+func (o Options) mailURL(mail store.Mail) string {
+	if o.DashboardURL == "" || mail.Id == "" {
+		return ""
+	}
+	return strings.TrimRight(o.DashboardURL, "/") + "/?mail=" + mail.Id
+}
+
+// New returns the notifier posting to the webhook.
+func New(webhook store.Webhook, opts Options) (Notifier, error) {
+	if err := Validate(webhook); err != nil {
+		return nil, err
+	}
+	switch webhook.Kind {
+	case KindDiscord:
+		return &discordNotifier{discord.NewWebhook(opts.DashboardURL, opts.AttachEml), webhook.URL}, nil
+	case KindSlack:
+		return &Slack{URL: webhook.URL, Options: opts}, nil
+	case KindTeams:
+		return &Teams{URL: webhook.URL, Options: opts}, nil
+	default:
+		return &HTTP{URL: webhook.URL, Secret: webhook.Secret, Options: opts}, nil
+	}
+}
+
+// Validate checks the webhook has a known kind and an http url.
+func Validate(webhook store.Webhook) error {
+	switch webhook.Kind {
+	case KindDiscord, KindSlack, KindTeams, KindWebhook:
+	default:
+		return fmt.Errorf("unknown webhook kind %q", webhook.Kind)
+	}
+	u, err := url.Parse(webhook.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid webhook url %q", webhook.URL)
+	}
+	return nil
+}
+
+type discordNotifier struct {
+	webhook *discord.Webhook
+	url     string
+}
+
+func (d *discordNotifier) Notify(ctx context.Context, mail store.Mail) error {
+	return d.webhook.Send(ctx, d.url, mail)
+}
+
+// Mailboxes maps recipient addresses to the webhooks their mails are
+// posted to. Keys are lower case addresses, "*@domain" or "*".
+type Mailboxes map[string][]store.Webhook
+
+// LoadMailboxes reads a json file of the form
+// {"alerts@example.com": [{"kind": "slack", "url": "..."}]}.
+func LoadMailboxes(path string) (Mailboxes, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var raw Mailboxes
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	mailboxes := Mailboxes{}
+	for address, webhooks := range raw {
+		for _, webhook := range webhooks {
+			if err := Validate(webhook); err != nil {
+				return nil, fmt.Errorf("%s: %s: %w", path, address, err)
+			}
+		}
+		mailboxes[strings.ToLower(address)] = webhooks
+	}
+	return mailboxes, nil
+}
+
+// For returns the webhooks configured for a recipient address.
+func (m Mailboxes) For(address string) []store.Webhook {
+	address = strings.ToLower(address)
+	webhooks := append([]store.Webhook{}, m[address]...)
+	if i := strings.LastIndexByte(address, '@'); i >= 0 {
+		webhooks = append(webhooks, m["*"+address[i:]]...)
+	}
+	return append(webhooks, m["*"]...)
+}
+
+// poster posts the notifications, its Sleep is replaced in tests.
+var poster = discord.NewPoster()
+
+// postJSON posts v to the url, retrying rate limited and failed requests.
+func postJSON(ctx context.Context, url string, v interface{}, header http.Header) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return poster.Post(ctx, url, "application/json", body, header)
+}
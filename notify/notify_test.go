@@ -0,0 +1,193 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"discord-smtp-server/store"
+)
+
+var testMail = store.Mail{
+	Id:      "64b7f0c2a1b2c3d4e5f60718",
+	Subject: "Welcome",
+	From:    "from@example.com",
+	To:      "to@example.com",
+	Text:    "Hello there",
+}
+
+func TestNotifiers(t *testing.T) {
+	poster.Sleep = func(ctx context.Context, d time.Duration) error { return nil }
+	opts := Options{DashboardURL: "https://mail.example.com"}
+	tests := []struct {
+		name     string
+		webhook  store.Webhook
+		statuses []int
+		check    func(t *testing.T, r *http.Request, body []byte)
+		wantErr  bool
+	}{
+		{
+			"Slack blocks",
+			store.Webhook{Kind: KindSlack},
+			[]int{200},
+			func(t *testing.T, r *http.Request, body []byte) {
+				var msg struct {
+					Text   string       `json:"text"`
+					Blocks []slackBlock `json:"blocks"`
+				}
+				json.Unmarshal(body, &msg)
+				if msg.Text != "New mail: Welcome" || len(msg.Blocks) != 4 || msg.Blocks[3].Elements[0].URL != "https://mail.example.com/?mail="+testMail.Id {
+					t.Errorf("slack message = %s", body)
+				}
+			},
+			false,
+		},
+		{
+			"Teams card",
+			store.Webhook{Kind: KindTeams},
+			[]int{200},
+			func(t *testing.T, r *http.Request, body []byte) {
+				var card map[string]interface{}
+				json.Unmarshal(body, &card)
+				if card["@type"] != "MessageCard" || card["title"] != "Welcome" || card["potentialAction"] == nil {
+					t.Errorf("teams card = %s", body)
+				}
+			},
+			false,
+		},
+		{
+			"Signed json webhook",
+			store.Webhook{Kind: KindWebhook, Secret: "s3cret"},
+			[]int{200},
+			func(t *testing.T, r *http.Request, body []byte) {
+				if got := r.Header.Get(SignatureHeader); got != "sha256="+Sign("s3cret", body) {
+					t.Errorf("%s = %q", SignatureHeader, got)
+				}
+				var event mailEvent
+				json.Unmarshal(body, &event)
+				if event.Event != "mail.received" || event.Mail.Id != testMail.Id || event.Mail.Text != "Hello there" {
+					t.Errorf("event = %s", body)
+				}
+			},
+			false,
+		},
+		{
+			"Unsigned json webhook",
+			store.Webhook{Kind: KindWebhook},
+			[]int{200},
+			func(t *testing.T, r *http.Request, body []byte) {
+				if r.Header.Get(SignatureHeader) != "" {
+					t.Errorf("unexpected signature without a secret")
+				}
+			},
+			false,
+		},
+		{
+			"Rate limit is retried",
+			store.Webhook{Kind: KindSlack},
+			[]int{429, 200},
+			nil,
+			false,
+		},
+		{
+			"Client error is not retried",
+			store.Webhook{Kind: KindTeams},
+			[]int{400},
+			nil,
+			true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			posts := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := ioutil.ReadAll(r.Body)
+				if tt.check != nil {
+					tt.check(t, r, body)
+				}
+				w.WriteHeader(tt.statuses[posts])
+				posts++
+			}))
+			defer server.Close()
+
+			tt.webhook.URL = server.URL
+			n, err := New(tt.webhook, opts)
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+			err = n.Notify(context.Background(), testMail)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Notify() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if posts != len(tt.statuses) {
+				t.Errorf("posts = %d, want %d", posts, len(tt.statuses))
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		webhook store.Webhook
+		wantErr bool
+	}{
+		{"Discord", store.Webhook{Kind: KindDiscord, URL: "https://discord.com/api/webhooks/1/x"}, false},
+		{"Unknown kind", store.Webhook{Kind: "irc", URL: "https://example.com"}, true},
+		{"Missing url", store.Webhook{Kind: KindSlack}, true},
+		{"Not http", store.Webhook{Kind: KindWebhook, URL: "file:///etc/passwd"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := Validate(tt.webhook); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadMailboxes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mailboxes.json")
+	os.WriteFile(path, []byte(`{
+		"Ops@Example.com": [{"kind": "slack", "url": "https://slack/ops"}],
+		"*@example.com": [{"kind": "teams", "url": "https://teams/example"}],
+		"*": [{"kind": "webhook", "url": "https://hooks/all", "secret": "x"}]
+	}`), 0644)
+
+	mailboxes, err := LoadMailboxes(path)
+	if err != nil {
+		t.Fatalf("LoadMailboxes() error = %v", err)
+	}
+	tests := []struct {
+		name    string
+		address string
+		want    []string
+	}{
+		{"Exact, domain and catch all", "ops@example.com", []string{"https://slack/ops", "https://teams/example", "https://hooks/all"}},
+		{"Domain and catch all", "dev@example.com", []string{"https://teams/example", "https://hooks/all"}},
+		{"Catch all", "someone@other.com", []string{"https://hooks/all"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, w := range mailboxes.For(tt.address) {
+				got = append(got, w.URL)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Mailboxes.For() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	os.WriteFile(path, []byte(`{"a@b.c": [{"kind": "fax", "url": "https://x"}]}`), 0644)
+	if _, err := LoadMailboxes(path); err == nil {
+		t.Errorf("LoadMailboxes() with an unknown kind succeeded")
+	}
+}
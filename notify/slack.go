@@ -0,0 +1,50 @@
+package notify
+
+import (
+	"context"
+	"discord-smtp-server/discord"
+	"discord-smtp-server/store"
+)
+
+// Slack posts to a slack incoming webhook.
+type Slack struct {
+	URL     string
+	Options Options
+}
+
+type slackText struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+type slackBlock struct {
+	Type     string       `json:"type"`
+	Text     *slackText   `json:"text,omitempty"`
+	Fields   []slackText  `json:"fields,omitempty"`
+	Elements []slackBlock `json:"elements,omitempty"`
+	URL      string       `json:"url,omitempty"`
+}
+
+func (s *Slack) Notify(ctx context.Context, mail store.Mail) error {
+	subject := discord.OrDash(mail.Subject)
+	blocks := []slackBlock{
+		{Type: "header", Text: &slackText{"plain_text", discord.Truncate(subject, 150)}},
+		{Type: "section", Fields: []slackText{
+			{"mrkdwn", "*From*\n" + discord.OrDash(mail.From)},
+			{"mrkdwn", "*To*\n" + discord.OrDash(mail.To)},
+		}},
+	}
+	if text := mail.PlainText(); text != "" {
+		blocks = append(blocks, slackBlock{Type: "section", Text: &slackText{"plain_text", discord.Truncate(text, 1000)}})
+	}
+	if url := s.Options.mailURL(mail); url != "" {
+		blocks = append(blocks, slackBlock{Type: "actions", Elements: []slackBlock{
+			{Type: "button", Text: &slackText{"plain_text", "MailTracker'da aç"}, URL: url},
+		}})
+	}
+
+	return postJSON(ctx, s.URL, map[string]interface{}{
+		"text":   "New mail: " + subject,
+		"blocks": blocks,
+	}, nil)
+}
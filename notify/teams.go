@@ -0,0 +1,45 @@
+package notify
+
+import (
+	"context"
+	"discord-smtp-server/discord"
+	"discord-smtp-server/store"
+)
+
+// Teams posts a message card to a microsoft teams connector.
+type Teams struct {
+	URL     string
+	Options Options
+}
+
+type teamsFact struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+func (t *Teams) Notify(ctx context.Context, mail store.Mail) error {
+	subject := discord.OrDash(mail.Subject)
+	card := map[string]interface{}{
+		"@type":      "MessageCard",
+		"@context":   "https://schema.org/extensions",
+		"summary":    "New mail: " + subject,
+		"themeColor": "5865F2",
+		"title":      subject,
+		"sections": []map[string]interface{}{{
+			"facts": []teamsFact{
+				{"From", discord.OrDash(mail.From)},
+				{"To", discord.OrDash(mail.To)},
+			},
+			"text": discord.Truncate(mail.PlainText(), 1000),
+		}},
+	}
+	if url := t.Options.mailURL(mail); url != "" {
+		card["potentialAction"] = []map[string]interface{}{{
+			"@type":   "OpenUri",
+			"name":    "MailTracker'da aç",
+			"targets": []map[string]string{{"os": "default", "uri": url}},
+		}}
+	}
+
+	return postJSON(ctx, t.URL, card, nil)
+}
@@ -0,0 +1,337 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"discord-smtp-server/rbac"
+	"github.com/golang-jwt/jwt"
+)
+
+// Config is the client registered at the provider.
+type Config struct {
+	// Issuer is the provider url, its discovery document is read from
+	// Issuer/.well-known/openid-configuration.
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is the callback the provider sends the code to.
+	RedirectURL string
+	// Scopes are asked next to openid, profile and email when empty.
+	Scopes []string
+	// GroupsClaim names the id token claim with the groups of the user,
+	// groups when empty.
+	GroupsClaim string
+	// Roles map groups to roles, the first one the user is in wins.
+	Roles []rbac.RoleMapping
+	// DefaultRole is given to users in none of the mapped groups, they
+	// are refused when it is empty.
+	DefaultRole string
+	Client      *http.Client
+}
+
+var (
+	ErrNoRole = errors.New("user is in none of the mapped groups")
+	// ErrInvalidToken is returned for id tokens that fail verification.
+	ErrInvalidToken = errors.New("invalid id token")
+)
+
+// Claims are the verified claims of an id token.
+type Claims struct {
+	Subject           string
+	Email             string
+	EmailVerified     bool
+	Name              string
+	PreferredUsername string
+	Groups            []string
+}
+
+// Username is the name a new user gets: the preferred username, the
+// email or the subject.
+func (c *Claims) Username() string {
+	switch {
+	case c.PreferredUsername != "":
+		return c.PreferredUsername
+	case c.Email != "":
+		return c.Email
+	}
+	return c.Subject
+}
+
+// discovery is the part of the discovery document the login flow uses.
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JwksURI               string `json:"jwks_uri"`
+}
+
+// Provider runs the authorization code flow with PKCE against an OIDC
+// provider and verifies the id tokens it issues.
+type Provider struct {
+	cfg       Config
+	endpoints discovery
+
+	mu   sync.Mutex
+	keys map[string]*rsa.PublicKey
+}
+
+// New reads the discovery document of cfg.Issuer.
+func New(ctx context.Context, cfg Config) (*Provider, error) {
+	if cfg.Issuer == "" || cfg.ClientID == "" || cfg.RedirectURL == "" {
+		return nil, errors.New("oidc needs an issuer, a client id and a redirect url")
+	}
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{"profile", "email"}
+	}
+	if cfg.GroupsClaim == "" {
+		cfg.GroupsClaim = "groups"
+	}
+	if cfg.Client == nil {
+		cfg.Client = &http.Client{Timeout: 30 * time.Second}
+	}
+	p := &Provider{cfg: cfg, keys: map[string]*rsa.PublicKey{}}
+
+	issuer := strings.TrimRight(cfg.Issuer, "/")
+	if err := p.getJSON(ctx, issuer+"/.well-known/openid-configuration", &p.endpoints); err != nil {
+		return nil, fmt.Errorf("reading the oidc discovery document: %w", err)
+	}
+	if strings.TrimRight(p.endpoints.Issuer, "/") != issuer {
+		return nil, fmt.Errorf("oidc discovery issuer %q does not match %q", p.endpoints.Issuer, cfg.Issuer)
+	}
+	if p.endpoints.AuthorizationEndpoint == "" || p.endpoints.TokenEndpoint == "" || p.endpoints.JwksURI == "" {
+		return nil, errors.New("oidc discovery document misses an endpoint")
+	}
+	return p, nil
+}
+
+// Role maps the groups of the claims to a role.
+func (p *Provider) Role(claims *Claims) (string, error) {
+	if role := rbac.MapGroups(p.cfg.Roles, claims.Groups); role != "" {
+		return role, nil
+	}
+	if p.cfg.DefaultRole == "" {
+		return "", ErrNoRole
+	}
+	return p.cfg.DefaultRole, nil
+}
+
+// RandomString returns a url safe random value for states, nonces and
+// PKCE verifiers.
+func RandomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Challenge is the S256 PKCE challenge of verifier.
+func Challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// AuthCodeURL is the provider page the user logs in at. The state comes
+// back to the callback, the nonce in the id token.
+func (p *Provider) AuthCodeURL(state, nonce, verifier string) string {
+	v := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.cfg.ClientID},
+		"redirect_uri":          {p.cfg.RedirectURL},
+		"scope":                 {strings.Join(append([]string{"openid"}, p.cfg.Scopes...), " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {Challenge(verifier)},
+		"code_challenge_method": {"S256"},
+	}
+	sep := "?"
+	if strings.Contains(p.endpoints.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return p.endpoints.AuthorizationEndpoint + sep + v.Encode()
+}
+
+// Exchange trades the code of the callback for tokens and returns the
+// claims of the verified id token.
+func (p *Provider) Exchange(ctx context.Context, code, verifier, nonce string) (*Claims, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.cfg.RedirectURL},
+		"client_id":     {p.cfg.ClientID},
+		"code_verifier": {verifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.endpoints.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.cfg.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.cfg.ClientID), url.QueryEscape(p.cfg.ClientSecret))
+	}
+	resp, err := p.cfg.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("reading the token response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK || body.Error != "" {
+		return nil, fmt.Errorf("token endpoint answered %d: %s %s", resp.StatusCode, body.Error, body.ErrorDescription)
+	}
+	if body.IDToken == "" {
+		return nil, errors.New("token response has no id token")
+	}
+	return p.Verify(ctx, body.IDToken, nonce)
+}
+
+// Verify checks the signature, issuer, audience, lifetime and nonce of an
+// id token.
+func (p *Provider) Verify(ctx context.Context, idToken, nonce string) (*Claims, error) {
+	token, err := jwt.Parse(idToken, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
+			return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+		}
+		kid, _ := token.Header["kid"].(string)
+		return p.key(ctx, kid)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	claims, _ := token.Claims.(jwt.MapClaims)
+	if !claims.VerifyIssuer(p.endpoints.Issuer, true) {
+		return nil, fmt.Errorf("%w: issuer %v", ErrInvalidToken, claims["iss"])
+	}
+	if !claims.VerifyAudience(p.cfg.ClientID, true) {
+		return nil, fmt.Errorf("%w: audience %v", ErrInvalidToken, claims["aud"])
+	}
+	if _, ok := claims["exp"]; !ok {
+		return nil, fmt.Errorf("%w: no expiry", ErrInvalidToken)
+	}
+	if got, _ := claims["nonce"].(string); got != nonce {
+		return nil, fmt.Errorf("%w: nonce does not match", ErrInvalidToken)
+	}
+
+	c := &Claims{Groups: stringList(claims[p.cfg.GroupsClaim])}
+	c.Subject, _ = claims["sub"].(string)
+	c.Email, _ = claims["email"].(string)
+	c.EmailVerified, _ = claims["email_verified"].(bool)
+	c.Name, _ = claims["name"].(string)
+	c.PreferredUsername, _ = claims["preferred_username"].(string)
+	if c.Subject == "" {
+		return nil, fmt.Errorf("%w: no subject", ErrInvalidToken)
+	}
+	return c, nil
+}
+
+// stringList reads a claim holding a list of strings, or one string.
+func stringList(v interface{}) []string {
+	switch v := v.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		var list []string
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				list = append(list, s)
+			}
+		}
+		return list
+	}
+	return nil
+}
+
+// key returns the signing key kid, the key set is read again when the
+// provider rotated to an unknown key.
+func (p *Provider) key(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	p.mu.Lock()
+	key, ok := p.keys[kid]
+	p.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := p.getJSON(ctx, p.endpoints.JwksURI, &set); err != nil {
+		return nil, fmt.Errorf("reading the oidc key set: %w", err)
+	}
+	keys := map[string]*rsa.PublicKey{}
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			continue
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			continue
+		}
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	p.mu.Lock()
+	p.keys = keys
+	p.mu.Unlock()
+
+	key, ok = keys[kid]
+	if !ok && kid == "" && len(keys) == 1 {
+		// a token without kid is signed with the only key
+		for _, only := range keys {
+			key, ok = only, true
+		}
+	}
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	return key, nil
+}
+
+func (p *Provider) getJSON(ctx context.Context, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := p.cfg.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s answered %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,153 @@
+package oidc_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"discord-smtp-server/oidc"
+	"discord-smtp-server/oidc/oidctest"
+	"discord-smtp-server/rbac"
+)
+
+func newTestProvider(t *testing.T, mock *oidctest.Provider, cfg oidc.Config) *oidc.Provider {
+	t.Helper()
+	cfg.Issuer = mock.URL
+	cfg.ClientID = mock.ClientID
+	cfg.ClientSecret = mock.ClientSecret
+	cfg.RedirectURL = "http://dashboard.test/api/oidc/callback"
+	p, err := oidc.New(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("oidc.New() error = %v", err)
+	}
+	return p
+}
+
+func TestProvider_Exchange(t *testing.T) {
+	mock := oidctest.NewProvider("mailtracker", "secret")
+	defer mock.Close()
+	p := newTestProvider(t, mock, oidc.Config{})
+	mock.SetUser(map[string]interface{}{
+		"sub":                "u-42",
+		"email":              "ayse@example.com",
+		"preferred_username": "ayse",
+		"groups":             []string{"dev", "mailtracker-admins"},
+	})
+
+	tests := []struct {
+		name     string
+		verifier string
+		nonce    string
+		wantErr  bool
+	}{
+		{"Login", "", "nonce", false},
+		{"Other verifier", "other-verifier", "nonce", true},
+		{"Other nonce", "", "other-nonce", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			verifier, _ := oidc.RandomString()
+			callback, err := mock.Authorize(p.AuthCodeURL("state", "nonce", verifier))
+			if err != nil {
+				t.Fatalf("Authorize() error = %v", err)
+			}
+			if callback.Query().Get("state") != "state" {
+				t.Errorf("callback state = %q", callback.Query().Get("state"))
+			}
+			if tt.verifier != "" {
+				verifier = tt.verifier
+			}
+			claims, err := p.Exchange(context.Background(), callback.Query().Get("code"), verifier, tt.nonce)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Exchange() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			want := &oidc.Claims{Subject: "u-42", Email: "ayse@example.com", PreferredUsername: "ayse", Groups: []string{"dev", "mailtracker-admins"}}
+			if !reflect.DeepEqual(claims, want) {
+				t.Errorf("Exchange() = %+v, want %+v", claims, want)
+			}
+		})
+	}
+}
+
+func TestProvider_Verify(t *testing.T) {
+	mock := oidctest.NewProvider("mailtracker", "")
+	defer mock.Close()
+	p := newTestProvider(t, mock, oidc.Config{})
+	valid := func() map[string]interface{} {
+		return map[string]interface{}{
+			"iss":   mock.URL,
+			"aud":   "mailtracker",
+			"sub":   "u-1",
+			"exp":   time.Now().Add(time.Minute).Unix(),
+			"nonce": "n",
+		}
+	}
+	with := func(k string, v interface{}) map[string]interface{} {
+		claims := valid()
+		if v == nil {
+			delete(claims, k)
+		} else {
+			claims[k] = v
+		}
+		return claims
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr bool
+	}{
+		{"Valid", mock.IDToken(valid()), false},
+		{"Audience list", mock.IDToken(with("aud", []string{"other", "mailtracker"})), false},
+		{"Other issuer", mock.IDToken(with("iss", "https://evil.example.com")), true},
+		{"Other audience", mock.IDToken(with("aud", "other")), true},
+		{"Expired", mock.IDToken(with("exp", time.Now().Add(-time.Minute).Unix())), true},
+		{"No expiry", mock.IDToken(with("exp", nil)), true},
+		{"No subject", mock.IDToken(with("sub", nil)), true},
+		{"Not signed", "eyJhbGciOiJub25lIn0.eyJzdWIiOiJ1LTEifQ.", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := p.Verify(context.Background(), tt.token, "n")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Verify() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, oidc.ErrInvalidToken) {
+				t.Errorf("Verify() error = %v, want ErrInvalidToken", err)
+			}
+		})
+	}
+}
+
+func TestProvider_Role(t *testing.T) {
+	mock := oidctest.NewProvider("mailtracker", "")
+	defer mock.Close()
+	roles := []rbac.RoleMapping{{Group: "mailtracker-admins", Role: "admin"}, {Group: "support", Role: "support"}}
+
+	tests := []struct {
+		name        string
+		defaultRole string
+		groups      []string
+		want        string
+		wantErr     error
+	}{
+		{"First mapping wins", "", []string{"support", "mailtracker-admins"}, "admin", nil},
+		{"Mapped group", "", []string{"dev", "support"}, "support", nil},
+		{"Default role", "watcher", []string{"dev"}, "watcher", nil},
+		{"No role", "", []string{"dev"}, "", oidc.ErrNoRole},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestProvider(t, mock, oidc.Config{Roles: roles, DefaultRole: tt.defaultRole})
+			got, err := p.Role(&oidc.Claims{Groups: tt.groups})
+			if got != tt.want || err != tt.wantErr {
+				t.Errorf("Role() = %q, %v, want %q, %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,168 @@
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// hash algorithms for new passwords
+const (
+	AlgorithmBcrypt   = "bcrypt"
+	AlgorithmArgon2id = "argon2id"
+)
+
+var (
+	ErrMismatch = errors.New("password does not match")
+	// ErrInvalidHash is returned for stored hashes that no password can
+	// match, like the ones of an older broken update.
+	ErrInvalidHash = errors.New("stored password hash is invalid")
+)
+
+// Config selects how new passwords are hashed, zero fields take the
+// defaults.
+type Config struct {
+	// Algorithm is bcrypt or argon2id, bcrypt when empty.
+	Algorithm  string
+	BcryptCost int
+	// Argon2Time is the number of passes and Argon2Memory the memory in
+	// KiB argon2id uses.
+	Argon2Time    uint32
+	Argon2Memory  uint32
+	Argon2Threads uint8
+}
+
+// Hasher hashes new passwords with the configured algorithm and verifies
+// the hashes of both algorithms.
+type Hasher struct {
+	cfg Config
+}
+
+func New(cfg Config) (*Hasher, error) {
+	switch cfg.Algorithm {
+	case "":
+		cfg.Algorithm = AlgorithmBcrypt
+	case AlgorithmBcrypt, AlgorithmArgon2id:
+	default:
+		return nil, fmt.Errorf("unknown password hash %q", cfg.Algorithm)
+	}
+	if cfg.BcryptCost == 0 {
+		cfg.BcryptCost = bcrypt.DefaultCost
+	}
+	if cfg.BcryptCost < bcrypt.MinCost || cfg.BcryptCost > bcrypt.MaxCost {
+		return nil, fmt.Errorf("bcrypt cost must be from %d to %d", bcrypt.MinCost, bcrypt.MaxCost)
+	}
+	if cfg.Argon2Time == 0 {
+		cfg.Argon2Time = 1
+	}
+	if cfg.Argon2Memory == 0 {
+		cfg.Argon2Memory = 64 * 1024
+	}
+	if cfg.Argon2Threads == 0 {
+		cfg.Argon2Threads = 4
+	}
+	return &Hasher{cfg: cfg}, nil
+}
+
+func (h *Hasher) Hash(password string) (string, error) {
+	if h.cfg.Algorithm == AlgorithmArgon2id {
+		salt := make([]byte, 16)
+		if _, err := rand.Read(salt); err != nil {
+			return "", err
+		}
+		p := argon2Hash{
+			time:    h.cfg.Argon2Time,
+			memory:  h.cfg.Argon2Memory,
+			threads: h.cfg.Argon2Threads,
+			salt:    salt,
+		}
+		p.key = argon2.IDKey([]byte(password), p.salt, p.time, p.memory, p.threads, 32)
+		return p.String(), nil
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), h.cfg.BcryptCost)
+	return string(hash), err
+}
+
+// Verify checks the password against a stored hash. rehash is set when
+// the password matches but the hash was made with another algorithm or
+// cost than configured, the caller should then store a new Hash.
+func (h *Hasher) Verify(hash, password string) (rehash bool, err error) {
+	if strings.HasPrefix(hash, "$argon2id$") {
+		p, err := parseArgon2(hash)
+		if err != nil {
+			return false, err
+		}
+		key := argon2.IDKey([]byte(password), p.salt, p.time, p.memory, p.threads, uint32(len(p.key)))
+		if subtle.ConstantTimeCompare(key, p.key) != 1 {
+			return false, ErrMismatch
+		}
+		return h.cfg.Algorithm != AlgorithmArgon2id || p.time != h.cfg.Argon2Time ||
+			p.memory != h.cfg.Argon2Memory || p.threads != h.cfg.Argon2Threads, nil
+	}
+
+	cost, err := bcrypt.Cost([]byte(hash))
+	if err != nil {
+		return false, ErrInvalidHash
+	}
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+		return false, ErrMismatch
+	}
+	return h.cfg.Algorithm != AlgorithmBcrypt || cost != h.cfg.BcryptCost, nil
+}
+
+// Valid tells whether a stored hash is one Verify can check.
+func Valid(hash string) bool {
+	if strings.HasPrefix(hash, "$argon2id$") {
+		_, err := parseArgon2(hash)
+		return err == nil
+	}
+	_, err := bcrypt.Cost([]byte(hash))
+	return err == nil
+}
+
+// argon2Hash is an argon2id hash in the PHC string format,
+// $argon2id$v=19$m=65536,t=1,p=4$salt$key.
+type argon2Hash struct {
+	time    uint32
+	memory  uint32
+	threads uint8
+	salt    []byte
+	key     []byte
+}
+
+func (p argon2Hash) String() string {
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, p.memory, p.time, p.threads,
+		base64.RawStdEncoding.EncodeToString(p.salt), base64.RawStdEncoding.EncodeToString(p.key))
+}
+
+func parseArgon2(hash string) (argon2Hash, error) {
+	var p argon2Hash
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != AlgorithmArgon2id {
+		return p, ErrInvalidHash
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return p, ErrInvalidHash
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.memory, &p.time, &p.threads); err != nil {
+		return p, ErrInvalidHash
+	}
+	var err error
+	if p.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return p, ErrInvalidHash
+	}
+	if p.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil || len(p.key) == 0 {
+		return p, ErrInvalidHash
+	}
+	if p.time == 0 || p.memory == 0 || p.threads == 0 {
+		return p, ErrInvalidHash
+	}
+	return p, nil
+}
@@ -0,0 +1,113 @@
+package password
+
+import (
+	"errors"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// small argon2 parameters keep the tests fast
+func newTestHasher(t *testing.T, cfg Config) *Hasher {
+	t.Helper()
+	if cfg.Argon2Memory == 0 {
+		cfg.Argon2Memory = 64
+	}
+	if cfg.BcryptCost == 0 {
+		cfg.BcryptCost = bcrypt.MinCost
+	}
+	h, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	return h
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     Config
+		wantErr bool
+	}{
+		{"Defaults", Config{}, false},
+		{"Argon2id", Config{Algorithm: AlgorithmArgon2id}, false},
+		{"Unknown algorithm", Config{Algorithm: "md5"}, true},
+		{"Bcrypt cost too high", Config{BcryptCost: 40}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := New(tt.cfg); (err != nil) != tt.wantErr {
+				t.Errorf("New() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestHasher_Verify(t *testing.T) {
+	bcryptHasher := newTestHasher(t, Config{Algorithm: AlgorithmBcrypt})
+	argonHasher := newTestHasher(t, Config{Algorithm: AlgorithmArgon2id})
+	bcryptHash, _ := bcryptHasher.Hash("Secret1!")
+	argonHash, _ := argonHasher.Hash("Secret1!")
+	strongerHash, _ := newTestHasher(t, Config{Algorithm: AlgorithmBcrypt, BcryptCost: bcrypt.MinCost + 1}).Hash("Secret1!")
+
+	tests := []struct {
+		name       string
+		hasher     *Hasher
+		hash       string
+		password   string
+		wantRehash bool
+		wantErr    error
+	}{
+		{"Bcrypt", bcryptHasher, bcryptHash, "Secret1!", false, nil},
+		{"Argon2id", argonHasher, argonHash, "Secret1!", false, nil},
+		{"Wrong password", bcryptHasher, bcryptHash, "secret1!", false, ErrMismatch},
+		{"Wrong argon2id password", argonHasher, argonHash, "secret1!", false, ErrMismatch},
+		{"Bcrypt hash when argon2id is configured", argonHasher, bcryptHash, "Secret1!", true, nil},
+		{"Argon2id hash when bcrypt is configured", bcryptHasher, argonHash, "Secret1!", true, nil},
+		{"Other bcrypt cost", bcryptHasher, strongerHash, "Secret1!", true, nil},
+		{"Byte count of the old update", bcryptHasher, "8", "Secret1!", false, ErrInvalidHash},
+		{"Broken argon2id", argonHasher, "$argon2id$v=19$m=64,t=1,p=4$", "Secret1!", false, ErrInvalidHash},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rehash, err := tt.hasher.Verify(tt.hash, tt.password)
+			if rehash != tt.wantRehash || !errors.Is(err, tt.wantErr) {
+				t.Errorf("Verify() = %v, %v, want %v, %v", rehash, err, tt.wantRehash, tt.wantErr)
+			}
+			if Valid(tt.hash) != (tt.wantErr != ErrInvalidHash) {
+				t.Errorf("Valid() = %v", Valid(tt.hash))
+			}
+		})
+	}
+}
+
+func TestPolicy_Check(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   Policy
+		password string
+		wantErr  bool
+	}{
+		{"Strong", DefaultPolicy, "Secret1!", false},
+		{"Turkish letters", DefaultPolicy, "Şifre12?", false},
+		{"Too short", DefaultPolicy, "Sec1!", true},
+		{"Seven characters", DefaultPolicy, "Secr1t!", true},
+		{"No upper case", DefaultPolicy, "secret1!", true},
+		{"No digit", DefaultPolicy, "Secrets!", true},
+		{"No symbol", DefaultPolicy, "Secret12", true},
+		{"Underscore is no symbol", DefaultPolicy, "Secret1_", true},
+		{"Length only", Policy{MinLength: 4}, "abcd", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.policy.Check(tt.password)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Check() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+	want := "Parola en az 8 karakter uzunluğunda olmalıdır, en az bir büyük harf, bir küçük harf, bir sayı ve bir özel karakter içermelidir"
+	if err := DefaultPolicy.Check(""); err == nil || err.Error() != want {
+		t.Errorf("Check() message = %v, want %q", err, want)
+	}
+}
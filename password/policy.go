@@ -0,0 +1,73 @@
+package password
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Policy is the strength a new password needs.
+type Policy struct {
+	MinLength int
+	Lower     bool
+	Upper     bool
+	Digit     bool
+	Symbol    bool
+}
+
+// DefaultPolicy asks for 8 characters with a lower and an upper case
+// letter, a digit and a symbol.
+var DefaultPolicy = Policy{MinLength: 8, Lower: true, Upper: true, Digit: true, Symbol: true}
+
+// WeakError is returned for a password the policy refuses, its message
+// tells the user what the policy asks for.
+type WeakError struct {
+	Policy Policy
+}
+
+func (e *WeakError) Error() string {
+	var needs []string
+	if e.Policy.Upper {
+		needs = append(needs, "bir büyük harf")
+	}
+	if e.Policy.Lower {
+		needs = append(needs, "bir küçük harf")
+	}
+	if e.Policy.Digit {
+		needs = append(needs, "bir sayı")
+	}
+	if e.Policy.Symbol {
+		needs = append(needs, "bir özel karakter")
+	}
+	message := "Parola en az " + strconv.Itoa(e.Policy.MinLength) + " karakter uzunluğunda olmalıdır"
+	switch len(needs) {
+	case 0:
+		return message
+	case 1:
+		return message + ", en az " + needs[0] + " içermelidir"
+	}
+	return message + ", en az " + strings.Join(needs[:len(needs)-1], ", ") + " ve " + needs[len(needs)-1] + " içermelidir"
+}
+
+// Check returns a *WeakError when the password does not meet the policy.
+func (p Policy) Check(password string) error {
+	var lower, upper, digit, symbol bool
+	length := 0
+	for _, r := range password {
+		length++
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		case !unicode.IsLetter(r) && r != '_':
+			symbol = true
+		}
+	}
+	if length < p.MinLength || (p.Lower && !lower) || (p.Upper && !upper) || (p.Digit && !digit) || (p.Symbol && !symbol) {
+		return &WeakError{Policy: p}
+	}
+	return nil
+}
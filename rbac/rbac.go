@@ -0,0 +1,131 @@
+package rbac
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"discord-smtp-server/store"
+)
+
+// permissions checked by the api and smtp servers
+const (
+	// MailsRead reads the mails of the inboxes of your projects.
+	MailsRead = "mails:read"
+	// MailsDelete marks mails read and deletes them, in the projects you
+	// maintain.
+	MailsDelete = "mails:delete"
+	// MailsAll widens the mail permissions to every inbox and project.
+	MailsAll       = "mails:all"
+	UsersManage    = "users:manage"
+	ProjectsManage = "projects:manage"
+	TicketsManage  = "tickets:manage"
+	// SettingsManage covers the smtp credentials and the quarantine.
+	SettingsManage = "settings:manage"
+)
+
+var permissions = []string{MailsRead, MailsDelete, MailsAll, UsersManage, ProjectsManage, TicketsManage, SettingsManage}
+
+// Permissions lists every permission.
+func Permissions() []string {
+	return clone(permissions)
+}
+
+// built-in roles
+const (
+	RoleAdmin   = "admin"
+	RoleWatcher = "watcher"
+)
+
+// builtin maps the built-in roles to their permissions, they can not be
+// changed.
+var builtin = map[string][]string{
+	RoleAdmin:   permissions,
+	RoleWatcher: {MailsRead, MailsDelete},
+}
+
+// Builtin returns the permissions of a built-in role, ok is false for
+// other roles.
+func Builtin(role string) ([]string, bool) {
+	found, ok := builtin[role]
+	return clone(found), ok
+}
+
+// Valid tells whether permission is a known one.
+func Valid(permission string) bool {
+	return Has(permissions, permission)
+}
+
+// Has tells whether permissions contains permission.
+func Has(permissions []string, permission string) bool {
+	for _, p := range permissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// Resolve returns the permissions of the role, a built-in one or a custom
+// role of roles. Unknown roles have none.
+func Resolve(ctx context.Context, roles store.RoleStore, role string) ([]string, error) {
+	if permissions, ok := Builtin(role); ok {
+		return permissions, nil
+	}
+	if roles == nil || role == "" {
+		return nil, nil
+	}
+	custom, err := roles.FindRoleByName(ctx, role)
+	if err == store.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return custom.Permissions, nil
+}
+
+func clone(permissions []string) []string {
+	if permissions == nil {
+		return nil
+	}
+	return append([]string{}, permissions...)
+}
+
+// RoleMapping gives the members of Group the Role, for users of a single
+// sign-on or directory.
+type RoleMapping struct {
+	Group string
+	Role  string
+}
+
+// ParseRoleMap reads mappings written as group=role pairs separated by
+// commas, like "mailtracker-admins=admin,support=support".
+func ParseRoleMap(s string) ([]RoleMapping, error) {
+	var roles []RoleMapping
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" || strings.TrimSpace(parts[1]) == "" {
+			return nil, fmt.Errorf("invalid role mapping %q, want group=role", pair)
+		}
+		roles = append(roles, RoleMapping{Group: strings.TrimSpace(parts[0]), Role: strings.TrimSpace(parts[1])})
+	}
+	return roles, nil
+}
+
+// MapGroups returns the role of the first mapping whose group is one of
+// groups, or "" when there is none.
+func MapGroups(mappings []RoleMapping, groups []string) string {
+	for _, mapping := range mappings {
+		for _, group := range groups {
+			if group == mapping.Group {
+				return mapping.Role
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,69 @@
+package rbac
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"discord-smtp-server/store"
+)
+
+func TestResolve(t *testing.T) {
+	ctx := context.Background()
+	roles := store.NewMemory()
+	roles.InsertRole(ctx, &store.Role{Name: "support", Permissions: []string{MailsRead, TicketsManage}})
+
+	tests := []struct {
+		name  string
+		roles store.RoleStore
+		role  string
+		want  []string
+	}{
+		{"Admin", roles, RoleAdmin, Permissions()},
+		{"Watcher", roles, RoleWatcher, []string{MailsRead, MailsDelete}},
+		{"Custom", roles, "support", []string{MailsRead, TicketsManage}},
+		{"Unknown", roles, "guest", nil},
+		{"Empty", roles, "", nil},
+		{"Without a role store", nil, "support", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Resolve(ctx, tt.roles, tt.role)
+			if err != nil || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Resolve() = %v, %v, want %v", got, err, tt.want)
+			}
+		})
+	}
+
+	// callers get copies, changing one leaves the built-in roles alone
+	got, _ := Resolve(ctx, roles, RoleWatcher)
+	got[0] = MailsAll
+	if watcher, _ := Builtin(RoleWatcher); watcher[0] != MailsRead {
+		t.Errorf("built-in watcher = %v after changing a resolved copy", watcher)
+	}
+	Permissions()[0] = UsersManage
+	if !reflect.DeepEqual(Permissions()[:1], []string{MailsRead}) {
+		t.Errorf("Permissions() = %v after changing a copy", Permissions())
+	}
+}
+
+func TestParseRoleMap(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       string
+		want    []RoleMapping
+		wantErr bool
+	}{
+		{"Pairs", " mailtracker-admins=admin, support=support ,", []RoleMapping{{"mailtracker-admins", "admin"}, {"support", "support"}}, false},
+		{"Empty", "", nil, false},
+		{"Pair without a role", "admins", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseRoleMap(tt.s)
+			if (err != nil) != tt.wantErr || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseRoleMap() = %v, %v, want %v", got, err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,156 @@
+package smtp
+
+import (
+	"bytes"
+	"discord-smtp-server/store"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"strings"
+
+	"golang.org/x/net/html/charset"
+)
+
+// kinds of decoded message parts
+const (
+	PartKindText       = "text"
+	PartKindHtml       = "html"
+	PartKindAttachment = "attachment"
+	PartKindInline     = "inline"
+)
+
+// maxPartDepth limits how deep nested multiparts are walked.
+const maxPartDepth = 10
+
+type header interface {
+	Get(key string) string
+}
+
+var wordDecoder = mime.WordDecoder{CharsetReader: charset.NewReaderLabel}
+
+// parseParts walks the MIME tree of a message and returns its leaf parts,
+// decoded by Content-Transfer-Encoding and, for text parts, by charset.
+func parseParts(h header, r io.Reader) ([]store.Part, error) {
+	return walkParts(h, r, 0)
+}
+
+func walkParts(h header, r io.Reader, depth int) ([]store.Part, error) {
+	if depth > maxPartDepth {
+		return nil, fmt.Errorf("mime parts nested deeper than %d levels", maxPartDepth)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(h.Get("Content-Type"))
+	if err != nil {
+		mediaType = "text/plain"
+		params = map[string]string{}
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		mr := multipart.NewReader(r, params["boundary"])
+		var parts []store.Part
+		for {
+			p, err := mr.NextRawPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return parts, err
+			}
+			children, err := walkParts(p.Header, p, depth+1)
+			if err != nil {
+				return parts, err
+			}
+			parts = append(parts, children...)
+		}
+		return parts, nil
+	}
+
+	content, err := io.ReadAll(decodeTransfer(h.Get("Content-Transfer-Encoding"), r))
+	if err != nil {
+		return nil, err
+	}
+
+	part := store.Part{
+		ContentType: mediaType,
+		Charset:     params["charset"],
+		ContentId:   strings.Trim(h.Get("Content-Id"), "<> "),
+		Size:        len(content),
+	}
+
+	disposition, dispositionParams, _ := mime.ParseMediaType(h.Get("Content-Disposition"))
+	part.Filename = dispositionParams["filename"]
+	if part.Filename == "" {
+		part.Filename = params["name"]
+	}
+	if filename, err := wordDecoder.DecodeHeader(part.Filename); err == nil {
+		part.Filename = filename
+	}
+
+	switch {
+	case disposition == "attachment":
+		part.Kind = PartKindAttachment
+	case mediaType == "text/plain" && part.Filename == "":
+		part.Kind = PartKindText
+	case mediaType == "text/html" && part.Filename == "":
+		part.Kind = PartKindHtml
+	case disposition == "inline" || part.ContentId != "":
+		part.Kind = PartKindInline
+	default:
+		part.Kind = PartKindAttachment
+	}
+
+	if part.Kind == PartKindText || part.Kind == PartKindHtml {
+		text, err := decodeCharset(part.Charset, content)
+		if err != nil {
+			return nil, err
+		}
+		part.Content = text
+	} else {
+		part.Raw = content
+	}
+
+	return []store.Part{part}, nil
+}
+
+func decodeTransfer(encoding string, r io.Reader) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, r)
+	case "quoted-printable":
+		return quotedprintable.NewReader(r)
+	}
+	return r
+}
+
+func decodeCharset(label string, content []byte) (string, error) {
+	switch strings.ToLower(label) {
+	case "", "utf-8", "utf8", "us-ascii":
+		return string(content), nil
+	}
+	r, err := charset.NewReaderLabel(label, bytes.NewReader(content))
+	if err != nil {
+		// unknown charset, keep the bytes as they are
+		return string(content), nil
+	}
+	decoded, err := io.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+	return string(decoded), nil
+}
+
+// textBodies returns the first plain text and html part of a message.
+func textBodies(parts []store.Part) (text, html string) {
+	for _, part := range parts {
+		if part.Kind == PartKindText && text == "" {
+			text = part.Content
+		}
+		if part.Kind == PartKindHtml && html == "" {
+			html = part.Content
+		}
+	}
+	return text, html
+}
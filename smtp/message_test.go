@@ -0,0 +1,124 @@
+package smtp
+
+import (
+	"net/mail"
+	"reflect"
+	"strings"
+	"testing"
+
+	"discord-smtp-server/store"
+)
+
+func TestParseParts(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		want    []store.Part
+		wantErr bool
+	}{
+		{
+			"Plain quoted-printable message",
+			"Content-Type: text/plain; charset=utf-8\r\n" +
+				"Content-Transfer-Encoding: quoted-printable\r\n" +
+				"\r\n" +
+				"caf=C3=A9\r\n",
+			[]store.Part{
+				{Kind: PartKindText, ContentType: "text/plain", Charset: "utf-8", Size: 7, Content: "café\r\n"},
+			},
+			false,
+		},
+		{
+			"Message without content type",
+			"Subject: hello\r\n" +
+				"\r\n" +
+				"hello\r\n",
+			[]store.Part{
+				{Kind: PartKindText, ContentType: "text/plain", Size: 7, Content: "hello\r\n"},
+			},
+			false,
+		},
+		{
+			"Alternative inside mixed with attachment and inline image",
+			"Content-Type: multipart/mixed; boundary=outer\r\n" +
+				"\r\n" +
+				"--outer\r\n" +
+				"Content-Type: multipart/alternative; boundary=inner\r\n" +
+				"\r\n" +
+				"--inner\r\n" +
+				"Content-Type: text/plain; charset=iso-8859-9\r\n" +
+				"Content-Transfer-Encoding: quoted-printable\r\n" +
+				"\r\n" +
+				"=DE=FD\r\n" +
+				"--inner\r\n" +
+				"Content-Type: text/html; charset=utf-8\r\n" +
+				"Content-Transfer-Encoding: base64\r\n" +
+				"\r\n" +
+				"PGI+aGk8L2I+\r\n" +
+				"--inner--\r\n" +
+				"--outer\r\n" +
+				"Content-Type: image/png\r\n" +
+				"Content-Id: <logo@mail>\r\n" +
+				"Content-Transfer-Encoding: base64\r\n" +
+				"\r\n" +
+				"iVBO\r\n" +
+				"--outer\r\n" +
+				"Content-Type: application/pdf; name=\"=?utf-8?q?fatura=C3=A7.pdf?=\"\r\n" +
+				"Content-Disposition: attachment\r\n" +
+				"Content-Transfer-Encoding: base64\r\n" +
+				"\r\n" +
+				"JVBERg==\r\n" +
+				"--outer--\r\n",
+			[]store.Part{
+				{Kind: PartKindText, ContentType: "text/plain", Charset: "iso-8859-9", Size: 2, Content: "Şı"},
+				{Kind: PartKindHtml, ContentType: "text/html", Charset: "utf-8", Size: 9, Content: "<b>hi</b>"},
+				{Kind: PartKindInline, ContentType: "image/png", ContentId: "logo@mail", Size: 3, Raw: []byte{0x89, 'P', 'N'}},
+				{Kind: PartKindAttachment, ContentType: "application/pdf", Filename: "faturaç.pdf", Size: 4, Raw: []byte("%PDF")},
+			},
+			false,
+		},
+		{
+			"Invalid base64 content",
+			"Content-Type: application/pdf\r\n" +
+				"Content-Transfer-Encoding: base64\r\n" +
+				"\r\n" +
+				"!!!!\r\n",
+			nil,
+			true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, err := mail.ReadMessage(strings.NewReader(tt.message))
+			if err != nil {
+				t.Fatalf("mail.ReadMessage() error = %v", err)
+			}
+			got, err := parseParts(msg.Header, msg.Body)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseParts() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseParts() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTextBodies(t *testing.T) {
+	parts := []store.Part{
+		{Kind: PartKindAttachment, Raw: []byte("x")},
+		{Kind: PartKindHtml, Content: "<p>first</p>"},
+		{Kind: PartKindText, Content: "first"},
+		{Kind: PartKindText, Content: "second"},
+	}
+	text, html := textBodies(parts)
+	if text != "first" || html != "<p>first</p>" {
+		t.Errorf("textBodies() = %q, %q", text, html)
+	}
+	if text, html := textBodies(nil); text != "" || html != "" {
+		t.Errorf("textBodies(nil) = %q, %q", text, html)
+	}
+}
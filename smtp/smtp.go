@@ -3,70 +3,240 @@ package smtp
 import (
 	"bytes"
 	"context"
-	"encoding/json"
+	"discord-smtp-server/attachment"
+	"discord-smtp-server/authn"
+	"discord-smtp-server/discord"
+	"discord-smtp-server/events"
+	"discord-smtp-server/notify"
+	"discord-smtp-server/password"
+	"discord-smtp-server/rbac"
+	"discord-smtp-server/store"
 	"errors"
-	"fmt"
 	"github.com/emersion/go-smtp"
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"io"
 	"io/ioutil"
 	"log"
-	"mime"
-	"mime/quotedprintable"
-	"net/http"
 	"net/mail"
-	"os"
 	"regexp"
+	"strings"
+	"sync"
 	"time"
 )
 
+// WebhookResolver finds the webhook a recipient address is routed to.
+type WebhookResolver interface {
+	Resolve(address string) (string, error)
+}
+
+// Config holds what the backend needs, only Mails and Attachments are
+// required.
+type Config struct {
+	Mails store.MailStore
+	// Credentials and Authenticator are checked on login, before the
+	// Username/Password pair.
+	Users       store.UserStore
+	Credentials store.CredentialStore
+	// Authenticator checks the passwords of users, the hashes of Users
+	// when nil.
+	Authenticator authn.Authenticator
+	// Passwords checks the hashes of users and credentials, bcrypt with the
+	// default cost when nil.
+	Passwords *password.Hasher
+	// Projects and the permissions of the Roles decide which users are
+	// told about a mail.
+	Projects store.ProjectStore
+	Roles    store.RoleStore
+	// Quarantine keeps the messages that can not be parsed.
+	Quarantine  store.QuarantineStore
+	Attachments attachment.Storage
+	Events      *events.Bus
+	// Resolver routes recipients to discord webhooks, without it every
+	// mail goes to Webhook.
+	Resolver      WebhookResolver
+	RejectUnknown bool
+	// Webhook is the fallback discord webhook.
+	Webhook string
+	// Mailboxes are the webhooks configured per recipient address.
+	Mailboxes notify.Mailboxes
+	Notify    notify.Options
+	Username  string
+	Password  string
+}
+
 type Backend struct {
-	client   *mongo.Client
-	webhook  string
-	username string
-	password string
+	mails         store.MailStore
+	users         store.UserStore
+	credentials   store.CredentialStore
+	authenticator authn.Authenticator
+	passwords     *password.Hasher
+	projects      store.ProjectStore
+	roles         store.RoleStore
+	quarantine    store.QuarantineStore
+	attachments   attachment.Storage
+	events        *events.Bus
+	resolver      WebhookResolver
+	rejectUnknown bool
+	webhook       string
+	mailboxes     notify.Mailboxes
+	notify        notify.Options
+	username      string
+	password      string
+
+	// watchers caches the users with webhooks, loaded again after
+	// watcherCacheTTL
+	watchersMu     sync.Mutex
+	watchers       []watcher
+	watchersExpire time.Time
+	// notifications is the queue of the notifier workers, started with
+	// the first mail
+	notifyOnce    sync.Once
+	notifications chan notification
 }
 
-func NewBackend(db, discordToken, username, password string) (*Backend, error) {
+const (
+	// watcherCacheTTL is how long a role or webhook change can take to
+	// reach the notifications, like the user cache of the api.
+	watcherCacheTTL = 30 * time.Second
+	// notifyWorkers send the notifications, a slow webhook holds one of
+	// them and not the smtp session.
+	notifyWorkers = 4
+	// notifyQueueSize is how many notifications wait for a worker before
+	// new ones are dropped.
+	notifyQueueSize = 256
+)
 
-	clientOptions := options.Client().ApplyURI(db)
-	client, err := mongo.Connect(context.TODO(), clientOptions)
-	if err != nil {
-		log.Fatal(err)
+// watcher is a user with webhooks and the permissions of their role.
+type watcher struct {
+	username    string
+	permissions []string
+	webhooks    []store.Webhook
+}
+
+type notification struct {
+	webhook store.Webhook
+	mail    store.Mail
+}
+
+func NewBackend(cfg Config) (*Backend, error) {
+	if cfg.Mails == nil || cfg.Attachments == nil {
+		return nil, errors.New("smtp backend needs a mail store and an attachment storage")
 	}
-	// Check the connection
-	err = client.Ping(context.TODO(), nil)
-	if err != nil {
-		log.Fatal(err)
+	if cfg.Passwords == nil {
+		cfg.Passwords, _ = password.New(password.Config{})
+	}
+	if cfg.Authenticator == nil && cfg.Users != nil {
+		cfg.Authenticator = &authn.Local{Users: cfg.Users, Passwords: cfg.Passwords}
 	}
-	fmt.Println("Connected to MongoDB!")
-
 	return &Backend{
-		client:   client,
-		webhook:  discordToken,
-		username: username,
-		password: password,
+		mails:         cfg.Mails,
+		users:         cfg.Users,
+		credentials:   cfg.Credentials,
+		authenticator: cfg.Authenticator,
+		passwords:     cfg.Passwords,
+		projects:      cfg.Projects,
+		roles:         cfg.Roles,
+		quarantine:    cfg.Quarantine,
+		attachments:   cfg.Attachments,
+		events:        cfg.Events,
+		resolver:      cfg.Resolver,
+		rejectUnknown: cfg.RejectUnknown,
+		webhook:       cfg.Webhook,
+		mailboxes:     cfg.Mailboxes,
+		notify:        cfg.Notify,
+		username:      cfg.Username,
+		password:      cfg.Password,
 	}, nil
 }
 
+var (
+	errUnknownRecipient = &smtp.SMTPError{
+		Code:         550,
+		EnhancedCode: smtp.EnhancedCode{5, 1, 1},
+		Message:      "No such recipient",
+	}
+	// errRecipientUnavailable asks the client to retry while the
+	// recipient can not be looked up, like during a discord outage
+	errRecipientUnavailable = &smtp.SMTPError{
+		Code:         451,
+		EnhancedCode: smtp.EnhancedCode{4, 4, 3},
+		Message:      "Recipient lookup failed, try again later",
+	}
+)
+
+var (
+	errInvalidCredentials = &smtp.SMTPError{
+		Code:         535,
+		EnhancedCode: smtp.EnhancedCode{5, 7, 8},
+		Message:      "Invalid username or password",
+	}
+	errAuthUnavailable = &smtp.SMTPError{
+		Code:         454,
+		EnhancedCode: smtp.EnhancedCode{4, 7, 0},
+		Message:      "Temporary authentication failure",
+	}
+)
+
+// Login accepts an smtp credential, a user the authenticator accepts or
+// the configured username and password, in that order. Mails of the
+// session are tagged with the inbox of the credential or the user inbox,
+// see store.UserInbox.
 func (b *Backend) Login(state *smtp.ConnectionState, username, password string) (smtp.Session, error) {
-	if username != b.username || password != b.password {
-		return nil, errors.New("Invalid username or password")
+	inbox, err := b.authenticate(context.TODO(), username, password)
+	if err != nil {
+		return nil, err
 	}
 	return &Session{
 		backend: b,
+		inbox:   inbox,
 	}, nil
 }
 
+func (b *Backend) authenticate(ctx context.Context, username, password string) (string, error) {
+	if b.credentials != nil {
+		credential, err := b.credentials.FindCredentialByUsername(ctx, username)
+		if err == nil {
+			if _, err := b.passwords.Verify(credential.Password, password); err != nil {
+				return "", errInvalidCredentials
+			}
+			return credential.Inbox, nil
+		}
+		if err != store.ErrNotFound {
+			log.Println("Finding smtp credential failed:", err)
+			return "", errAuthUnavailable
+		}
+	}
+
+	if b.authenticator != nil {
+		user, err := b.authenticator.Authenticate(ctx, username, password)
+		switch err {
+		case nil:
+			return store.UserInbox(user.Username), nil
+		case authn.ErrInvalidCredentials:
+		case authn.ErrNoRole, authn.ErrUsernameTaken:
+			return "", errInvalidCredentials
+		default:
+			log.Println("Authenticating smtp user failed:", err)
+			return "", errAuthUnavailable
+		}
+	}
+
+	if b.username == "" || username != b.username || password != b.password {
+		return "", errInvalidCredentials
+	}
+	return "", nil
+}
+
 func (b *Backend) AnonymousLogin(state *smtp.ConnectionState) (smtp.Session, error) {
 	return nil, smtp.ErrAuthRequired
 }
 
 type Session struct {
-	backend *Backend
-	webhook string
-	from    string
+	backend  *Backend
+	inbox    string
+	webhooks []store.Webhook
+	from     string
+	rcpts    []string
 }
 
 func (s *Session) Mail(from string, opts smtp.MailOptions) error {
@@ -74,165 +244,307 @@ func (s *Session) Mail(from string, opts smtp.MailOptions) error {
 	return nil
 }
 
+// Rcpt routes the recipient to its discord webhook and the webhooks of its
+// mailbox. Unknown recipients go to the fallback webhook unless rejection
+// is enabled or they have nowhere else to go.
 func (s *Session) Rcpt(to string) error {
-	//address, err := email.Parse(to)
-	//if err != nil {
-	//	return err
-	//}
-	//
-	//guildID, err := s.backend.discordClient.GetGuildID(address.TLD)
-	//if err != nil {
-	//	return err
-	//}
-	//
-	//channelID, err := s.backend.discordClient.GetChannelID(*guildID, address.Domain)
-	//if err != nil {
-	//	return err
-	//}
-	//
-	//webhook, err := s.backend.discordClient.GetWebhook(address.User, *channelID)
-	//if err != nil {
-	//	return err
-	//}
-
-	s.webhook = s.backend.webhook
+	mailbox := s.backend.mailboxes.For(to)
+
+	webhook := s.backend.webhook
+	if s.backend.resolver != nil {
+		resolved, err := s.backend.resolver.Resolve(to)
+		if err == nil {
+			webhook = resolved
+		} else if s.backend.rejectUnknown || (webhook == "" && len(mailbox) == 0) {
+			if discord.IsNotFound(err) {
+				return errUnknownRecipient
+			}
+			log.Println("Resolving recipient failed:", err)
+			return errRecipientUnavailable
+		}
+	}
+	if webhook != "" {
+		s.addWebhook(store.Webhook{Kind: notify.KindDiscord, URL: webhook})
+	}
+	for _, w := range mailbox {
+		s.addWebhook(w)
+	}
+	s.rcpts = append(s.rcpts, to)
 
 	return nil
 }
 
-type mailDto struct {
-	Data        string `json:"data"`
-	Subject     string `json:"subject"`
-	To          string `json:"to"`
-	IsRead      int    `json:"isRead"`
-	From        string `json:"from"`
-	Rcpt        string `json:"rcpt"`
-	MimeVersion string `json:"mimeVersion"`
-	ContentType string `json:"contentType"`
-	Body        string `json:"body"`
-	Cc          string `json:"cc"`
-	Bcc         string `json:"bcc"`
-	CreatedAt   string `json:"createdat"`
+func (s *Session) addWebhook(webhook store.Webhook) {
+	for _, w := range s.webhooks {
+		if w.URL == webhook.URL {
+			return
+		}
+	}
+	s.webhooks = append(s.webhooks, webhook)
 }
 
+var (
+	errUnparseable = &smtp.SMTPError{
+		Code:         554,
+		EnhancedCode: smtp.EnhancedCode{5, 6, 0},
+		Message:      "Message could not be parsed",
+	}
+	errStorageUnavailable = &smtp.SMTPError{
+		Code:         451,
+		EnhancedCode: smtp.EnhancedCode{4, 3, 0},
+		Message:      "Temporary storage failure, try again later",
+	}
+)
+
+// Data stores the message and notifies its webhooks. Messages that can not
+// be parsed are quarantined and refused with a permanent error, storage
+// failures are refused with a temporary one so the client retries.
 func (s *Session) Data(r io.Reader) error {
 	b, err := ioutil.ReadAll(r)
 	if err != nil {
 		return err
 	}
 
-	reqBody, err := json.Marshal(
-		map[string]string{
-			"content": string(b),
-		},
-	)
+	msg, err := mail.ReadMessage(bytes.NewReader(b))
 	if err != nil {
-		return err
+		return s.quarantine(b, err)
 	}
 
-	reader := bytes.NewReader(b)
-	msg, err := mail.ReadMessage(reader)
+	parts, err := parseParts(msg.Header, msg.Body)
 	if err != nil {
-		log.Fatal(err)
+		return s.quarantine(b, err)
 	}
-
-	body, err := io.ReadAll(quotedprintable.NewReader(msg.Body))
-	if err != nil {
-		log.Fatal(err)
+	text, html := textBodies(parts)
+	body := html
+	if body == "" {
+		body = text
 	}
 
-	fmt.Println(msg.Header)
-
-	var dec mime.WordDecoder
+	to := decodeHeader(msg.Header, "To")
+	address := regexp.MustCompile(`(?m)<(.*)>`).FindStringSubmatch(to)
+	if len(address) == 0 {
+		address = append(address, to)
+	}
 
-	subject, err := dec.DecodeHeader(msg.Header.Get("Subject"))
+	var newMail store.Mail
+	newMail.Data = string(b)
+	newMail.Subject = decodeHeader(msg.Header, "Subject")
+	newMail.To = to
+	newMail.From = decodeHeader(msg.Header, "From")
+	newMail.Body = body
+	newMail.Text = text
+	newMail.Html = html
+	newMail.Attachments, err = s.saveAttachments(parts)
 	if err != nil {
-		fmt.Println("1")
-		return err
+		log.Println("Saving attachments failed:", err)
+		return errStorageUnavailable
+	}
+	newMail.Parts = parts
+	newMail.Rcpt = address[0]
+	newMail.Inbox = s.inbox
+	newMail.MimeVersion = decodeHeader(msg.Header, "Mime-Version")
+	newMail.ContentType = decodeHeader(msg.Header, "Content-Type")
+	newMail.Cc = decodeHeader(msg.Header, "Cc")
+	newMail.Bcc = decodeHeader(msg.Header, "Bcc")
+	if date, err := msg.Header.Date(); err == nil {
+		newMail.Date = date.UTC()
+	}
+	newMail.CreatedAt = time.Now().UTC()
+	newMail.IsRead = 0
+	if _, err := s.backend.mails.InsertMail(context.TODO(), &newMail); err != nil {
+		log.Println("Inserting mail failed:", err)
+		// the client sends the mail again, do not keep its attachments twice
+		for _, a := range newMail.Attachments {
+			s.backend.attachments.Delete(context.TODO(), a.Id)
+		}
+		return errStorageUnavailable
+	}
+	if s.backend.events != nil {
+		s.backend.events.Publish(newMail)
 	}
 
-	to, err := dec.DecodeHeader(msg.Header.Get("To"))
-	if err != nil {
-		fmt.Println("2")
-		return err
+	s.addWatcherWebhooks(newMail)
+
+	// the mail is stored, the notifications are sent after the client is
+	// answered and a failed one must not make it send the mail again
+	for _, webhook := range s.webhooks {
+		s.backend.enqueue(notification{webhook: webhook, mail: newMail})
 	}
 
-	address := regexp.MustCompile(`(?m)<(.*)>`).FindStringSubmatch(to)
-	if len(address) == 0 {
-		address = append(address, to)
+	return nil
+}
+
+// enqueue hands the notification to the workers, or drops it when they
+// are that far behind.
+func (b *Backend) enqueue(n notification) {
+	b.notifyOnce.Do(func() {
+		b.notifications = make(chan notification, notifyQueueSize)
+		for i := 0; i < notifyWorkers; i++ {
+			go b.notifyWorker()
+		}
+	})
+	select {
+	case b.notifications <- n:
+	default:
+		log.Println("Notification queue is full, dropping the notification to", n.webhook.Kind)
 	}
+}
 
-	fmt.Println(address)
+func (b *Backend) notifyWorker() {
+	for n := range b.notifications {
+		notifier, err := notify.New(n.webhook, b.notify)
+		if err == nil {
+			err = notifier.Notify(context.TODO(), n.mail)
+		}
+		if err != nil {
+			log.Println("Notification to", n.webhook.Kind, "failed:", err)
+		}
+	}
+}
 
-	from, err := dec.DecodeHeader(msg.Header.Get("From"))
+// decodeHeader decodes the encoded words of a header, a header that can
+// not be decoded is kept as it was sent.
+func decodeHeader(header mail.Header, key string) string {
+	value := header.Get(key)
+	decoded, err := wordDecoder.DecodeHeader(value)
 	if err != nil {
-		fmt.Println("3")
-		return err
+		return value
 	}
+	return decoded
+}
 
-	cc, err := dec.DecodeHeader(msg.Header.Get("Cc"))
+// quarantine keeps the raw bytes of a message that could not be parsed and
+// returns the error for the client.
+func (s *Session) quarantine(raw []byte, reason error) error {
+	log.Println("Quarantining unparseable mail from", s.from+":", reason)
+	if s.backend.quarantine == nil {
+		return errUnparseable
+	}
+	_, err := s.backend.quarantine.InsertQuarantined(context.TODO(), &store.QuarantinedMail{
+		Raw:       raw,
+		Reason:    reason.Error(),
+		From:      s.from,
+		Rcpts:     s.rcpts,
+		Inbox:     s.inbox,
+		Size:      len(raw),
+		CreatedAt: time.Now().UTC(),
+	})
 	if err != nil {
-		fmt.Println("4")
-		return err
+		log.Println("Quarantining mail failed:", err)
 	}
+	return errUnparseable
+}
 
-	bcc, err := dec.DecodeHeader(msg.Header.Get("Bcc"))
+// addWatcherWebhooks adds the webhooks of the users who can see the mail
+// on the dashboard: the mails of a user inbox reach its user only, users
+// with mails:all see every other mail and other readers the mails of their
+// projects.
+func (s *Session) addWatcherWebhooks(mail store.Mail) {
+	if s.backend.users == nil {
+		return
+	}
+	watchers, err := s.backend.loadWatchers()
 	if err != nil {
-		fmt.Println("5")
-		return err
+		log.Println("Listing user webhooks failed:", err)
+		return
 	}
 
-	contentType, err := dec.DecodeHeader(msg.Header.Get("Content-Type"))
-	if err != nil {
-		fmt.Println("6")
-		return err
+	var project *store.Project
+	if mail.Inbox != "" && s.backend.projects != nil {
+		project, err = s.backend.projects.FindProjectByInbox(context.TODO(), mail.Inbox)
+		if err != nil && err != store.ErrNotFound {
+			log.Println("Finding the project of the mail failed:", err)
+		}
 	}
 
-	mimeVersion, err := dec.DecodeHeader(msg.Header.Get("Mime-Version"))
-	if err != nil {
-		fmt.Println("7")
-		return err
+	for _, w := range watchers {
+		if strings.HasPrefix(mail.Inbox, store.UserInboxPrefix) {
+			if mail.Inbox != store.UserInbox(w.username) {
+				continue
+			}
+		} else if !rbac.Has(w.permissions, rbac.MailsAll) {
+			if project == nil {
+				continue
+			}
+			if _, ok := project.Member(w.username); !ok {
+				continue
+			}
+		}
+		for _, webhook := range w.webhooks {
+			s.addWebhook(webhook)
+		}
 	}
-	fmt.Println(
-		"rcpt: "+address[0],
-		"mimeVersion: "+mimeVersion,
-		"contentType: "+contentType,
-	)
+}
 
-	var newMail mailDto
-	newMail.Data = string(b)
-	newMail.Subject = subject
-	newMail.To = to
-	newMail.From = from
-	newMail.Body = string(body)
-	newMail.Rcpt = address[0]
-	newMail.MimeVersion = mimeVersion
-	newMail.ContentType = contentType
-	newMail.Cc = cc
-	newMail.Bcc = bcc
-	newMail.CreatedAt = time.Now().UTC().String()
-	newMail.IsRead = 0
-	var mailCollection = s.backend.client.Database(os.Getenv("MONGO_TABLE_NAME")).Collection("mails")
-	insert, err := mailCollection.InsertOne(context.TODO(), newMail)
-	if err != nil {
-		log.Fatal(err)
+// loadWatchers returns the users with webhooks who can read mails, from
+// the cache while it is fresh.
+func (b *Backend) loadWatchers() ([]watcher, error) {
+	b.watchersMu.Lock()
+	defer b.watchersMu.Unlock()
+	if time.Now().Before(b.watchersExpire) {
+		return b.watchers, nil
 	}
-	fmt.Println(insert.InsertedID)
 
-	resp, err := http.Post(
-		s.webhook,
-		"application/json",
-		bytes.NewBuffer(reqBody),
-	)
+	users, err := b.users.ListUsers(context.TODO())
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer resp.Body.Close()
+	watchers := []watcher{}
+	for _, user := range users {
+		if len(user.Webhooks) == 0 {
+			continue
+		}
+		permissions, err := rbac.Resolve(context.TODO(), b.roles, user.Role)
+		if err != nil {
+			log.Println("Finding the permissions of", user.Username, "failed:", err)
+			continue
+		}
+		if !rbac.Has(permissions, rbac.MailsRead) {
+			continue
+		}
+		watchers = append(watchers, watcher{username: user.Username, permissions: permissions, webhooks: user.Webhooks})
+	}
+	b.watchers = watchers
+	b.watchersExpire = time.Now().Add(watcherCacheTTL)
+	return watchers, nil
+}
 
-	return nil
+// saveAttachments moves the binary parts into the attachment storage and
+// leaves a reference to the stored file on each part. When one can not be
+// saved the ones before it are deleted again.
+func (s *Session) saveAttachments(parts []store.Part) ([]store.Attachment, error) {
+	var attachments []store.Attachment
+	for i, part := range parts {
+		if part.Raw == nil {
+			continue
+		}
+		id := primitive.NewObjectID().Hex()
+		err := s.backend.attachments.Save(context.TODO(), id, part.Filename, bytes.NewReader(part.Raw))
+		if err != nil {
+			for _, a := range attachments {
+				s.backend.attachments.Delete(context.TODO(), a.Id)
+			}
+			return nil, err
+		}
+		parts[i].AttachmentId = id
+		parts[i].Raw = nil
+		attachments = append(attachments, store.Attachment{
+			Id:          id,
+			Filename:    part.Filename,
+			ContentType: part.ContentType,
+			ContentId:   part.ContentId,
+			Inline:      part.Kind == PartKindInline,
+			Size:        part.Size,
+		})
+	}
+	return attachments, nil
 }
 
-func (s *Session) Reset() {}
+func (s *Session) Reset() {
+	s.from = ""
+	s.webhooks = nil
+	s.rcpts = nil
+}
 
 func (s *Session) Logout() error {
 	return nil
@@ -1,31 +1,61 @@
 package smtp
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 
+	"discord-smtp-server/attachment"
+	"discord-smtp-server/authn"
+	"discord-smtp-server/discord"
+	"discord-smtp-server/events"
+	"discord-smtp-server/ldap/ldaptest"
+	"discord-smtp-server/notify"
+	"discord-smtp-server/password"
+	"discord-smtp-server/rbac"
+	"discord-smtp-server/store"
 	"github.com/emersion/go-smtp"
-	"github.com/kylegrantlucas/discord-smtp-server/discord"
+	"golang.org/x/crypto/bcrypt"
 )
 
 func TestNewBackend(t *testing.T) {
-	type args struct {
-		discordToken string
-		username     string
-		password     string
+	mails := store.NewMemory()
+	attachments, err := attachment.NewDir(t.TempDir())
+	if err != nil {
+		t.Fatalf("attachment.NewDir() error = %v", err)
 	}
+	bus := events.NewBus()
+	passwords, _ := password.New(password.Config{})
 	tests := []struct {
 		name    string
-		args    args
+		cfg     Config
 		want    *Backend
 		wantErr bool
 	}{
-		// TODO: Add test cases.
+		{
+			"Store and attachments",
+			Config{Mails: mails, Attachments: attachments, Events: bus, Webhook: "https://discord/fallback", Username: "demo", Password: "demo"},
+			&Backend{mails: mails, passwords: passwords, attachments: attachments, events: bus, webhook: "https://discord/fallback", username: "demo", password: "demo"},
+			false,
+		},
+		{
+			"Missing mail store",
+			Config{Attachments: attachments},
+			nil,
+			true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := NewBackend(tt.args.discordToken, tt.args.username, tt.args.password)
+			got, err := NewBackend(tt.cfg)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("NewBackend() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -38,39 +68,75 @@ func TestNewBackend(t *testing.T) {
 }
 
 func TestBackend_Login(t *testing.T) {
+	ctx := context.Background()
+	db := store.NewMemory()
+	hash := func(password string) string {
+		h, _ := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+		return string(h)
+	}
+	db.InsertCredential(ctx, &store.SmtpCredential{Username: "billing-app", Password: hash("app-secret"), Inbox: "billing"})
+	db.InsertUser(ctx, &store.User{Username: "ayse", Password: hash("user-secret"), Role: "watcher"})
+	db.InsertUser(ctx, &store.User{Username: "billing", Password: hash("user-secret"), Role: "watcher"})
+	passwords, _ := password.New(password.Config{})
+	local := &authn.Local{Users: db, Passwords: passwords}
+
+	directory := ldaptest.NewDirectory()
+	defer directory.Close()
+	directory.Add(ldaptest.Entry{DN: "uid=mehmet,dc=example,dc=com", Attributes: map[string][]string{"uid": {"mehmet"}}}, "directory-secret")
+	dirAndLocal := authn.Chain{&authn.LDAP{URL: directory.URL, BaseDN: "dc=example,dc=com", DefaultRole: "watcher", Users: db}, local}
+
 	type fields struct {
-		discordClient *discord.Client
+		authenticator authn.Authenticator
+		credentials   store.CredentialStore
 		username      string
 		password      string
 	}
 	type args struct {
-		state    *smtp.ConnectionState
 		username string
 		password string
 	}
 	tests := []struct {
-		name    string
-		fields  fields
-		args    args
-		want    smtp.Session
-		wantErr bool
+		name      string
+		fields    fields
+		args      args
+		wantInbox string
+		wantCode  int
 	}{
-		// TODO: Add test cases.
+		{"Credential", fields{local, db, "demo", "demo"}, args{"billing-app", "app-secret"}, "billing", 0},
+		{"Credential with a wrong password", fields{local, db, "demo", "demo"}, args{"billing-app", "demo"}, "", 535},
+		{"User", fields{local, db, "demo", "demo"}, args{"ayse", "user-secret"}, "user:ayse", 0},
+		{"User named like a project inbox", fields{local, db, "demo", "demo"}, args{"billing", "user-secret"}, "user:billing", 0},
+		{"User with a wrong password", fields{local, db, "demo", "demo"}, args{"ayse", "app-secret"}, "", 535},
+		{"Directory user", fields{dirAndLocal, db, "demo", "demo"}, args{"mehmet", "directory-secret"}, "user:mehmet", 0},
+		{"Local user next to a directory", fields{dirAndLocal, db, "demo", "demo"}, args{"ayse", "user-secret"}, "user:ayse", 0},
+		{"Directory user with a wrong password", fields{dirAndLocal, db, "demo", "demo"}, args{"mehmet", "user-secret"}, "", 535},
+		{"Unreachable directory", fields{&authn.LDAP{URL: "ldap://127.0.0.1:1", Users: db}, db, "demo", "demo"}, args{"mehmet", "directory-secret"}, "", 454},
+		{"Configured pair", fields{local, db, "demo", "demo"}, args{"demo", "demo"}, "", 0},
+		{"Configured pair without stores", fields{nil, nil, "demo", "demo"}, args{"demo", "demo"}, "", 0},
+		{"Unknown user", fields{local, db, "demo", "demo"}, args{"nobody", "demo"}, "", 535},
+		{"Empty configured pair", fields{nil, nil, "", ""}, args{"", ""}, "", 535},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			b := &Backend{
-				discordClient: tt.fields.discordClient,
+				authenticator: tt.fields.authenticator,
+				credentials:   tt.fields.credentials,
+				passwords:     passwords,
 				username:      tt.fields.username,
 				password:      tt.fields.password,
 			}
-			got, err := b.Login(tt.args.state, tt.args.username, tt.args.password)
-			if (err != nil) != tt.wantErr {
-				t.Errorf("Backend.Login() error = %v, wantErr %v", err, tt.wantErr)
+			got, err := b.Login(nil, tt.args.username, tt.args.password)
+			if tt.wantCode != 0 {
+				if smtpErr, ok := err.(*smtp.SMTPError); !ok || smtpErr.Code != tt.wantCode {
+					t.Errorf("Backend.Login() error = %v, want code %d", err, tt.wantCode)
+				}
 				return
 			}
-			if !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("Backend.Login() = %v, want %v", got, tt.want)
+			if err != nil {
+				t.Fatalf("Backend.Login() error = %v", err)
+			}
+			if inbox := got.(*Session).inbox; inbox != tt.wantInbox {
+				t.Errorf("Session.inbox = %q, want %q", inbox, tt.wantInbox)
 			}
 		})
 	}
@@ -78,9 +144,11 @@ func TestBackend_Login(t *testing.T) {
 
 func TestBackend_AnonymousLogin(t *testing.T) {
 	type fields struct {
-		discordClient *discord.Client
-		username      string
-		password      string
+		mails       store.MailStore
+		attachments attachment.Storage
+		webhook     string
+		username    string
+		password    string
 	}
 	type args struct {
 		state *smtp.ConnectionState
@@ -97,9 +165,11 @@ func TestBackend_AnonymousLogin(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			b := &Backend{
-				discordClient: tt.fields.discordClient,
-				username:      tt.fields.username,
-				password:      tt.fields.password,
+				mails:       tt.fields.mails,
+				attachments: tt.fields.attachments,
+				webhook:     tt.fields.webhook,
+				username:    tt.fields.username,
+				password:    tt.fields.password,
 			}
 			got, err := b.AnonymousLogin(tt.args.state)
 			if (err != nil) != tt.wantErr {
@@ -115,9 +185,9 @@ func TestBackend_AnonymousLogin(t *testing.T) {
 
 func TestSession_Mail(t *testing.T) {
 	type fields struct {
-		backend *Backend
-		webhook string
-		from    string
+		backend  *Backend
+		webhooks []store.Webhook
+		from     string
 	}
 	type args struct {
 		from string
@@ -134,9 +204,9 @@ func TestSession_Mail(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			s := &Session{
-				backend: tt.fields.backend,
-				webhook: tt.fields.webhook,
-				from:    tt.fields.from,
+				backend:  tt.fields.backend,
+				webhooks: tt.fields.webhooks,
+				from:     tt.fields.from,
 			}
 			if err := s.Mail(tt.args.from, tt.args.opts); (err != nil) != tt.wantErr {
 				t.Errorf("Session.Mail() error = %v, wantErr %v", err, tt.wantErr)
@@ -145,73 +215,302 @@ func TestSession_Mail(t *testing.T) {
 	}
 }
 
+type fakeResolver map[string]string
+
+func (f fakeResolver) Resolve(address string) (string, error) {
+	if webhook, ok := f[address]; ok {
+		return webhook, nil
+	}
+	return "", discord.ErrWebhookNotFound
+}
+
+// unreachableResolver fails like discord does during an outage.
+type unreachableResolver struct{}
+
+func (unreachableResolver) Resolve(address string) (string, error) {
+	return "", errors.New("HTTP 503 Service Unavailable")
+}
+
 func TestSession_Rcpt(t *testing.T) {
+	resolver := fakeResolver{
+		"bot@general.acme": "https://discord/general",
+		"bot@alerts.acme":  "https://discord/alerts",
+	}
+	mailboxes := notify.Mailboxes{
+		"ops@example.com": {{Kind: notify.KindSlack, URL: "https://slack/ops"}},
+	}
 	type fields struct {
-		backend *Backend
-		webhook string
-		from    string
+		backend  *Backend
+		webhooks []store.Webhook
+		from     string
 	}
 	type args struct {
 		to string
 	}
 	tests := []struct {
-		name    string
-		fields  fields
-		args    args
-		wantErr bool
+		name     string
+		fields   fields
+		args     args
+		want     []store.Webhook
+		wantCode int
 	}{
-		// TODO: Add test cases.
+		{
+			"Without resolver uses the fallback webhook",
+			fields{backend: &Backend{webhook: "https://discord/fallback"}},
+			args{"anyone@example.com"},
+			[]store.Webhook{{Kind: notify.KindDiscord, URL: "https://discord/fallback"}},
+			0,
+		},
+		{
+			"Resolved recipient",
+			fields{backend: &Backend{resolver: resolver, webhook: "https://discord/fallback"}},
+			args{"bot@alerts.acme"},
+			[]store.Webhook{{Kind: notify.KindDiscord, URL: "https://discord/alerts"}},
+			0,
+		},
+		{
+			"Same webhook is added once",
+			fields{backend: &Backend{resolver: resolver}, webhooks: []store.Webhook{{Kind: notify.KindDiscord, URL: "https://discord/general"}}},
+			args{"bot@general.acme"},
+			[]store.Webhook{{Kind: notify.KindDiscord, URL: "https://discord/general"}},
+			0,
+		},
+		{
+			"Unknown recipient falls back",
+			fields{backend: &Backend{resolver: resolver, webhook: "https://discord/fallback"}},
+			args{"nobody@general.acme"},
+			[]store.Webhook{{Kind: notify.KindDiscord, URL: "https://discord/fallback"}},
+			0,
+		},
+		{
+			"Mailbox webhooks are added to the discord one",
+			fields{backend: &Backend{mailboxes: mailboxes, webhook: "https://discord/fallback"}},
+			args{"OPS@example.com"},
+			[]store.Webhook{{Kind: notify.KindDiscord, URL: "https://discord/fallback"}, {Kind: notify.KindSlack, URL: "https://slack/ops"}},
+			0,
+		},
+		{
+			"Unknown recipient with a mailbox is accepted",
+			fields{backend: &Backend{resolver: resolver, mailboxes: mailboxes}},
+			args{"ops@example.com"},
+			[]store.Webhook{{Kind: notify.KindSlack, URL: "https://slack/ops"}},
+			0,
+		},
+		{
+			"Unknown recipient is rejected",
+			fields{backend: &Backend{resolver: resolver, rejectUnknown: true, webhook: "https://discord/fallback"}},
+			args{"nobody@general.acme"},
+			nil,
+			550,
+		},
+		{
+			"Unknown recipient without fallback is rejected",
+			fields{backend: &Backend{resolver: resolver}},
+			args{"nobody@general.acme"},
+			nil,
+			550,
+		},
+		{
+			"Unreachable discord defers the recipient",
+			fields{backend: &Backend{resolver: unreachableResolver{}}},
+			args{"bot@general.acme"},
+			nil,
+			451,
+		},
+		{
+			"Unreachable discord defers a rejected recipient",
+			fields{backend: &Backend{resolver: unreachableResolver{}, rejectUnknown: true, webhook: "https://discord/fallback"}},
+			args{"bot@general.acme"},
+			nil,
+			451,
+		},
+		{
+			"Unreachable discord falls back",
+			fields{backend: &Backend{resolver: unreachableResolver{}, webhook: "https://discord/fallback"}},
+			args{"bot@general.acme"},
+			[]store.Webhook{{Kind: notify.KindDiscord, URL: "https://discord/fallback"}},
+			0,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			s := &Session{
-				backend: tt.fields.backend,
-				webhook: tt.fields.webhook,
-				from:    tt.fields.from,
+				backend:  tt.fields.backend,
+				webhooks: tt.fields.webhooks,
+				from:     tt.fields.from,
 			}
-			if err := s.Rcpt(tt.args.to); (err != nil) != tt.wantErr {
-				t.Errorf("Session.Rcpt() error = %v, wantErr %v", err, tt.wantErr)
+			err := s.Rcpt(tt.args.to)
+			code := 0
+			if smtpErr, ok := err.(*smtp.SMTPError); ok {
+				code = smtpErr.Code
+			} else if err != nil {
+				t.Fatalf("Session.Rcpt() error = %v", err)
+			}
+			if code != tt.wantCode {
+				t.Errorf("Session.Rcpt() code = %d, want %d", code, tt.wantCode)
+			}
+			if !reflect.DeepEqual(s.webhooks, tt.want) {
+				t.Errorf("Session.webhooks = %v, want %v", s.webhooks, tt.want)
 			}
 		})
 	}
 }
 
+// failingMails refuses every insert.
+type failingMails struct {
+	store.MailStore
+}
+
+func (failingMails) InsertMail(ctx context.Context, mail *store.Mail) (string, error) {
+	return "", errors.New("connection refused")
+}
+
 func TestSession_Data(t *testing.T) {
-	type fields struct {
-		backend *Backend
-		webhook string
-		from    string
-	}
-	type args struct {
-		r io.Reader
+	message := func(lines ...string) string {
+		return strings.Join(lines, "\r\n")
 	}
+	withAttachment := message(
+		"Date: Wed, 19 Jul 2023 13:00:00 +0300",
+		"Subject: =?UTF-8?B?SGVsbG8=?=",
+		"Content-Type: multipart/mixed; boundary=b",
+		"",
+		"--b",
+		"Content-Type: text/plain",
+		"",
+		"hi",
+		"--b",
+		"Content-Type: application/pdf",
+		"Content-Disposition: attachment; filename=a.pdf",
+		"",
+		"%PDF",
+		"--b--",
+		"",
+	)
 	tests := []struct {
-		name    string
-		fields  fields
-		args    args
-		wantErr bool
+		name           string
+		data           string
+		failInsert     bool
+		wantCode       int
+		wantMails      int
+		wantQuarantine int
 	}{
-		// TODO: Add test cases.
+		{"Stored", withAttachment, false, 0, 1, 0},
+		{"Undecodable header is kept raw", message("Subject: =?x-unknown?Q?abc?=", "", "hi", ""), false, 0, 1, 0},
+		{"Malformed header", message("not a header", "", "hi", ""), false, 554, 0, 1},
+		{"Broken multipart", message("Content-Type: multipart/mixed; boundary=b", "", "no parts here", ""), false, 554, 0, 1},
+		{"Storage failure", withAttachment, true, 451, 0, 0},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			s := &Session{
-				backend: tt.fields.backend,
-				webhook: tt.fields.webhook,
-				from:    tt.fields.from,
+			ctx := context.Background()
+			dir := t.TempDir()
+			attachments, err := attachment.NewDir(dir)
+			if err != nil {
+				t.Fatalf("attachment.NewDir() error = %v", err)
+			}
+			memory := store.NewMemory()
+			cfg := Config{Mails: memory, Quarantine: memory, Attachments: attachments}
+			if tt.failInsert {
+				cfg.Mails = failingMails{memory}
+			}
+			backend, _ := NewBackend(cfg)
+			s := &Session{backend: backend, inbox: "shop", from: "app@example.com"}
+			s.Rcpt("to@example.com")
+
+			err = s.Data(strings.NewReader(tt.data))
+			code := 0
+			var smtpErr *smtp.SMTPError
+			if errors.As(err, &smtpErr) {
+				code = smtpErr.Code
+			} else if err != nil {
+				t.Fatalf("Session.Data() error = %v, want an smtp error", err)
 			}
-			if err := s.Data(tt.args.r); (err != nil) != tt.wantErr {
-				t.Errorf("Session.Data() error = %v, wantErr %v", err, tt.wantErr)
+			if code != tt.wantCode {
+				t.Errorf("Session.Data() code = %d, want %d", code, tt.wantCode)
+			}
+
+			mails, _ := memory.ListMails(ctx, store.MailFilter{})
+			if len(mails) != tt.wantMails {
+				t.Errorf("stored %d mails, want %d", len(mails), tt.wantMails)
+			}
+			if len(mails) == 1 && tt.data == withAttachment {
+				if want := time.Date(2023, 7, 19, 10, 0, 0, 0, time.UTC); !mails[0].Date.Equal(want) || mails[0].CreatedAt.IsZero() {
+					t.Errorf("stored Date = %v, CreatedAt = %v, want Date %v", mails[0].Date, mails[0].CreatedAt, want)
+				}
+			}
+			quarantined, _ := memory.ListQuarantined(ctx)
+			if len(quarantined) != tt.wantQuarantine {
+				t.Fatalf("quarantined %d mails, want %d", len(quarantined), tt.wantQuarantine)
+			}
+			if len(quarantined) == 1 {
+				got, _ := memory.FindQuarantined(ctx, quarantined[0].Id)
+				if string(got.Raw) != tt.data || got.From != "app@example.com" || !reflect.DeepEqual(got.Rcpts, []string{"to@example.com"}) || got.Inbox != "shop" {
+					t.Errorf("quarantined mail = %+v", got)
+				}
+			}
+			if tt.failInsert {
+				files, _ := os.ReadDir(dir)
+				if len(files) != 0 {
+					t.Errorf("attachments of the refused mail were kept: %v", files)
+				}
 			}
 		})
 	}
 }
 
+func TestSession_Data_notifications(t *testing.T) {
+	// the webhook answers only after the session is done
+	release := make(chan struct{})
+	received := make(chan string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		var body struct {
+			Mail struct {
+				Subject string `json:"subject"`
+			} `json:"mail"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		received <- body.Mail.Subject
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	attachments, _ := attachment.NewDir(t.TempDir())
+	backend, _ := NewBackend(Config{
+		Mails:       store.NewMemory(),
+		Attachments: attachments,
+		Mailboxes:   notify.Mailboxes{"to@example.com": {{Kind: notify.KindWebhook, URL: srv.URL}}},
+	})
+	s := &Session{backend: backend, from: "app@example.com"}
+	s.Rcpt("to@example.com")
+
+	done := make(chan error, 1)
+	go func() { done <- s.Data(strings.NewReader("Subject: Hello\r\n\r\nhi\r\n")) }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Session.Data() error = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Session.Data() waited for the webhook")
+	}
+
+	release <- struct{}{}
+	select {
+	case subject := <-received:
+		if subject != "Hello" {
+			t.Errorf("notified subject = %q, want Hello", subject)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the webhook was not notified")
+	}
+}
+
 func TestSession_Reset(t *testing.T) {
 	type fields struct {
-		backend *Backend
-		webhook string
-		from    string
+		backend  *Backend
+		webhooks []store.Webhook
+		from     string
 	}
 	tests := []struct {
 		name   string
@@ -222,9 +521,9 @@ func TestSession_Reset(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			s := &Session{
-				backend: tt.fields.backend,
-				webhook: tt.fields.webhook,
-				from:    tt.fields.from,
+				backend:  tt.fields.backend,
+				webhooks: tt.fields.webhooks,
+				from:     tt.fields.from,
 			}
 			s.Reset()
 		})
@@ -233,9 +532,9 @@ func TestSession_Reset(t *testing.T) {
 
 func TestSession_Logout(t *testing.T) {
 	type fields struct {
-		backend *Backend
-		webhook string
-		from    string
+		backend  *Backend
+		webhooks []store.Webhook
+		from     string
 	}
 	tests := []struct {
 		name    string
@@ -247,9 +546,9 @@ func TestSession_Logout(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			s := &Session{
-				backend: tt.fields.backend,
-				webhook: tt.fields.webhook,
-				from:    tt.fields.from,
+				backend:  tt.fields.backend,
+				webhooks: tt.fields.webhooks,
+				from:     tt.fields.from,
 			}
 			if err := s.Logout(); (err != nil) != tt.wantErr {
 				t.Errorf("Session.Logout() error = %v, wantErr %v", err, tt.wantErr)
@@ -257,3 +556,137 @@ func TestSession_Logout(t *testing.T) {
 		})
 	}
 }
+
+func TestSession_saveAttachments(t *testing.T) {
+	storage, err := attachment.NewDir(t.TempDir())
+	if err != nil {
+		t.Fatalf("attachment.NewDir() error = %v", err)
+	}
+	s := &Session{
+		backend: &Backend{attachments: storage},
+	}
+	parts := []store.Part{
+		{Kind: PartKindText, Content: "hello"},
+		{Kind: PartKindInline, ContentType: "image/png", ContentId: "logo", Size: 3, Raw: []byte("png")},
+		{Kind: PartKindAttachment, ContentType: "application/pdf", Filename: "invoice.pdf", Size: 4, Raw: []byte("%PDF")},
+	}
+
+	got, err := s.saveAttachments(parts)
+	if err != nil {
+		t.Fatalf("Session.saveAttachments() error = %v", err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("Session.saveAttachments() returned %d attachments, want 2", len(got))
+	}
+	if !got[0].Inline || got[0].ContentId != "logo" || got[1].Inline || got[1].Filename != "invoice.pdf" {
+		t.Errorf("Session.saveAttachments() = %+v", got)
+	}
+	for i, part := range parts[1:] {
+		if part.Raw != nil || part.AttachmentId != got[i].Id {
+			t.Errorf("part %d not moved to storage: %+v", i+1, part)
+		}
+	}
+
+	r, err := storage.Open(context.Background(), got[1].Id)
+	if err != nil {
+		t.Fatalf("storage.Open() error = %v", err)
+	}
+	defer r.Close()
+	content, _ := io.ReadAll(r)
+	if string(content) != "%PDF" {
+		t.Errorf("stored content = %q, want %q", content, "%PDF")
+	}
+
+	// a failed save deletes the attachments saved before it
+	dir := t.TempDir()
+	storage, _ = attachment.NewDir(dir)
+	s.backend.attachments = &failingSaves{storage, 1}
+	parts = []store.Part{
+		{Kind: PartKindAttachment, Filename: "a.pdf", Raw: []byte("%PDF")},
+		{Kind: PartKindAttachment, Filename: "b.pdf", Raw: []byte("%PDF")},
+	}
+	if _, err := s.saveAttachments(parts); err == nil {
+		t.Fatal("Session.saveAttachments() error = nil, want the failed save")
+	}
+	if files, _ := os.ReadDir(dir); len(files) != 0 {
+		t.Errorf("attachments kept after a failed save: %v", files)
+	}
+}
+
+// failingSaves refuses the saves after the first ok ones.
+type failingSaves struct {
+	attachment.Storage
+	ok int
+}
+
+func (f *failingSaves) Save(ctx context.Context, id, filename string, r io.Reader) error {
+	if f.ok == 0 {
+		return errors.New("disk full")
+	}
+	f.ok--
+	return f.Storage.Save(ctx, id, filename, r)
+}
+
+func TestSession_addWatcherWebhooks(t *testing.T) {
+	users := store.NewMemory()
+	ctx := context.Background()
+	users.InsertUser(ctx, &store.User{Username: "admin", Role: "admin", Webhooks: []store.Webhook{{Kind: notify.KindSlack, URL: "https://slack/admin"}}})
+	users.InsertUser(ctx, &store.User{Username: "team", Role: "watcher", Webhooks: []store.Webhook{{Kind: notify.KindTeams, URL: "https://teams/team"}}})
+	users.InsertUser(ctx, &store.User{Username: "other", Role: "watcher", Webhooks: []store.Webhook{{Kind: notify.KindWebhook, URL: "https://hooks/other"}}})
+	users.InsertUser(ctx, &store.User{Username: "auditor", Role: "auditor", Webhooks: []store.Webhook{{Kind: notify.KindWebhook, URL: "https://hooks/auditor"}}})
+	users.InsertUser(ctx, &store.User{Username: "support", Role: "support", Webhooks: []store.Webhook{{Kind: notify.KindWebhook, URL: "https://hooks/support"}}})
+	users.InsertRole(ctx, &store.Role{Name: "auditor", Permissions: []string{rbac.MailsRead, rbac.MailsAll}})
+	users.InsertRole(ctx, &store.Role{Name: "support", Permissions: []string{rbac.TicketsManage}})
+	users.InsertProject(ctx, &store.Project{Name: "App", Inbox: "app", Members: []store.ProjectMember{
+		{Username: "team", Role: store.ProjectRoleViewer},
+		{Username: "support", Role: store.ProjectRoleViewer},
+	}})
+
+	tests := []struct {
+		name string
+		mail store.Mail
+		want []store.Webhook
+	}{
+		{
+			"Every inbox and the readers of the project",
+			store.Mail{Inbox: "app"},
+			[]store.Webhook{{Kind: notify.KindSlack, URL: "https://slack/admin"}, {Kind: notify.KindTeams, URL: "https://teams/team"}, {Kind: notify.KindWebhook, URL: "https://hooks/auditor"}},
+		},
+		{
+			"Only every inbox without a project",
+			store.Mail{Inbox: "unknown"},
+			[]store.Webhook{{Kind: notify.KindSlack, URL: "https://slack/admin"}, {Kind: notify.KindWebhook, URL: "https://hooks/auditor"}},
+		},
+		{
+			"Only the user of a user inbox",
+			store.Mail{Inbox: store.UserInbox("other")},
+			[]store.Webhook{{Kind: notify.KindWebhook, URL: "https://hooks/other"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Session{backend: &Backend{users: users, projects: users, roles: users}}
+			s.addWatcherWebhooks(tt.mail)
+			if !reflect.DeepEqual(s.webhooks, tt.want) {
+				t.Errorf("Session.webhooks = %v, want %v", s.webhooks, tt.want)
+			}
+		})
+	}
+
+	// the watchers are cached, a new user is told once the cache expires
+	backend := &Backend{users: users, projects: users, roles: users}
+	watched := func() int {
+		s := &Session{backend: backend}
+		s.addWatcherWebhooks(store.Mail{Inbox: "unknown"})
+		return len(s.webhooks)
+	}
+	watched()
+	users.InsertUser(ctx, &store.User{Username: "late", Role: "admin", Webhooks: []store.Webhook{{Kind: notify.KindSlack, URL: "https://slack/late"}}})
+	if got := watched(); got != 2 {
+		t.Errorf("%d webhooks from the cache, want 2", got)
+	}
+	backend.watchersExpire = time.Time{}
+	if got := watched(); got != 3 {
+		t.Errorf("%d webhooks after the cache expired, want 3", got)
+	}
+}
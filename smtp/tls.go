@@ -0,0 +1,96 @@
+package smtp
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"github.com/emersion/go-smtp"
+	"math/big"
+	"net"
+	"time"
+)
+
+// TLSConfig loads the certificate pair, or generates a self-signed
+// certificate for host when selfSigned is set. It returns nil when TLS is
+// not configured.
+func TLSConfig(certFile, keyFile string, selfSigned bool, host string) (*tls.Config, error) {
+	var cert tls.Certificate
+	var err error
+	switch {
+	case certFile != "" || keyFile != "":
+		if certFile == "" || keyFile == "" {
+			return nil, errors.New("tls needs both a certificate and a key file")
+		}
+		cert, err = tls.LoadX509KeyPair(certFile, keyFile)
+	case selfSigned:
+		cert, err = selfSignedCertificate(host)
+	default:
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// selfSignedCertificate creates a certificate for development, valid for a
+// year for host, localhost and the loopback addresses.
+func selfSignedCertificate(host string) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	template := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"MailTracker"}, CommonName: host},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(1, 0, 0),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		template.IPAddresses = append(template.IPAddresses, ip)
+	} else if host != "" && host != "localhost" {
+		template.DNSNames = append(template.DNSNames, host)
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  key,
+	}, nil
+}
+
+// NewServer creates the smtp server for backend. With a tls config the
+// server offers STARTTLS and only accepts AUTH over TLS.
+func NewServer(backend *Backend, addr, domain string, tlsConfig *tls.Config) *smtp.Server {
+	server := smtp.NewServer(backend)
+	server.Addr = addr
+	server.Domain = domain
+	server.ReadTimeout = 10 * time.Second
+	server.WriteTimeout = 10 * time.Second
+	server.MaxMessageBytes = 1024 * 1024
+	server.MaxRecipients = 50
+	server.TLSConfig = tlsConfig
+	server.AllowInsecureAuth = tlsConfig == nil
+	return server
+}
@@ -0,0 +1,126 @@
+package smtp
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"net"
+	gosmtp "net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"discord-smtp-server/attachment"
+	"discord-smtp-server/store"
+)
+
+func TestTLSConfig(t *testing.T) {
+	dir := t.TempDir()
+	cert, err := selfSignedCertificate("mail.example.com")
+	if err != nil {
+		t.Fatalf("selfSignedCertificate() error = %v", err)
+	}
+	key, _ := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key}), 0600)
+
+	tests := []struct {
+		name       string
+		certFile   string
+		keyFile    string
+		selfSigned bool
+		wantNil    bool
+		wantErr    bool
+	}{
+		{"Not configured", "", "", false, true, false},
+		{"Certificate files", certFile, keyFile, false, false, false},
+		{"Files win over self-signed", certFile, keyFile, true, false, false},
+		{"Self-signed", "", "", true, false, false},
+		{"Missing key", certFile, "", false, true, true},
+		{"Unreadable files", filepath.Join(dir, "none.pem"), keyFile, false, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := TLSConfig(tt.certFile, tt.keyFile, tt.selfSigned, "mail.example.com")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("TLSConfig() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if (got == nil) != tt.wantNil {
+				t.Errorf("TLSConfig() = %v, wantNil %v", got, tt.wantNil)
+			}
+		})
+	}
+
+	leaf, _ := x509.ParseCertificate(cert.Certificate[0])
+	if err := leaf.VerifyHostname("mail.example.com"); err != nil {
+		t.Errorf("self-signed certificate: %v", err)
+	}
+}
+
+func TestNewServer_starttls(t *testing.T) {
+	attachments, err := attachment.NewDir(t.TempDir())
+	if err != nil {
+		t.Fatalf("attachment.NewDir() error = %v", err)
+	}
+	mails := store.NewMemory()
+	backend, _ := NewBackend(Config{Mails: mails, Attachments: attachments, Username: "demo", Password: "demo"})
+	tlsConfig, _ := TLSConfig("", "", true, "localhost")
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen() error = %v", err)
+	}
+	server := NewServer(backend, "", "localhost", tlsConfig)
+	go server.Serve(l)
+	defer server.Close()
+
+	// AUTH is refused before STARTTLS, plain auth of net/smtp allows
+	// 127.0.0.1 without tls so the server has to refuse it itself
+	c, err := gosmtp.Dial(l.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	if ok, _ := c.Extension("STARTTLS"); !ok {
+		t.Errorf("STARTTLS is not offered")
+	}
+	if ok, _ := c.Extension("AUTH"); ok {
+		t.Errorf("AUTH is offered without tls")
+	}
+	c.Close()
+
+	c, err = gosmtp.Dial(l.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer c.Close()
+	if err := c.StartTLS(&tls.Config{InsecureSkipVerify: true}); err != nil {
+		t.Fatalf("StartTLS() error = %v", err)
+	}
+	if err := c.Auth(gosmtp.PlainAuth("", "demo", "demo", "127.0.0.1")); err != nil {
+		t.Fatalf("Auth() error = %v", err)
+	}
+	if err := c.Mail("app@example.com"); err != nil {
+		t.Fatalf("Mail() error = %v", err)
+	}
+	if err := c.Rcpt("to@example.com"); err != nil {
+		t.Fatalf("Rcpt() error = %v", err)
+	}
+	w, err := c.Data()
+	if err != nil {
+		t.Fatalf("Data() error = %v", err)
+	}
+	w.Write([]byte(strings.Join([]string{"Subject: over tls", "", "hello", ""}, "\r\n")))
+	if err := w.Close(); err != nil {
+		t.Fatalf("Data close error = %v", err)
+	}
+
+	found, _ := mails.ListMails(context.Background(), store.MailFilter{})
+	if len(found) != 1 || found[0].Subject != "over tls" {
+		t.Errorf("stored mails = %v", found)
+	}
+}
@@ -0,0 +1,792 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	mailsBucket          = []byte("mails")
+	usersBucket          = []byte("users")
+	ticketsBucket        = []byte("supports")
+	ticketMessagesBucket = []byte("support_messages")
+	credentialsBucket    = []byte("smtp_credentials")
+	projectsBucket       = []byte("projects")
+	quarantineBucket     = []byte("quarantine")
+	refreshTokensBucket  = []byte("refresh_tokens")
+	apiKeysBucket        = []byte("api_keys")
+	resetsBucket         = []byte("password_resets")
+	rolesBucket          = []byte("roles")
+)
+
+// Bolt stores documents as JSON in an embedded BoltDB file, keyed by ids
+// that sort in insertion order.
+type Bolt struct {
+	db *bolt.DB
+}
+
+func OpenBolt(path string) (*Bolt, error) {
+	db, err := bolt.Open(path, 0o600, nil)
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{mailsBucket, usersBucket, ticketsBucket, ticketMessagesBucket, credentialsBucket, projectsBucket, quarantineBucket, refreshTokensBucket, apiKeysBucket, resetsBucket, rolesBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &Bolt{
+		db: db,
+	}, nil
+}
+
+func (b *Bolt) Close(ctx context.Context) error {
+	return b.db.Close()
+}
+
+func (b *Bolt) put(bucket []byte, id string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).Put([]byte(id), data)
+	})
+}
+
+func (b *Bolt) get(bucket []byte, id string, v interface{}) error {
+	return b.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(bucket).Get([]byte(id))
+		if data == nil {
+			return ErrNotFound
+		}
+		return json.Unmarshal(data, v)
+	})
+}
+
+// update decodes the document into v, lets change modify it and writes it back.
+func (b *Bolt) update(bucket []byte, id string, v interface{}, change func()) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bk := tx.Bucket(bucket)
+		data := bk.Get([]byte(id))
+		if data == nil {
+			return ErrNotFound
+		}
+		if err := json.Unmarshal(data, v); err != nil {
+			return err
+		}
+		change()
+		data, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		return bk.Put([]byte(id), data)
+	})
+}
+
+// each calls fn for every document, newest first when reverse is set.
+func (b *Bolt) each(bucket []byte, reverse bool, fn func(data []byte) error) error {
+	return b.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(bucket).Cursor()
+		first, next := c.First, c.Next
+		if reverse {
+			first, next = c.Last, c.Prev
+		}
+		for k, v := first(); k != nil; k, v = next() {
+			if err := fn(v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (b *Bolt) delete(bucket []byte, id string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).Delete([]byte(id))
+	})
+}
+
+// deleteWhere deletes the values of the bucket that match.
+func (b *Bolt) deleteWhere(bucket []byte, match func(data []byte) (bool, error)) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bk := tx.Bucket(bucket)
+		var keys [][]byte
+		err := bk.ForEach(func(k, v []byte) error {
+			ok, err := match(v)
+			if ok {
+				keys = append(keys, k)
+			}
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		// keys can not be deleted while iterating
+		for _, k := range keys {
+			if err := bk.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// take deletes the first value of the bucket that matches and returns it,
+// ErrNotFound when none does.
+func (b *Bolt) take(bucket []byte, match func(data []byte) (bool, error)) ([]byte, error) {
+	var taken []byte
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		c := tx.Bucket(bucket).Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			ok, err := match(v)
+			if err != nil {
+				return err
+			}
+			if ok {
+				// the value is only valid in the transaction
+				taken = append([]byte{}, v...)
+				return c.Delete()
+			}
+		}
+		return ErrNotFound
+	})
+	return taken, err
+}
+
+func (b *Bolt) clear(bucket []byte) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.DeleteBucket(bucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucket(bucket)
+		return err
+	})
+}
+
+func (b *Bolt) InsertMail(ctx context.Context, mail *Mail) (string, error) {
+	mail.Id = newID()
+	return mail.Id, b.put(mailsBucket, mail.Id, mail)
+}
+
+func (b *Bolt) FindMail(ctx context.Context, id string) (*Mail, error) {
+	var mail Mail
+	if err := b.get(mailsBucket, id, &mail); err != nil {
+		return nil, err
+	}
+	return &mail, nil
+}
+
+func (b *Bolt) ListMails(ctx context.Context, filter MailFilter) ([]Mail, error) {
+	var mails []Mail
+	err := b.each(mailsBucket, true, func(data []byte) error {
+		var mail Mail
+		if err := json.Unmarshal(data, &mail); err != nil {
+			return err
+		}
+		if filter.matches(&mail) {
+			mails = append(mails, summary(mail))
+		}
+		return nil
+	})
+	return mails, err
+}
+
+func (b *Bolt) PageMails(ctx context.Context, filter MailFilter, page Page) (*MailPage, error) {
+	mails, err := b.ListMails(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	return pageMails(mails, page), nil
+}
+
+func (b *Bolt) MailAttachments(ctx context.Context, filter MailFilter) ([]Attachment, error) {
+	var attachments []Attachment
+	err := b.each(mailsBucket, false, func(data []byte) error {
+		var mail Mail
+		if err := json.Unmarshal(data, &mail); err != nil {
+			return err
+		}
+		if filter.matches(&mail) {
+			attachments = append(attachments, mail.Attachments...)
+		}
+		return nil
+	})
+	return attachments, err
+}
+
+func (b *Bolt) MarkMailRead(ctx context.Context, id string) error {
+	var mail Mail
+	return b.update(mailsBucket, id, &mail, func() {
+		mail.IsRead = 1
+	})
+}
+
+func (b *Bolt) MarkAllMailsRead(ctx context.Context, filter MailFilter) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bk := tx.Bucket(mailsBucket)
+		return bk.ForEach(func(k, v []byte) error {
+			var mail Mail
+			if err := json.Unmarshal(v, &mail); err != nil {
+				return err
+			}
+			if !filter.matches(&mail) {
+				return nil
+			}
+			mail.IsRead = 1
+			data, err := json.Marshal(mail)
+			if err != nil {
+				return err
+			}
+			return bk.Put(k, data)
+		})
+	})
+}
+
+func (b *Bolt) DeleteMail(ctx context.Context, id string) error {
+	return b.delete(mailsBucket, id)
+}
+
+func (b *Bolt) DeleteAllMails(ctx context.Context, filter MailFilter) error {
+	if filter.empty() {
+		return b.clear(mailsBucket)
+	}
+	return b.deleteWhere(mailsBucket, func(data []byte) (bool, error) {
+		var mail Mail
+		if err := json.Unmarshal(data, &mail); err != nil {
+			return false, err
+		}
+		return filter.matches(&mail), nil
+	})
+}
+
+func (b *Bolt) InsertUser(ctx context.Context, user *User) (string, error) {
+	user.Id = newID()
+	return user.Id, b.put(usersBucket, user.Id, user)
+}
+
+func (b *Bolt) FindUser(ctx context.Context, id string) (*User, error) {
+	var user User
+	if err := b.get(usersBucket, id, &user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (b *Bolt) findUser(match func(u *User) bool) (*User, error) {
+	users, err := b.ListUsers(context.TODO())
+	if err != nil {
+		return nil, err
+	}
+	for i := range users {
+		if match(&users[i]) {
+			return &users[i], nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (b *Bolt) FindUserByUsername(ctx context.Context, username string) (*User, error) {
+	return b.findUser(func(u *User) bool { return u.Username == username })
+}
+
+func (b *Bolt) FindUserBySalt(ctx context.Context, salt string) (*User, error) {
+	return b.findUser(func(u *User) bool { return u.Salt == salt })
+}
+
+func (b *Bolt) FindUserBySubject(ctx context.Context, subject string) (*User, error) {
+	return b.findUser(func(u *User) bool { return u.Subject == subject })
+}
+
+func (b *Bolt) ListUsers(ctx context.Context) ([]User, error) {
+	var users []User
+	err := b.each(usersBucket, false, func(data []byte) error {
+		var user User
+		if err := json.Unmarshal(data, &user); err != nil {
+			return err
+		}
+		users = append(users, user)
+		return nil
+	})
+	return users, err
+}
+
+func (b *Bolt) UpdateUser(ctx context.Context, user *User) error {
+	var stored User
+	return b.update(usersBucket, user.Id, &stored, func() {
+		stored.Username = user.Username
+		stored.Emails = user.Emails
+		stored.Role = user.Role
+	})
+}
+
+func (b *Bolt) UpdateUserPassword(ctx context.Context, id, password string) error {
+	var user User
+	return b.update(usersBucket, id, &user, func() {
+		user.Password = password
+	})
+}
+
+func (b *Bolt) UpdateUserWebhooks(ctx context.Context, id string, webhooks []Webhook) error {
+	var user User
+	return b.update(usersBucket, id, &user, func() {
+		user.Webhooks = webhooks
+	})
+}
+
+func (b *Bolt) UpdateUserSalt(ctx context.Context, id, salt string) error {
+	var user User
+	return b.update(usersBucket, id, &user, func() {
+		user.Salt = salt
+	})
+}
+
+func (b *Bolt) UpdateUserSubject(ctx context.Context, id, subject string) error {
+	var user User
+	return b.update(usersBucket, id, &user, func() {
+		user.Subject = subject
+	})
+}
+
+func (b *Bolt) DeleteUser(ctx context.Context, id string) error {
+	return b.delete(usersBucket, id)
+}
+
+func (b *Bolt) InsertTicket(ctx context.Context, ticket *Ticket) (string, error) {
+	ticket.Id = newID()
+	return ticket.Id, b.put(ticketsBucket, ticket.Id, ticket)
+}
+
+func (b *Bolt) FindTicket(ctx context.Context, id, username string) (*Ticket, error) {
+	var ticket Ticket
+	if err := b.get(ticketsBucket, id, &ticket); err != nil {
+		return nil, err
+	}
+	if username != "" && ticket.Username != username {
+		return nil, ErrNotFound
+	}
+	return &ticket, nil
+}
+
+func (b *Bolt) ListTickets(ctx context.Context, username string) ([]Ticket, error) {
+	var tickets []Ticket
+	err := b.each(ticketsBucket, true, func(data []byte) error {
+		var ticket Ticket
+		if err := json.Unmarshal(data, &ticket); err != nil {
+			return err
+		}
+		if username == "" || ticket.Username == username {
+			tickets = append(tickets, ticket)
+		}
+		return nil
+	})
+	return tickets, err
+}
+
+func (b *Bolt) SetTicketStatus(ctx context.Context, id, status string) error {
+	var ticket Ticket
+	return b.update(ticketsBucket, id, &ticket, func() {
+		ticket.Status = status
+	})
+}
+
+func (b *Bolt) MarkTicketRead(ctx context.Context, id string) error {
+	var ticket Ticket
+	return b.update(ticketsBucket, id, &ticket, func() {
+		ticket.IsRead = 1
+	})
+}
+
+func (b *Bolt) DeleteTicket(ctx context.Context, id string) error {
+	return b.delete(ticketsBucket, id)
+}
+
+func (b *Bolt) InsertTicketMessage(ctx context.Context, message *TicketMessage) (string, error) {
+	message.Id = newID()
+	return message.Id, b.put(ticketMessagesBucket, message.Id, message)
+}
+
+func (b *Bolt) ListTicketMessages(ctx context.Context, ticketId string) ([]TicketMessage, error) {
+	var messages []TicketMessage
+	err := b.each(ticketMessagesBucket, false, func(data []byte) error {
+		var message TicketMessage
+		if err := json.Unmarshal(data, &message); err != nil {
+			return err
+		}
+		if message.TicketId == ticketId {
+			messages = append(messages, message)
+		}
+		return nil
+	})
+	return messages, err
+}
+
+func (b *Bolt) InsertCredential(ctx context.Context, credential *SmtpCredential) (string, error) {
+	credential.Id = newID()
+	return credential.Id, b.put(credentialsBucket, credential.Id, credential)
+}
+
+func (b *Bolt) FindCredentialByUsername(ctx context.Context, username string) (*SmtpCredential, error) {
+	credentials, err := b.ListCredentials(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for i := range credentials {
+		if credentials[i].Username == username {
+			return &credentials[i], nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (b *Bolt) ListCredentials(ctx context.Context) ([]SmtpCredential, error) {
+	var credentials []SmtpCredential
+	err := b.each(credentialsBucket, false, func(data []byte) error {
+		var credential SmtpCredential
+		if err := json.Unmarshal(data, &credential); err != nil {
+			return err
+		}
+		credentials = append(credentials, credential)
+		return nil
+	})
+	return credentials, err
+}
+
+func (b *Bolt) DeleteCredential(ctx context.Context, id string) error {
+	return b.delete(credentialsBucket, id)
+}
+
+func (b *Bolt) InsertProject(ctx context.Context, project *Project) (string, error) {
+	project.Id = newID()
+	return project.Id, b.put(projectsBucket, project.Id, project)
+}
+
+func (b *Bolt) FindProject(ctx context.Context, id string) (*Project, error) {
+	var project Project
+	if err := b.get(projectsBucket, id, &project); err != nil {
+		return nil, err
+	}
+	return &project, nil
+}
+
+func (b *Bolt) FindProjectByInbox(ctx context.Context, inbox string) (*Project, error) {
+	projects, err := b.ListProjects(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	for i := range projects {
+		if projects[i].Inbox == inbox {
+			return &projects[i], nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (b *Bolt) ListProjects(ctx context.Context, username string) ([]Project, error) {
+	var projects []Project
+	err := b.each(projectsBucket, false, func(data []byte) error {
+		var project Project
+		if err := json.Unmarshal(data, &project); err != nil {
+			return err
+		}
+		if _, ok := project.Member(username); ok || username == "" {
+			projects = append(projects, project)
+		}
+		return nil
+	})
+	return projects, err
+}
+
+func (b *Bolt) UpdateProject(ctx context.Context, project *Project) error {
+	var stored Project
+	return b.update(projectsBucket, project.Id, &stored, func() {
+		stored.Name = project.Name
+		stored.Members = project.Members
+	})
+}
+
+func (b *Bolt) DeleteProject(ctx context.Context, id string) error {
+	return b.delete(projectsBucket, id)
+}
+
+func (b *Bolt) InsertRole(ctx context.Context, role *Role) (string, error) {
+	role.Id = newID()
+	return role.Id, b.put(rolesBucket, role.Id, role)
+}
+
+func (b *Bolt) FindRole(ctx context.Context, id string) (*Role, error) {
+	var role Role
+	if err := b.get(rolesBucket, id, &role); err != nil {
+		return nil, err
+	}
+	return &role, nil
+}
+
+func (b *Bolt) FindRoleByName(ctx context.Context, name string) (*Role, error) {
+	roles, err := b.ListRoles(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for i := range roles {
+		if roles[i].Name == name {
+			return &roles[i], nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (b *Bolt) ListRoles(ctx context.Context) ([]Role, error) {
+	var roles []Role
+	err := b.each(rolesBucket, false, func(data []byte) error {
+		var role Role
+		if err := json.Unmarshal(data, &role); err != nil {
+			return err
+		}
+		roles = append(roles, role)
+		return nil
+	})
+	return roles, err
+}
+
+func (b *Bolt) UpdateRole(ctx context.Context, role *Role) error {
+	var stored Role
+	return b.update(rolesBucket, role.Id, &stored, func() {
+		stored.Permissions = role.Permissions
+	})
+}
+
+func (b *Bolt) DeleteRole(ctx context.Context, id string) error {
+	return b.delete(rolesBucket, id)
+}
+
+func (b *Bolt) InsertQuarantined(ctx context.Context, mail *QuarantinedMail) (string, error) {
+	mail.Id = newID()
+	return mail.Id, b.put(quarantineBucket, mail.Id, mail)
+}
+
+func (b *Bolt) FindQuarantined(ctx context.Context, id string) (*QuarantinedMail, error) {
+	var mail QuarantinedMail
+	if err := b.get(quarantineBucket, id, &mail); err != nil {
+		return nil, err
+	}
+	return &mail, nil
+}
+
+func (b *Bolt) ListQuarantined(ctx context.Context) ([]QuarantinedMail, error) {
+	var mails []QuarantinedMail
+	err := b.each(quarantineBucket, true, func(data []byte) error {
+		var mail QuarantinedMail
+		if err := json.Unmarshal(data, &mail); err != nil {
+			return err
+		}
+		mail.Raw = nil
+		mails = append(mails, mail)
+		return nil
+	})
+	return mails, err
+}
+
+func (b *Bolt) DeleteQuarantined(ctx context.Context, id string) error {
+	return b.delete(quarantineBucket, id)
+}
+
+func (b *Bolt) InsertRefreshToken(ctx context.Context, token *RefreshToken) (string, error) {
+	token.Id = newID()
+	return token.Id, b.put(refreshTokensBucket, token.Id, token)
+}
+
+func (b *Bolt) FindRefreshToken(ctx context.Context, hash string) (*RefreshToken, error) {
+	var found *RefreshToken
+	err := b.each(refreshTokensBucket, false, func(data []byte) error {
+		var token RefreshToken
+		if err := json.Unmarshal(data, &token); err != nil {
+			return err
+		}
+		if found == nil && token.Hash == hash {
+			found = &token
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if found == nil {
+		return nil, ErrNotFound
+	}
+	return found, nil
+}
+
+func (b *Bolt) DeleteRefreshToken(ctx context.Context, id string) error {
+	return b.delete(refreshTokensBucket, id)
+}
+
+func (b *Bolt) TakeRefreshToken(ctx context.Context, hash string) (*RefreshToken, error) {
+	data, err := b.take(refreshTokensBucket, func(data []byte) (bool, error) {
+		var token RefreshToken
+		if err := json.Unmarshal(data, &token); err != nil {
+			return false, err
+		}
+		return token.Hash == hash, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	var token RefreshToken
+	return &token, json.Unmarshal(data, &token)
+}
+
+func (b *Bolt) DeleteUserRefreshTokens(ctx context.Context, userId string) error {
+	return b.deleteWhere(refreshTokensBucket, func(data []byte) (bool, error) {
+		var token RefreshToken
+		if err := json.Unmarshal(data, &token); err != nil {
+			return false, err
+		}
+		return token.UserId == userId, nil
+	})
+}
+
+func (b *Bolt) InsertPasswordReset(ctx context.Context, reset *PasswordReset) (string, error) {
+	reset.Id = newID()
+	return reset.Id, b.put(resetsBucket, reset.Id, reset)
+}
+
+func (b *Bolt) FindPasswordReset(ctx context.Context, hash string) (*PasswordReset, error) {
+	var found *PasswordReset
+	err := b.each(resetsBucket, false, func(data []byte) error {
+		var reset PasswordReset
+		if err := json.Unmarshal(data, &reset); err != nil {
+			return err
+		}
+		if found == nil && reset.Hash == hash {
+			found = &reset
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if found == nil {
+		return nil, ErrNotFound
+	}
+	return found, nil
+}
+
+func (b *Bolt) DeleteUserPasswordResets(ctx context.Context, userId string) error {
+	return b.deleteWhere(resetsBucket, func(data []byte) (bool, error) {
+		var reset PasswordReset
+		if err := json.Unmarshal(data, &reset); err != nil {
+			return false, err
+		}
+		return reset.UserId == userId, nil
+	})
+}
+
+func (b *Bolt) InsertApiKey(ctx context.Context, key *ApiKey) (string, error) {
+	key.Id = newID()
+	return key.Id, b.put(apiKeysBucket, key.Id, key)
+}
+
+func (b *Bolt) FindApiKey(ctx context.Context, id string) (*ApiKey, error) {
+	var key ApiKey
+	if err := b.get(apiKeysBucket, id, &key); err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+func (b *Bolt) FindApiKeyByHash(ctx context.Context, hash string) (*ApiKey, error) {
+	keys, err := b.ListApiKeys(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	for i := range keys {
+		if keys[i].Hash == hash {
+			return &keys[i], nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (b *Bolt) ListApiKeys(ctx context.Context, userId string) ([]ApiKey, error) {
+	var keys []ApiKey
+	err := b.each(apiKeysBucket, false, func(data []byte) error {
+		var key ApiKey
+		if err := json.Unmarshal(data, &key); err != nil {
+			return err
+		}
+		if userId == "" || key.UserId == userId {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	return keys, err
+}
+
+func (b *Bolt) TouchApiKey(ctx context.Context, id string, at time.Time) error {
+	var key ApiKey
+	return b.update(apiKeysBucket, id, &key, func() {
+		key.LastUsedAt = at
+	})
+}
+
+func (b *Bolt) DeleteApiKey(ctx context.Context, id string) error {
+	return b.delete(apiKeysBucket, id)
+}
+
+func (b *Bolt) MigrateTimestamps(ctx context.Context) (int, error) {
+	changed := 0
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, bk *bolt.Bucket) error {
+			// a bucket must not change while it is iterated
+			updates := map[string][]byte{}
+			err := bk.ForEach(func(k, v []byte) error {
+				var doc map[string]json.RawMessage
+				if err := json.Unmarshal(v, &doc); err != nil {
+					return err
+				}
+				var value string
+				if json.Unmarshal(doc["createdat"], &value) != nil {
+					return nil
+				}
+				if _, err := time.Parse(time.RFC3339Nano, value); err == nil {
+					return nil
+				}
+				// empty and unknown text become the zero time
+				created, _ := time.Parse(legacyTimeLayout, value)
+				doc["createdat"], _ = json.Marshal(created.UTC())
+				if string(name) == string(mailsBucket) {
+					var data string
+					json.Unmarshal(doc["data"], &data)
+					doc["date"], _ = json.Marshal(messageDate(data))
+				}
+				updated, err := json.Marshal(doc)
+				if err != nil {
+					return err
+				}
+				updates[string(k)] = updated
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			for k, v := range updates {
+				if err := bk.Put([]byte(k), v); err != nil {
+					return err
+				}
+			}
+			changed += len(updates)
+			return nil
+		})
+	})
+	return changed, err
+}
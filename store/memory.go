@@ -0,0 +1,704 @@
+package store
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Memory keeps everything in process, it is meant for tests and single
+// binary runs where losing the data on restart is fine.
+type Memory struct {
+	mu             sync.RWMutex
+	mails          []Mail
+	users          []User
+	tickets        []Ticket
+	ticketMessages []TicketMessage
+	credentials    []SmtpCredential
+	projects       []Project
+	quarantine     []QuarantinedMail
+	refreshTokens  []RefreshToken
+	resets         []PasswordReset
+	apiKeys        []ApiKey
+	roles          []Role
+}
+
+func NewMemory() *Memory {
+	return &Memory{}
+}
+
+// newID returns ids in the same format and order as Mongo ObjectIDs.
+func newID() string {
+	return primitive.NewObjectID().Hex()
+}
+
+func (m *Memory) Close(ctx context.Context) error {
+	return nil
+}
+
+func (m *Memory) mailIndex(id string) int {
+	for i := range m.mails {
+		if m.mails[i].Id == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func (m *Memory) InsertMail(ctx context.Context, mail *Mail) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	mail.Id = newID()
+	m.mails = append(m.mails, *mail)
+	return mail.Id, nil
+}
+
+func (m *Memory) FindMail(ctx context.Context, id string) (*Mail, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	i := m.mailIndex(id)
+	if i < 0 {
+		return nil, ErrNotFound
+	}
+	mail := m.mails[i]
+	return &mail, nil
+}
+
+func (m *Memory) ListMails(ctx context.Context, filter MailFilter) ([]Mail, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var mails []Mail
+	for i := len(m.mails) - 1; i >= 0; i-- {
+		if filter.matches(&m.mails[i]) {
+			mails = append(mails, summary(m.mails[i]))
+		}
+	}
+	return mails, nil
+}
+
+func (m *Memory) PageMails(ctx context.Context, filter MailFilter, page Page) (*MailPage, error) {
+	mails, err := m.ListMails(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	return pageMails(mails, page), nil
+}
+
+func (m *Memory) MailAttachments(ctx context.Context, filter MailFilter) ([]Attachment, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var attachments []Attachment
+	for i := range m.mails {
+		if filter.matches(&m.mails[i]) {
+			attachments = append(attachments, m.mails[i].Attachments...)
+		}
+	}
+	return attachments, nil
+}
+
+func (m *Memory) MarkMailRead(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i := m.mailIndex(id)
+	if i < 0 {
+		return ErrNotFound
+	}
+	m.mails[i].IsRead = 1
+	return nil
+}
+
+func (m *Memory) MarkAllMailsRead(ctx context.Context, filter MailFilter) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range m.mails {
+		if filter.matches(&m.mails[i]) {
+			m.mails[i].IsRead = 1
+		}
+	}
+	return nil
+}
+
+func (m *Memory) DeleteMail(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if i := m.mailIndex(id); i >= 0 {
+		m.mails = append(m.mails[:i], m.mails[i+1:]...)
+	}
+	return nil
+}
+
+func (m *Memory) DeleteAllMails(ctx context.Context, filter MailFilter) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var kept []Mail
+	for i := range m.mails {
+		if !filter.matches(&m.mails[i]) {
+			kept = append(kept, m.mails[i])
+		}
+	}
+	m.mails = kept
+	return nil
+}
+
+func (m *Memory) userIndex(match func(u *User) bool) int {
+	for i := range m.users {
+		if match(&m.users[i]) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (m *Memory) findUser(match func(u *User) bool) (*User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	i := m.userIndex(match)
+	if i < 0 {
+		return nil, ErrNotFound
+	}
+	user := m.users[i]
+	return &user, nil
+}
+
+func (m *Memory) InsertUser(ctx context.Context, user *User) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	user.Id = newID()
+	m.users = append(m.users, *user)
+	return user.Id, nil
+}
+
+func (m *Memory) FindUser(ctx context.Context, id string) (*User, error) {
+	return m.findUser(func(u *User) bool { return u.Id == id })
+}
+
+func (m *Memory) FindUserByUsername(ctx context.Context, username string) (*User, error) {
+	return m.findUser(func(u *User) bool { return u.Username == username })
+}
+
+func (m *Memory) FindUserBySalt(ctx context.Context, salt string) (*User, error) {
+	return m.findUser(func(u *User) bool { return u.Salt == salt })
+}
+
+func (m *Memory) FindUserBySubject(ctx context.Context, subject string) (*User, error) {
+	return m.findUser(func(u *User) bool { return u.Subject == subject })
+}
+
+func (m *Memory) ListUsers(ctx context.Context) ([]User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return append([]User(nil), m.users...), nil
+}
+
+func (m *Memory) UpdateUser(ctx context.Context, user *User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i := m.userIndex(func(u *User) bool { return u.Id == user.Id })
+	if i < 0 {
+		return ErrNotFound
+	}
+	m.users[i].Username = user.Username
+	m.users[i].Emails = user.Emails
+	m.users[i].Role = user.Role
+	return nil
+}
+
+func (m *Memory) UpdateUserPassword(ctx context.Context, id, password string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i := m.userIndex(func(u *User) bool { return u.Id == id })
+	if i < 0 {
+		return ErrNotFound
+	}
+	m.users[i].Password = password
+	return nil
+}
+
+func (m *Memory) UpdateUserWebhooks(ctx context.Context, id string, webhooks []Webhook) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i := m.userIndex(func(u *User) bool { return u.Id == id })
+	if i < 0 {
+		return ErrNotFound
+	}
+	m.users[i].Webhooks = webhooks
+	return nil
+}
+
+func (m *Memory) UpdateUserSalt(ctx context.Context, id, salt string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i := m.userIndex(func(u *User) bool { return u.Id == id })
+	if i < 0 {
+		return ErrNotFound
+	}
+	m.users[i].Salt = salt
+	return nil
+}
+
+func (m *Memory) UpdateUserSubject(ctx context.Context, id, subject string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i := m.userIndex(func(u *User) bool { return u.Id == id })
+	if i < 0 {
+		return ErrNotFound
+	}
+	m.users[i].Subject = subject
+	return nil
+}
+
+func (m *Memory) DeleteUser(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if i := m.userIndex(func(u *User) bool { return u.Id == id }); i >= 0 {
+		m.users = append(m.users[:i], m.users[i+1:]...)
+	}
+	return nil
+}
+
+func (m *Memory) ticketIndex(id string) int {
+	for i := range m.tickets {
+		if m.tickets[i].Id == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func (m *Memory) InsertTicket(ctx context.Context, ticket *Ticket) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ticket.Id = newID()
+	m.tickets = append(m.tickets, *ticket)
+	return ticket.Id, nil
+}
+
+func (m *Memory) FindTicket(ctx context.Context, id, username string) (*Ticket, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	i := m.ticketIndex(id)
+	if i < 0 || (username != "" && m.tickets[i].Username != username) {
+		return nil, ErrNotFound
+	}
+	ticket := m.tickets[i]
+	return &ticket, nil
+}
+
+func (m *Memory) ListTickets(ctx context.Context, username string) ([]Ticket, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var tickets []Ticket
+	for i := len(m.tickets) - 1; i >= 0; i-- {
+		if username == "" || m.tickets[i].Username == username {
+			tickets = append(tickets, m.tickets[i])
+		}
+	}
+	return tickets, nil
+}
+
+func (m *Memory) SetTicketStatus(ctx context.Context, id, status string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i := m.ticketIndex(id)
+	if i < 0 {
+		return ErrNotFound
+	}
+	m.tickets[i].Status = status
+	return nil
+}
+
+func (m *Memory) MarkTicketRead(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i := m.ticketIndex(id)
+	if i < 0 {
+		return ErrNotFound
+	}
+	m.tickets[i].IsRead = 1
+	return nil
+}
+
+func (m *Memory) DeleteTicket(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if i := m.ticketIndex(id); i >= 0 {
+		m.tickets = append(m.tickets[:i], m.tickets[i+1:]...)
+	}
+	return nil
+}
+
+func (m *Memory) InsertTicketMessage(ctx context.Context, message *TicketMessage) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	message.Id = newID()
+	m.ticketMessages = append(m.ticketMessages, *message)
+	return message.Id, nil
+}
+
+func (m *Memory) ListTicketMessages(ctx context.Context, ticketId string) ([]TicketMessage, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var messages []TicketMessage
+	for _, message := range m.ticketMessages {
+		if message.TicketId == ticketId {
+			messages = append(messages, message)
+		}
+	}
+	return messages, nil
+}
+
+func (m *Memory) InsertCredential(ctx context.Context, credential *SmtpCredential) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	credential.Id = newID()
+	m.credentials = append(m.credentials, *credential)
+	return credential.Id, nil
+}
+
+func (m *Memory) FindCredentialByUsername(ctx context.Context, username string) (*SmtpCredential, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, credential := range m.credentials {
+		if credential.Username == username {
+			return &credential, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (m *Memory) ListCredentials(ctx context.Context) ([]SmtpCredential, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return append([]SmtpCredential(nil), m.credentials...), nil
+}
+
+func (m *Memory) DeleteCredential(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range m.credentials {
+		if m.credentials[i].Id == id {
+			m.credentials = append(m.credentials[:i], m.credentials[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (m *Memory) InsertProject(ctx context.Context, project *Project) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	project.Id = newID()
+	m.projects = append(m.projects, *project)
+	return project.Id, nil
+}
+
+func (m *Memory) findProject(match func(p *Project) bool) (*Project, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for i := range m.projects {
+		if match(&m.projects[i]) {
+			project := m.projects[i]
+			return &project, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (m *Memory) FindProject(ctx context.Context, id string) (*Project, error) {
+	return m.findProject(func(p *Project) bool { return p.Id == id })
+}
+
+func (m *Memory) FindProjectByInbox(ctx context.Context, inbox string) (*Project, error) {
+	return m.findProject(func(p *Project) bool { return p.Inbox == inbox })
+}
+
+func (m *Memory) ListProjects(ctx context.Context, username string) ([]Project, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var projects []Project
+	for _, project := range m.projects {
+		if _, ok := project.Member(username); ok || username == "" {
+			projects = append(projects, project)
+		}
+	}
+	return projects, nil
+}
+
+func (m *Memory) UpdateProject(ctx context.Context, project *Project) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range m.projects {
+		if m.projects[i].Id == project.Id {
+			m.projects[i].Name = project.Name
+			m.projects[i].Members = project.Members
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (m *Memory) DeleteProject(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range m.projects {
+		if m.projects[i].Id == id {
+			m.projects = append(m.projects[:i], m.projects[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (m *Memory) InsertRole(ctx context.Context, role *Role) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	role.Id = newID()
+	m.roles = append(m.roles, *role)
+	return role.Id, nil
+}
+
+func (m *Memory) findRole(match func(r *Role) bool) (*Role, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for i := range m.roles {
+		if match(&m.roles[i]) {
+			role := m.roles[i]
+			return &role, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (m *Memory) FindRole(ctx context.Context, id string) (*Role, error) {
+	return m.findRole(func(r *Role) bool { return r.Id == id })
+}
+
+func (m *Memory) FindRoleByName(ctx context.Context, name string) (*Role, error) {
+	return m.findRole(func(r *Role) bool { return r.Name == name })
+}
+
+func (m *Memory) ListRoles(ctx context.Context) ([]Role, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return append([]Role(nil), m.roles...), nil
+}
+
+func (m *Memory) UpdateRole(ctx context.Context, role *Role) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range m.roles {
+		if m.roles[i].Id == role.Id {
+			m.roles[i].Permissions = role.Permissions
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (m *Memory) DeleteRole(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range m.roles {
+		if m.roles[i].Id == id {
+			m.roles = append(m.roles[:i], m.roles[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (m *Memory) InsertQuarantined(ctx context.Context, mail *QuarantinedMail) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	mail.Id = newID()
+	m.quarantine = append(m.quarantine, *mail)
+	return mail.Id, nil
+}
+
+func (m *Memory) FindQuarantined(ctx context.Context, id string) (*QuarantinedMail, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, mail := range m.quarantine {
+		if mail.Id == id {
+			return &mail, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (m *Memory) ListQuarantined(ctx context.Context) ([]QuarantinedMail, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var mails []QuarantinedMail
+	for i := len(m.quarantine) - 1; i >= 0; i-- {
+		mail := m.quarantine[i]
+		mail.Raw = nil
+		mails = append(mails, mail)
+	}
+	return mails, nil
+}
+
+func (m *Memory) DeleteQuarantined(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range m.quarantine {
+		if m.quarantine[i].Id == id {
+			m.quarantine = append(m.quarantine[:i], m.quarantine[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (m *Memory) InsertRefreshToken(ctx context.Context, token *RefreshToken) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	token.Id = newID()
+	m.refreshTokens = append(m.refreshTokens, *token)
+	return token.Id, nil
+}
+
+func (m *Memory) FindRefreshToken(ctx context.Context, hash string) (*RefreshToken, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, token := range m.refreshTokens {
+		if token.Hash == hash {
+			return &token, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (m *Memory) DeleteRefreshToken(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range m.refreshTokens {
+		if m.refreshTokens[i].Id == id {
+			m.refreshTokens = append(m.refreshTokens[:i], m.refreshTokens[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (m *Memory) TakeRefreshToken(ctx context.Context, hash string) (*RefreshToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, token := range m.refreshTokens {
+		if token.Hash == hash {
+			m.refreshTokens = append(m.refreshTokens[:i], m.refreshTokens[i+1:]...)
+			return &token, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (m *Memory) DeleteUserRefreshTokens(ctx context.Context, userId string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	kept := m.refreshTokens[:0]
+	for _, token := range m.refreshTokens {
+		if token.UserId != userId {
+			kept = append(kept, token)
+		}
+	}
+	m.refreshTokens = kept
+	return nil
+}
+
+func (m *Memory) InsertPasswordReset(ctx context.Context, reset *PasswordReset) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	reset.Id = newID()
+	m.resets = append(m.resets, *reset)
+	return reset.Id, nil
+}
+
+func (m *Memory) FindPasswordReset(ctx context.Context, hash string) (*PasswordReset, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, reset := range m.resets {
+		if reset.Hash == hash {
+			return &reset, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (m *Memory) DeleteUserPasswordResets(ctx context.Context, userId string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	kept := m.resets[:0]
+	for _, reset := range m.resets {
+		if reset.UserId != userId {
+			kept = append(kept, reset)
+		}
+	}
+	m.resets = kept
+	return nil
+}
+
+func (m *Memory) InsertApiKey(ctx context.Context, key *ApiKey) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key.Id = newID()
+	m.apiKeys = append(m.apiKeys, *key)
+	return key.Id, nil
+}
+
+func (m *Memory) findApiKey(match func(k *ApiKey) bool) (*ApiKey, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, key := range m.apiKeys {
+		if match(&key) {
+			return &key, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (m *Memory) FindApiKey(ctx context.Context, id string) (*ApiKey, error) {
+	return m.findApiKey(func(k *ApiKey) bool { return k.Id == id })
+}
+
+func (m *Memory) FindApiKeyByHash(ctx context.Context, hash string) (*ApiKey, error) {
+	return m.findApiKey(func(k *ApiKey) bool { return k.Hash == hash })
+}
+
+func (m *Memory) ListApiKeys(ctx context.Context, userId string) ([]ApiKey, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var keys []ApiKey
+	for _, key := range m.apiKeys {
+		if userId == "" || key.UserId == userId {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+func (m *Memory) TouchApiKey(ctx context.Context, id string, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range m.apiKeys {
+		if m.apiKeys[i].Id == id {
+			m.apiKeys[i].LastUsedAt = at
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (m *Memory) DeleteApiKey(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range m.apiKeys {
+		if m.apiKeys[i].Id == id {
+			m.apiKeys = append(m.apiKeys[:i], m.apiKeys[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
@@ -0,0 +1,890 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type Mongo struct {
+	client *mongo.Client
+	db     *mongo.Database
+}
+
+// Connect opens a Mongo connection and checks it with a ping.
+func Connect(ctx context.Context, uri, database string) (*Mongo, error) {
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		return nil, err
+	}
+	// Check the connection
+	err = client.Ping(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("Connected to MongoDB!")
+
+	m := NewMongo(client, database)
+	if err := m.createIndexes(ctx); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// createIndexes adds the text index the full text search of mails needs
+// and an index for their date ranges.
+func (m *Mongo) createIndexes(ctx context.Context) error {
+	_, err := m.mails().Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{
+			{Key: "subject", Value: "text"},
+			{Key: "text", Value: "text"},
+			{Key: "html", Value: "text"},
+		}},
+		{Keys: bson.D{{Key: "createdat", Value: -1}}},
+	})
+	return err
+}
+
+func NewMongo(client *mongo.Client, database string) *Mongo {
+	return &Mongo{
+		client: client,
+		db:     client.Database(database),
+	}
+}
+
+// Database exposes the underlying database for GridFS attachments.
+func (m *Mongo) Database() *mongo.Database {
+	return m.db
+}
+
+func (m *Mongo) Close(ctx context.Context) error {
+	return m.client.Disconnect(ctx)
+}
+
+func (m *Mongo) mails() *mongo.Collection {
+	return m.db.Collection("mails")
+}
+
+func (m *Mongo) users() *mongo.Collection {
+	return m.db.Collection("users")
+}
+
+func (m *Mongo) tickets() *mongo.Collection {
+	return m.db.Collection("supports")
+}
+
+func (m *Mongo) ticketMessages() *mongo.Collection {
+	return m.db.Collection("support_messages")
+}
+
+func (m *Mongo) credentials() *mongo.Collection {
+	return m.db.Collection("smtp_credentials")
+}
+
+func (m *Mongo) projects() *mongo.Collection {
+	return m.db.Collection("projects")
+}
+
+func (m *Mongo) quarantine() *mongo.Collection {
+	return m.db.Collection("quarantine")
+}
+
+func (m *Mongo) refreshTokens() *mongo.Collection {
+	return m.db.Collection("refresh_tokens")
+}
+
+func (m *Mongo) resets() *mongo.Collection {
+	return m.db.Collection("password_resets")
+}
+
+func (m *Mongo) roles() *mongo.Collection {
+	return m.db.Collection("roles")
+}
+
+func (m *Mongo) apiKeys() *mongo.Collection {
+	return m.db.Collection("api_keys")
+}
+
+// objectID converts a hex id, unknown ids match no document.
+func objectID(id string) primitive.ObjectID {
+	objID, _ := primitive.ObjectIDFromHex(id)
+	return objID
+}
+
+// insert stores doc and returns the hex id Mongo assigned to it.
+func insert(ctx context.Context, collection *mongo.Collection, doc interface{}) (string, error) {
+	res, err := collection.InsertOne(ctx, doc)
+	if err != nil {
+		return "", err
+	}
+	return res.InsertedID.(primitive.ObjectID).Hex(), nil
+}
+
+// findOne decodes the first match into v and returns its hex id.
+func findOne(ctx context.Context, collection *mongo.Collection, filter interface{}, v interface{}) (string, error) {
+	raw, err := collection.FindOne(ctx, filter).DecodeBytes()
+	if err == mongo.ErrNoDocuments {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	if err := bson.Unmarshal(raw, v); err != nil {
+		return "", err
+	}
+	return raw.Lookup("_id").ObjectID().Hex(), nil
+}
+
+// findAll decodes every match, calling add with the hex id of each one.
+func findAll(ctx context.Context, collection *mongo.Collection, filter interface{}, opts *options.FindOptions, add func(id string, cur *mongo.Cursor) error) error {
+	cur, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return err
+	}
+	defer cur.Close(ctx)
+	for cur.Next(ctx) {
+		if err := add(cur.Current.Lookup("_id").ObjectID().Hex(), cur); err != nil {
+			return err
+		}
+	}
+	return cur.Err()
+}
+
+func updateOne(ctx context.Context, collection *mongo.Collection, id string, set bson.M) error {
+	res, err := collection.UpdateOne(ctx, bson.M{"_id": objectID(id)}, bson.M{"$set": set})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func newestFirst() *options.FindOptions {
+	return options.Find().SetSort(bson.D{{Key: "_id", Value: -1}})
+}
+
+func (m *Mongo) InsertMail(ctx context.Context, mail *Mail) (string, error) {
+	id, err := insert(ctx, m.mails(), mail)
+	if err != nil {
+		return "", err
+	}
+	mail.Id = id
+	return id, nil
+}
+
+func (m *Mongo) FindMail(ctx context.Context, id string) (*Mail, error) {
+	var mail Mail
+	hex, err := findOne(ctx, m.mails(), bson.M{"_id": objectID(id)}, &mail)
+	if err != nil {
+		return nil, err
+	}
+	mail.Id = hex
+	return &mail, nil
+}
+
+func (m *Mongo) MigrateTimestamps(ctx context.Context) (int, error) {
+	changed := 0
+	for _, collection := range []*mongo.Collection{
+		m.mails(), m.users(), m.tickets(), m.ticketMessages(), m.credentials(),
+		m.projects(), m.quarantine(), m.refreshTokens(), m.apiKeys(), m.resets(),
+	} {
+		opts := options.Find().SetProjection(bson.M{"createdat": 1})
+		if collection.Name() == m.mails().Name() {
+			opts.SetProjection(bson.M{"createdat": 1, "data": 1})
+		}
+		cur, err := collection.Find(ctx, bson.M{"createdat": bson.M{"$type": "string"}}, opts)
+		if err != nil {
+			return changed, err
+		}
+		for cur.Next(ctx) {
+			var doc struct {
+				Id        primitive.ObjectID `bson:"_id"`
+				CreatedAt string             `bson:"createdat"`
+				Data      string             `bson:"data"`
+			}
+			if err := cur.Decode(&doc); err != nil {
+				cur.Close(ctx)
+				return changed, err
+			}
+			created, err := time.Parse(legacyTimeLayout, doc.CreatedAt)
+			if err != nil {
+				// empty or unknown text, the id holds the insert time
+				created = doc.Id.Timestamp()
+			}
+			set := bson.M{"createdat": created.UTC()}
+			if collection.Name() == m.mails().Name() {
+				set["date"] = messageDate(doc.Data)
+			}
+			if _, err := collection.UpdateOne(ctx, bson.M{"_id": doc.Id}, bson.M{"$set": set}); err != nil {
+				cur.Close(ctx)
+				return changed, err
+			}
+			changed++
+		}
+		err = cur.Err()
+		cur.Close(ctx)
+		if err != nil {
+			return changed, err
+		}
+	}
+	return changed, nil
+}
+
+// mailSummary leaves the raw data and the bodies out of listed mails.
+var mailSummary = bson.M{
+	"data":  0,
+	"body":  0,
+	"text":  0,
+	"html":  0,
+	"parts": 0,
+}
+
+// mailQuery translates a filter into a Mongo query. The conditions are
+// joined with $and so no field overwrites another.
+func mailQuery(filter MailFilter) bson.M {
+	var and bson.A
+	contains := func(field, value string) {
+		if value != "" {
+			and = append(and, bson.M{field: bson.M{"$regex": regexp.QuoteMeta(value), "$options": "i"}})
+		}
+	}
+	contains("subject", filter.Subject)
+	contains("from", filter.From)
+	contains("to", filter.To)
+	contains("rcpt", filter.Rcpt)
+	contains("cc", filter.Cc)
+	if filter.Text != "" {
+		and = append(and, bson.M{"$text": bson.M{"$search": filter.Text}})
+	}
+	if !filter.After.IsZero() {
+		and = append(and, bson.M{"createdat": bson.M{"$gte": filter.After}})
+	}
+	if !filter.Before.IsZero() {
+		and = append(and, bson.M{"createdat": bson.M{"$lt": filter.Before}})
+	}
+	if filter.Read != nil {
+		if *filter.Read {
+			and = append(and, bson.M{"isread": 1})
+		} else {
+			and = append(and, bson.M{"isread": bson.M{"$ne": 1}})
+		}
+	}
+	if filter.HasAttachments != nil {
+		and = append(and, bson.M{"attachments.0": bson.M{"$exists": *filter.HasAttachments}})
+	}
+	if filter.Inbox != "" {
+		and = append(and, bson.M{"inbox": filter.Inbox})
+	}
+	if filter.Inboxes != nil {
+		and = append(and, bson.M{"inbox": bson.M{"$in": filter.Inboxes}})
+	}
+	if filter.Owner != "" {
+		and = append(and, bson.M{"$or": bson.A{
+			bson.M{"inbox": bson.M{"$not": primitive.Regex{Pattern: "^" + regexp.QuoteMeta(UserInboxPrefix)}}},
+			bson.M{"inbox": UserInbox(filter.Owner)},
+		}})
+	}
+	if len(and) == 0 {
+		return bson.M{}
+	}
+	return bson.M{"$and": and}
+}
+
+func (m *Mongo) ListMails(ctx context.Context, filter MailFilter) ([]Mail, error) {
+	payload := mailQuery(filter)
+	opts := newestFirst().SetProjection(mailSummary)
+
+	var mails []Mail
+	err := findAll(ctx, m.mails(), payload, opts, func(id string, cur *mongo.Cursor) error {
+		var mail Mail
+		if err := cur.Decode(&mail); err != nil {
+			return err
+		}
+		mail.Id = id
+		mails = append(mails, mail)
+		return nil
+	})
+	return mails, err
+}
+
+func (m *Mongo) PageMails(ctx context.Context, filter MailFilter, page Page) (*MailPage, error) {
+	query := mailQuery(filter)
+	total, err := m.mails().CountDocuments(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	unread, err := m.mails().CountDocuments(ctx, bson.M{"$and": bson.A{query, bson.M{"isread": bson.M{"$ne": 1}}}})
+	if err != nil {
+		return nil, err
+	}
+
+	direction := 1
+	if page.Desc {
+		direction = -1
+	}
+	// ids grow with the insertion time and are indexed
+	order := bson.D{{Key: "_id", Value: direction}}
+	opts := options.Find().SetSkip(int64(page.Offset)).SetProjection(mailSummary)
+	if page.Sort != "" && page.Sort != MailSortCreatedAt {
+		order = append(bson.D{{Key: page.Sort, Value: direction}}, order...)
+		opts.SetCollation(&options.Collation{Locale: "en", Strength: 2})
+	}
+	opts.SetSort(order)
+	if page.Limit > 0 {
+		opts.SetLimit(int64(page.Limit))
+	}
+
+	result := &MailPage{Total: int(total), Unread: int(unread)}
+	err = findAll(ctx, m.mails(), query, opts, func(id string, cur *mongo.Cursor) error {
+		var mail Mail
+		if err := cur.Decode(&mail); err != nil {
+			return err
+		}
+		mail.Id = id
+		result.Mails = append(result.Mails, mail)
+		return nil
+	})
+	return result, err
+}
+
+func (m *Mongo) MailAttachments(ctx context.Context, filter MailFilter) ([]Attachment, error) {
+	query := mailQuery(filter)
+	query["attachments.0"] = bson.M{"$exists": true}
+	opts := options.Find().SetProjection(bson.M{"attachments": 1})
+	var attachments []Attachment
+	err := findAll(ctx, m.mails(), query, opts, func(id string, cur *mongo.Cursor) error {
+		var mail Mail
+		if err := cur.Decode(&mail); err != nil {
+			return err
+		}
+		attachments = append(attachments, mail.Attachments...)
+		return nil
+	})
+	return attachments, err
+}
+
+func (m *Mongo) MarkMailRead(ctx context.Context, id string) error {
+	return updateOne(ctx, m.mails(), id, bson.M{"isread": 1})
+}
+
+func (m *Mongo) MarkAllMailsRead(ctx context.Context, filter MailFilter) error {
+	_, err := m.mails().UpdateMany(ctx, mailQuery(filter), bson.M{"$set": bson.M{"isread": 1}})
+	return err
+}
+
+func (m *Mongo) DeleteMail(ctx context.Context, id string) error {
+	_, err := m.mails().DeleteOne(ctx, bson.M{"_id": objectID(id)})
+	return err
+}
+
+func (m *Mongo) DeleteAllMails(ctx context.Context, filter MailFilter) error {
+	_, err := m.mails().DeleteMany(ctx, mailQuery(filter))
+	return err
+}
+
+// WatchMails follows a change stream on the mails collection, Mongo has to
+// run as a replica set for it.
+func (m *Mongo) WatchMails(ctx context.Context) (<-chan Mail, error) {
+	pipeline := mongo.Pipeline{bson.D{{Key: "$match", Value: bson.M{"operationType": "insert"}}}}
+	stream, err := m.mails().Watch(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+
+	ch := make(chan Mail)
+	go func() {
+		defer close(ch)
+		defer stream.Close(context.Background())
+		for stream.Next(ctx) {
+			var event struct {
+				FullDocument bson.Raw `bson:"fullDocument"`
+			}
+			if err := stream.Decode(&event); err != nil {
+				continue
+			}
+			var mail Mail
+			if err := bson.Unmarshal(event.FullDocument, &mail); err != nil {
+				continue
+			}
+			mail.Id = event.FullDocument.Lookup("_id").ObjectID().Hex()
+			select {
+			case ch <- mail:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
+
+func (m *Mongo) InsertUser(ctx context.Context, user *User) (string, error) {
+	id, err := insert(ctx, m.users(), user)
+	if err != nil {
+		return "", err
+	}
+	user.Id = id
+	return id, nil
+}
+
+func (m *Mongo) findUser(ctx context.Context, filter bson.M) (*User, error) {
+	var user User
+	id, err := findOne(ctx, m.users(), filter, &user)
+	if err != nil {
+		return nil, err
+	}
+	user.Id = id
+	return &user, nil
+}
+
+func (m *Mongo) FindUser(ctx context.Context, id string) (*User, error) {
+	return m.findUser(ctx, bson.M{"_id": objectID(id)})
+}
+
+func (m *Mongo) FindUserByUsername(ctx context.Context, username string) (*User, error) {
+	return m.findUser(ctx, bson.M{"username": username})
+}
+
+func (m *Mongo) FindUserBySalt(ctx context.Context, salt string) (*User, error) {
+	return m.findUser(ctx, bson.M{"salt": salt})
+}
+
+func (m *Mongo) FindUserBySubject(ctx context.Context, subject string) (*User, error) {
+	return m.findUser(ctx, bson.M{"subject": subject})
+}
+
+func (m *Mongo) ListUsers(ctx context.Context) ([]User, error) {
+	var users []User
+	err := findAll(ctx, m.users(), bson.M{}, options.Find(), func(id string, cur *mongo.Cursor) error {
+		var user User
+		if err := cur.Decode(&user); err != nil {
+			return err
+		}
+		user.Id = id
+		users = append(users, user)
+		return nil
+	})
+	return users, err
+}
+
+func (m *Mongo) UpdateUser(ctx context.Context, user *User) error {
+	return updateOne(ctx, m.users(), user.Id, bson.M{
+		"username": user.Username,
+		"emails":   user.Emails,
+		"role":     user.Role,
+	})
+}
+
+func (m *Mongo) UpdateUserPassword(ctx context.Context, id, password string) error {
+	return updateOne(ctx, m.users(), id, bson.M{"password": password})
+}
+
+func (m *Mongo) UpdateUserWebhooks(ctx context.Context, id string, webhooks []Webhook) error {
+	return updateOne(ctx, m.users(), id, bson.M{"webhooks": webhooks})
+}
+
+func (m *Mongo) UpdateUserSalt(ctx context.Context, id, salt string) error {
+	return updateOne(ctx, m.users(), id, bson.M{"salt": salt})
+}
+
+func (m *Mongo) UpdateUserSubject(ctx context.Context, id, subject string) error {
+	return updateOne(ctx, m.users(), id, bson.M{"subject": subject})
+}
+
+func (m *Mongo) DeleteUser(ctx context.Context, id string) error {
+	_, err := m.users().DeleteOne(ctx, bson.M{"_id": objectID(id)})
+	return err
+}
+
+func (m *Mongo) InsertTicket(ctx context.Context, ticket *Ticket) (string, error) {
+	id, err := insert(ctx, m.tickets(), ticket)
+	if err != nil {
+		return "", err
+	}
+	ticket.Id = id
+	return id, nil
+}
+
+func (m *Mongo) FindTicket(ctx context.Context, id, username string) (*Ticket, error) {
+	filter := bson.M{"_id": objectID(id)}
+	if username != "" {
+		filter["username"] = username
+	}
+	var ticket Ticket
+	hex, err := findOne(ctx, m.tickets(), filter, &ticket)
+	if err != nil {
+		return nil, err
+	}
+	ticket.Id = hex
+	return &ticket, nil
+}
+
+func (m *Mongo) ListTickets(ctx context.Context, username string) ([]Ticket, error) {
+	filter := bson.M{}
+	if username != "" {
+		filter["username"] = username
+	}
+	var tickets []Ticket
+	err := findAll(ctx, m.tickets(), filter, newestFirst(), func(id string, cur *mongo.Cursor) error {
+		var ticket Ticket
+		if err := cur.Decode(&ticket); err != nil {
+			return err
+		}
+		ticket.Id = id
+		tickets = append(tickets, ticket)
+		return nil
+	})
+	return tickets, err
+}
+
+func (m *Mongo) SetTicketStatus(ctx context.Context, id, status string) error {
+	return updateOne(ctx, m.tickets(), id, bson.M{"status": status})
+}
+
+func (m *Mongo) MarkTicketRead(ctx context.Context, id string) error {
+	return updateOne(ctx, m.tickets(), id, bson.M{"isread": 1})
+}
+
+func (m *Mongo) DeleteTicket(ctx context.Context, id string) error {
+	_, err := m.tickets().DeleteOne(ctx, bson.M{"_id": objectID(id)})
+	return err
+}
+
+func (m *Mongo) InsertTicketMessage(ctx context.Context, message *TicketMessage) (string, error) {
+	id, err := insert(ctx, m.ticketMessages(), message)
+	if err != nil {
+		return "", err
+	}
+	message.Id = id
+	return id, nil
+}
+
+func (m *Mongo) ListTicketMessages(ctx context.Context, ticketId string) ([]TicketMessage, error) {
+	var messages []TicketMessage
+	err := findAll(ctx, m.ticketMessages(), bson.M{"ticketid": ticketId}, options.Find(), func(id string, cur *mongo.Cursor) error {
+		var message TicketMessage
+		if err := cur.Decode(&message); err != nil {
+			return err
+		}
+		message.Id = id
+		messages = append(messages, message)
+		return nil
+	})
+	return messages, err
+}
+
+func (m *Mongo) InsertCredential(ctx context.Context, credential *SmtpCredential) (string, error) {
+	id, err := insert(ctx, m.credentials(), credential)
+	if err != nil {
+		return "", err
+	}
+	credential.Id = id
+	return id, nil
+}
+
+func (m *Mongo) FindCredentialByUsername(ctx context.Context, username string) (*SmtpCredential, error) {
+	var credential SmtpCredential
+	id, err := findOne(ctx, m.credentials(), bson.M{"username": username}, &credential)
+	if err != nil {
+		return nil, err
+	}
+	credential.Id = id
+	return &credential, nil
+}
+
+func (m *Mongo) ListCredentials(ctx context.Context) ([]SmtpCredential, error) {
+	var credentials []SmtpCredential
+	err := findAll(ctx, m.credentials(), bson.M{}, options.Find(), func(id string, cur *mongo.Cursor) error {
+		var credential SmtpCredential
+		if err := cur.Decode(&credential); err != nil {
+			return err
+		}
+		credential.Id = id
+		credentials = append(credentials, credential)
+		return nil
+	})
+	return credentials, err
+}
+
+func (m *Mongo) DeleteCredential(ctx context.Context, id string) error {
+	_, err := m.credentials().DeleteOne(ctx, bson.M{"_id": objectID(id)})
+	return err
+}
+
+func (m *Mongo) InsertProject(ctx context.Context, project *Project) (string, error) {
+	id, err := insert(ctx, m.projects(), project)
+	if err != nil {
+		return "", err
+	}
+	project.Id = id
+	return id, nil
+}
+
+func (m *Mongo) findProject(ctx context.Context, filter bson.M) (*Project, error) {
+	var project Project
+	id, err := findOne(ctx, m.projects(), filter, &project)
+	if err != nil {
+		return nil, err
+	}
+	project.Id = id
+	return &project, nil
+}
+
+func (m *Mongo) FindProject(ctx context.Context, id string) (*Project, error) {
+	return m.findProject(ctx, bson.M{"_id": objectID(id)})
+}
+
+func (m *Mongo) FindProjectByInbox(ctx context.Context, inbox string) (*Project, error) {
+	return m.findProject(ctx, bson.M{"inbox": inbox})
+}
+
+func (m *Mongo) ListProjects(ctx context.Context, username string) ([]Project, error) {
+	filter := bson.M{}
+	if username != "" {
+		filter["members.username"] = username
+	}
+	var projects []Project
+	err := findAll(ctx, m.projects(), filter, options.Find(), func(id string, cur *mongo.Cursor) error {
+		var project Project
+		if err := cur.Decode(&project); err != nil {
+			return err
+		}
+		project.Id = id
+		projects = append(projects, project)
+		return nil
+	})
+	return projects, err
+}
+
+func (m *Mongo) UpdateProject(ctx context.Context, project *Project) error {
+	return updateOne(ctx, m.projects(), project.Id, bson.M{
+		"name":    project.Name,
+		"members": project.Members,
+	})
+}
+
+func (m *Mongo) DeleteProject(ctx context.Context, id string) error {
+	_, err := m.projects().DeleteOne(ctx, bson.M{"_id": objectID(id)})
+	return err
+}
+
+func (m *Mongo) InsertRole(ctx context.Context, role *Role) (string, error) {
+	id, err := insert(ctx, m.roles(), role)
+	if err != nil {
+		return "", err
+	}
+	role.Id = id
+	return id, nil
+}
+
+func (m *Mongo) findRole(ctx context.Context, filter bson.M) (*Role, error) {
+	var role Role
+	id, err := findOne(ctx, m.roles(), filter, &role)
+	if err != nil {
+		return nil, err
+	}
+	role.Id = id
+	return &role, nil
+}
+
+func (m *Mongo) FindRole(ctx context.Context, id string) (*Role, error) {
+	return m.findRole(ctx, bson.M{"_id": objectID(id)})
+}
+
+func (m *Mongo) FindRoleByName(ctx context.Context, name string) (*Role, error) {
+	return m.findRole(ctx, bson.M{"name": name})
+}
+
+func (m *Mongo) ListRoles(ctx context.Context) ([]Role, error) {
+	var roles []Role
+	err := findAll(ctx, m.roles(), bson.M{}, options.Find(), func(id string, cur *mongo.Cursor) error {
+		var role Role
+		if err := cur.Decode(&role); err != nil {
+			return err
+		}
+		role.Id = id
+		roles = append(roles, role)
+		return nil
+	})
+	return roles, err
+}
+
+func (m *Mongo) UpdateRole(ctx context.Context, role *Role) error {
+	return updateOne(ctx, m.roles(), role.Id, bson.M{
+		"permissions": role.Permissions,
+	})
+}
+
+func (m *Mongo) DeleteRole(ctx context.Context, id string) error {
+	_, err := m.roles().DeleteOne(ctx, bson.M{"_id": objectID(id)})
+	return err
+}
+
+func (m *Mongo) InsertQuarantined(ctx context.Context, mail *QuarantinedMail) (string, error) {
+	id, err := insert(ctx, m.quarantine(), mail)
+	if err != nil {
+		return "", err
+	}
+	mail.Id = id
+	return id, nil
+}
+
+func (m *Mongo) FindQuarantined(ctx context.Context, id string) (*QuarantinedMail, error) {
+	var mail QuarantinedMail
+	hex, err := findOne(ctx, m.quarantine(), bson.M{"_id": objectID(id)}, &mail)
+	if err != nil {
+		return nil, err
+	}
+	mail.Id = hex
+	return &mail, nil
+}
+
+func (m *Mongo) ListQuarantined(ctx context.Context) ([]QuarantinedMail, error) {
+	opts := newestFirst().SetProjection(bson.M{"raw": 0})
+	var mails []QuarantinedMail
+	err := findAll(ctx, m.quarantine(), bson.M{}, opts, func(id string, cur *mongo.Cursor) error {
+		var mail QuarantinedMail
+		if err := cur.Decode(&mail); err != nil {
+			return err
+		}
+		mail.Id = id
+		mails = append(mails, mail)
+		return nil
+	})
+	return mails, err
+}
+
+func (m *Mongo) DeleteQuarantined(ctx context.Context, id string) error {
+	_, err := m.quarantine().DeleteOne(ctx, bson.M{"_id": objectID(id)})
+	return err
+}
+
+func (m *Mongo) InsertRefreshToken(ctx context.Context, token *RefreshToken) (string, error) {
+	id, err := insert(ctx, m.refreshTokens(), token)
+	if err != nil {
+		return "", err
+	}
+	token.Id = id
+	return id, nil
+}
+
+func (m *Mongo) FindRefreshToken(ctx context.Context, hash string) (*RefreshToken, error) {
+	var token RefreshToken
+	id, err := findOne(ctx, m.refreshTokens(), bson.M{"hash": hash}, &token)
+	if err != nil {
+		return nil, err
+	}
+	token.Id = id
+	return &token, nil
+}
+
+func (m *Mongo) DeleteRefreshToken(ctx context.Context, id string) error {
+	_, err := m.refreshTokens().DeleteOne(ctx, bson.M{"_id": objectID(id)})
+	return err
+}
+
+func (m *Mongo) TakeRefreshToken(ctx context.Context, hash string) (*RefreshToken, error) {
+	raw, err := m.refreshTokens().FindOneAndDelete(ctx, bson.M{"hash": hash}).DecodeBytes()
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var token RefreshToken
+	if err := bson.Unmarshal(raw, &token); err != nil {
+		return nil, err
+	}
+	token.Id = raw.Lookup("_id").ObjectID().Hex()
+	return &token, nil
+}
+
+func (m *Mongo) DeleteUserRefreshTokens(ctx context.Context, userId string) error {
+	_, err := m.refreshTokens().DeleteMany(ctx, bson.M{"userid": userId})
+	return err
+}
+
+func (m *Mongo) InsertPasswordReset(ctx context.Context, reset *PasswordReset) (string, error) {
+	id, err := insert(ctx, m.resets(), reset)
+	if err != nil {
+		return "", err
+	}
+	reset.Id = id
+	return id, nil
+}
+
+func (m *Mongo) FindPasswordReset(ctx context.Context, hash string) (*PasswordReset, error) {
+	var reset PasswordReset
+	id, err := findOne(ctx, m.resets(), bson.M{"hash": hash}, &reset)
+	if err != nil {
+		return nil, err
+	}
+	reset.Id = id
+	return &reset, nil
+}
+
+func (m *Mongo) DeleteUserPasswordResets(ctx context.Context, userId string) error {
+	_, err := m.resets().DeleteMany(ctx, bson.M{"userid": userId})
+	return err
+}
+
+func (m *Mongo) InsertApiKey(ctx context.Context, key *ApiKey) (string, error) {
+	id, err := insert(ctx, m.apiKeys(), key)
+	if err != nil {
+		return "", err
+	}
+	key.Id = id
+	return id, nil
+}
+
+func (m *Mongo) findApiKey(ctx context.Context, filter bson.M) (*ApiKey, error) {
+	var key ApiKey
+	id, err := findOne(ctx, m.apiKeys(), filter, &key)
+	if err != nil {
+		return nil, err
+	}
+	key.Id = id
+	return &key, nil
+}
+
+func (m *Mongo) FindApiKey(ctx context.Context, id string) (*ApiKey, error) {
+	return m.findApiKey(ctx, bson.M{"_id": objectID(id)})
+}
+
+func (m *Mongo) FindApiKeyByHash(ctx context.Context, hash string) (*ApiKey, error) {
+	return m.findApiKey(ctx, bson.M{"hash": hash})
+}
+
+func (m *Mongo) ListApiKeys(ctx context.Context, userId string) ([]ApiKey, error) {
+	filter := bson.M{}
+	if userId != "" {
+		filter["userid"] = userId
+	}
+	var keys []ApiKey
+	err := findAll(ctx, m.apiKeys(), filter, options.Find(), func(id string, cur *mongo.Cursor) error {
+		var key ApiKey
+		if err := cur.Decode(&key); err != nil {
+			return err
+		}
+		key.Id = id
+		keys = append(keys, key)
+		return nil
+	})
+	return keys, err
+}
+
+func (m *Mongo) TouchApiKey(ctx context.Context, id string, at time.Time) error {
+	return updateOne(ctx, m.apiKeys(), id, bson.M{"lastusedat": at})
+}
+
+func (m *Mongo) DeleteApiKey(ctx context.Context, id string) error {
+	_, err := m.apiKeys().DeleteOne(ctx, bson.M{"_id": objectID(id)})
+	return err
+}
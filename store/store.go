@@ -0,0 +1,593 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"golang.org/x/net/html"
+	"net/mail"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+var ErrNotFound = errors.New("document not found")
+
+// kinds of stores
+const (
+	KindMongo  = "mongo"
+	KindMemory = "memory"
+	KindBolt   = "bolt"
+)
+
+type Mail struct {
+	Id          string       `json:"id" bson:"-"`
+	Subject     string       `json:"subject"`
+	Data        string       `json:"data"`
+	To          string       `json:"to"`
+	IsRead      int          `json:"isread"`
+	From        string       `json:"from"`
+	Body        string       `json:"body"`
+	Text        string       `json:"text"`
+	Html        string       `json:"html"`
+	Parts       []Part       `json:"parts"`
+	Attachments []Attachment `json:"attachments"`
+	Cc          string       `json:"cc"`
+	Bcc         string       `json:"bcc"`
+	Rcpt        string       `json:"rcpt"`
+	Inbox       string       `json:"inbox"`
+	MimeVersion string       `json:"mimeversion"`
+	ContentType string       `json:"contenttype"`
+	// Date is the Date header of the message, zero when it is missing or
+	// malformed. CreatedAt is when the mail was received.
+	Date      time.Time `json:"date"`
+	CreatedAt time.Time `json:"createdat"`
+}
+
+// UserInboxPrefix starts the inbox of the mails a user sent over smtp, or
+// that MailTracker captured for them. Project inboxes can not contain the
+// colon, so a user is never filed into a project by their name. A user
+// inbox is only read by its user.
+const UserInboxPrefix = "user:"
+
+// UserInbox is the inbox of the mails the user sends over smtp.
+func UserInbox(username string) string {
+	return UserInboxPrefix + username
+}
+
+type Part struct {
+	Kind         string `json:"kind"`
+	ContentType  string `json:"contenttype"`
+	Charset      string `json:"charset"`
+	Filename     string `json:"filename"`
+	ContentId    string `json:"contentid"`
+	Size         int    `json:"size"`
+	Content      string `json:"content"`
+	Raw          []byte `json:"-" bson:"-"`
+	AttachmentId string `json:"attachmentid"`
+}
+
+type Attachment struct {
+	Id          string `json:"id"`
+	Filename    string `json:"filename"`
+	ContentType string `json:"contenttype"`
+	ContentId   string `json:"contentid"`
+	Inline      bool   `json:"inline"`
+	Size        int    `json:"size"`
+}
+
+type User struct {
+	Id       string    `json:"id" bson:"-"`
+	Salt     string    `json:"salt"`
+	Username string    `json:"username"`
+	Password string    `json:"password"`
+	Role     string    `json:"role"`
+	Emails   []string  `json:"emails"`
+	Webhooks []Webhook `json:"webhooks"`
+	// Subject links the user to their account at the single sign-on
+	// provider, users created by a sign-on have no password.
+	Subject   string    `json:"subject"`
+	CreatedAt time.Time `json:"createdat"`
+}
+
+// RefreshToken is a login session. Only the sha256 of the token is
+// stored, a refresh replaces it with a new one.
+type RefreshToken struct {
+	Id        string    `json:"id" bson:"-"`
+	Hash      string    `json:"hash"`
+	UserId    string    `json:"userid"`
+	ExpiresAt time.Time `json:"expiresat"`
+	CreatedAt time.Time `json:"createdat"`
+}
+
+// PasswordReset lets a user set a new password without the current one,
+// once. Only the sha256 of the token is stored.
+type PasswordReset struct {
+	Id        string    `json:"id" bson:"-"`
+	Hash      string    `json:"hash"`
+	UserId    string    `json:"userid"`
+	ExpiresAt time.Time `json:"expiresat"`
+	CreatedAt time.Time `json:"createdat"`
+}
+
+// scopes of api keys
+const (
+	ApiKeyScopeRead       = "read"
+	ApiKeyScopeReadDelete = "read-delete"
+)
+
+// ApiKey lets scripts act as its user without a login. Only the sha256 of
+// the key is stored, Prefix is kept to tell keys apart. A key with an
+// Inbox only reaches the mails of that project.
+type ApiKey struct {
+	Id         string    `json:"id" bson:"-"`
+	Name       string    `json:"name"`
+	Prefix     string    `json:"prefix"`
+	Hash       string    `json:"hash"`
+	UserId     string    `json:"userid"`
+	ProjectId  string    `json:"projectid"`
+	Inbox      string    `json:"inbox"`
+	Scope      string    `json:"scope"`
+	LastUsedAt time.Time `json:"lastusedat"`
+	CreatedAt  time.Time `json:"createdat"`
+}
+
+// Webhook is a notification target, Kind names the notifier that posts to
+// it.
+type Webhook struct {
+	Kind   string `json:"kind"`
+	URL    string `json:"url"`
+	Secret string `json:"secret,omitempty"`
+}
+
+// QuarantinedMail is a message the smtp server accepted but could not
+// parse, kept byte for byte for inspection.
+type QuarantinedMail struct {
+	Id        string    `json:"id" bson:"-"`
+	Raw       []byte    `json:"raw"`
+	Reason    string    `json:"reason"`
+	From      string    `json:"from"`
+	Rcpts     []string  `json:"rcpts"`
+	Inbox     string    `json:"inbox"`
+	Size      int       `json:"size"`
+	CreatedAt time.Time `json:"createdat"`
+}
+
+// roles of project members
+const (
+	ProjectRoleMaintainer = "maintainer"
+	ProjectRoleViewer     = "viewer"
+)
+
+// Role is a custom role, a named set of permissions given to users next
+// to the built-in admin and watcher roles.
+type Role struct {
+	Id          string    `json:"id" bson:"-"`
+	Name        string    `json:"name"`
+	Permissions []string  `json:"permissions"`
+	CreatedAt   time.Time `json:"createdat"`
+}
+
+// Project owns the mails sent to its Inbox. Viewers read them, maintainers
+// also mark them read and delete them.
+type Project struct {
+	Id        string          `json:"id" bson:"-"`
+	Name      string          `json:"name"`
+	Inbox     string          `json:"inbox"`
+	Members   []ProjectMember `json:"members"`
+	CreatedAt time.Time       `json:"createdat"`
+}
+
+type ProjectMember struct {
+	Username string `json:"username"`
+	Role     string `json:"role"`
+}
+
+// Member returns the member entry of username.
+func (p Project) Member(username string) (ProjectMember, bool) {
+	for _, member := range p.Members {
+		if member.Username == username {
+			return member, true
+		}
+	}
+	return ProjectMember{}, false
+}
+
+// SmtpCredential lets an application send over smtp, the mails it sends
+// are tagged with Inbox. Password is a bcrypt or argon2id hash.
+type SmtpCredential struct {
+	Id        string    `json:"id" bson:"-"`
+	Username  string    `json:"username"`
+	Password  string    `json:"password"`
+	Inbox     string    `json:"inbox"`
+	CreatedAt time.Time `json:"createdat"`
+}
+
+type Ticket struct {
+	Id        string    `json:"id" bson:"-"`
+	Username  string    `json:"username"`
+	Subject   string    `json:"subject"`
+	Message   string    `json:"message"`
+	CreatedAt time.Time `json:"createdat"`
+	IsRead    int       `json:"isread"`
+	Status    string    `json:"status"`
+}
+
+type TicketMessage struct {
+	Id            string    `json:"id" bson:"-"`
+	Username      string    `json:"username"`
+	TicketId      string    `json:"ticketid"`
+	Message       string    `json:"message"`
+	IsReadAdmin   int       `json:"isreadadmin"`
+	IsReadWatcher int       `json:"isreadwatcher"`
+	CreatedAt     time.Time `json:"createdat"`
+}
+
+// MailFilter narrows the mails a query works on, empty fields and nil
+// pointers leave it open. Subject, From, To, Rcpt and Cc match a
+// case-insensitive part of the field, taken literally. Inbox limits it to
+// one inbox when not empty and Inboxes to a set of inboxes when not nil.
+// Owner leaves out the user inboxes of everyone else when not empty.
+type MailFilter struct {
+	Subject string
+	From    string
+	To      string
+	Rcpt    string
+	Cc      string
+	// Text searches the subject and the body. Mongo answers it from its
+	// text index, so there whole words match rather than parts of them.
+	Text string
+	// After and Before bound the creation time when not zero, After is
+	// inclusive and Before is not.
+	After          time.Time
+	Before         time.Time
+	Read           *bool
+	HasAttachments *bool
+	Inbox          string
+	Inboxes        []string
+	Owner          string
+}
+
+// empty tells whether the filter matches every mail.
+func (f MailFilter) empty() bool {
+	return reflect.DeepEqual(f, MailFilter{})
+}
+
+func (f MailFilter) matches(m *Mail) bool {
+	if !ContainsFold(m.Subject, f.Subject) || !ContainsFold(m.From, f.From) || !ContainsFold(m.To, f.To) ||
+		!ContainsFold(m.Rcpt, f.Rcpt) || !ContainsFold(m.Cc, f.Cc) {
+		return false
+	}
+	if f.Text != "" && !ContainsFold(m.Subject, f.Text) && !ContainsFold(m.Text, f.Text) && !ContainsFold(m.Html, f.Text) {
+		return false
+	}
+	if !f.After.IsZero() || !f.Before.IsZero() {
+		if m.CreatedAt.Before(f.After) || (!f.Before.IsZero() && !m.CreatedAt.Before(f.Before)) {
+			return false
+		}
+	}
+	if f.Read != nil && (m.IsRead == 1) != *f.Read {
+		return false
+	}
+	if f.HasAttachments != nil && (len(m.Attachments) > 0) != *f.HasAttachments {
+		return false
+	}
+	if f.Inbox != "" && m.Inbox != f.Inbox {
+		return false
+	}
+	if f.Owner != "" && strings.HasPrefix(m.Inbox, UserInboxPrefix) && m.Inbox != UserInbox(f.Owner) {
+		return false
+	}
+	if f.Inboxes == nil {
+		return true
+	}
+	for _, inbox := range f.Inboxes {
+		if m.Inbox == inbox {
+			return true
+		}
+	}
+	return false
+}
+
+// ContainsFold tells whether substr is in s, ignoring case.
+func ContainsFold(s, substr string) bool {
+	return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
+}
+
+// fields mails can be sorted by
+const (
+	MailSortCreatedAt = "createdat"
+	MailSortSubject   = "subject"
+	MailSortFrom      = "from"
+	MailSortTo        = "to"
+)
+
+// Page selects a part of a listing, Limit documents after skipping Offset,
+// every document when Limit is zero. Sort names the field to order by,
+// descending when Desc is set.
+type Page struct {
+	Limit  int
+	Offset int
+	Sort   string
+	Desc   bool
+}
+
+// MailPage is a page of mails with the counts of every mail matching the
+// filter.
+type MailPage struct {
+	Mails  []Mail
+	Total  int
+	Unread int
+}
+
+// pageMails cuts a page out of mails listed newest first, for the stores
+// that filter in memory.
+func pageMails(mails []Mail, page Page) *MailPage {
+	result := &MailPage{Total: len(mails)}
+	for _, mail := range mails {
+		if mail.IsRead != 1 {
+			result.Unread++
+		}
+	}
+	sort.SliceStable(mails, func(i, j int) bool {
+		if page.Desc {
+			return mailLess(mails[j], mails[i], page.Sort)
+		}
+		return mailLess(mails[i], mails[j], page.Sort)
+	})
+	start, end := page.Offset, len(mails)
+	if start > end {
+		start = end
+	}
+	if page.Limit > 0 && start+page.Limit < end {
+		end = start + page.Limit
+	}
+	result.Mails = mails[start:end]
+	return result
+}
+
+func mailLess(a, b Mail, field string) bool {
+	switch field {
+	case MailSortSubject:
+		return strings.ToLower(a.Subject) < strings.ToLower(b.Subject)
+	case MailSortFrom:
+		return strings.ToLower(a.From) < strings.ToLower(b.From)
+	case MailSortTo:
+		return strings.ToLower(a.To) < strings.ToLower(b.To)
+	}
+	return a.CreatedAt.Before(b.CreatedAt)
+}
+
+type MailStore interface {
+	InsertMail(ctx context.Context, mail *Mail) (string, error)
+	FindMail(ctx context.Context, id string) (*Mail, error)
+	// ListMails returns mails newest first, without their raw data and parts.
+	ListMails(ctx context.Context, filter MailFilter) ([]Mail, error)
+	// PageMails returns a page of the mails ListMails would, counting
+	// every mail matching the filter.
+	PageMails(ctx context.Context, filter MailFilter, page Page) (*MailPage, error)
+	MailAttachments(ctx context.Context, filter MailFilter) ([]Attachment, error)
+	MarkMailRead(ctx context.Context, id string) error
+	MarkAllMailsRead(ctx context.Context, filter MailFilter) error
+	DeleteMail(ctx context.Context, id string) error
+	DeleteAllMails(ctx context.Context, filter MailFilter) error
+}
+
+type UserStore interface {
+	InsertUser(ctx context.Context, user *User) (string, error)
+	FindUser(ctx context.Context, id string) (*User, error)
+	FindUserByUsername(ctx context.Context, username string) (*User, error)
+	FindUserBySalt(ctx context.Context, salt string) (*User, error)
+	FindUserBySubject(ctx context.Context, subject string) (*User, error)
+	ListUsers(ctx context.Context) ([]User, error)
+	// UpdateUser changes the username, emails and role of a user.
+	UpdateUser(ctx context.Context, user *User) error
+	UpdateUserPassword(ctx context.Context, id, password string) error
+	UpdateUserWebhooks(ctx context.Context, id string, webhooks []Webhook) error
+	// UpdateUserSalt changes the token subject of a user, the tokens
+	// issued before stop working.
+	UpdateUserSalt(ctx context.Context, id, salt string) error
+	UpdateUserSubject(ctx context.Context, id, subject string) error
+	DeleteUser(ctx context.Context, id string) error
+}
+
+type TicketStore interface {
+	InsertTicket(ctx context.Context, ticket *Ticket) (string, error)
+	// FindTicket returns the ticket with the given id, owned by username
+	// unless username is empty.
+	FindTicket(ctx context.Context, id, username string) (*Ticket, error)
+	// ListTickets returns tickets newest first, owned by username unless
+	// username is empty.
+	ListTickets(ctx context.Context, username string) ([]Ticket, error)
+	SetTicketStatus(ctx context.Context, id, status string) error
+	MarkTicketRead(ctx context.Context, id string) error
+	DeleteTicket(ctx context.Context, id string) error
+	InsertTicketMessage(ctx context.Context, message *TicketMessage) (string, error)
+	ListTicketMessages(ctx context.Context, ticketId string) ([]TicketMessage, error)
+}
+
+type CredentialStore interface {
+	InsertCredential(ctx context.Context, credential *SmtpCredential) (string, error)
+	FindCredentialByUsername(ctx context.Context, username string) (*SmtpCredential, error)
+	ListCredentials(ctx context.Context) ([]SmtpCredential, error)
+	DeleteCredential(ctx context.Context, id string) error
+}
+
+type ProjectStore interface {
+	InsertProject(ctx context.Context, project *Project) (string, error)
+	FindProject(ctx context.Context, id string) (*Project, error)
+	FindProjectByInbox(ctx context.Context, inbox string) (*Project, error)
+	// ListProjects returns the projects username is a member of, every
+	// project when username is empty.
+	ListProjects(ctx context.Context, username string) ([]Project, error)
+	// UpdateProject changes the name and members of a project.
+	UpdateProject(ctx context.Context, project *Project) error
+	DeleteProject(ctx context.Context, id string) error
+}
+
+type RoleStore interface {
+	InsertRole(ctx context.Context, role *Role) (string, error)
+	FindRole(ctx context.Context, id string) (*Role, error)
+	FindRoleByName(ctx context.Context, name string) (*Role, error)
+	ListRoles(ctx context.Context) ([]Role, error)
+	// UpdateRole changes the permissions of a role.
+	UpdateRole(ctx context.Context, role *Role) error
+	DeleteRole(ctx context.Context, id string) error
+}
+
+type TokenStore interface {
+	InsertRefreshToken(ctx context.Context, token *RefreshToken) (string, error)
+	FindRefreshToken(ctx context.Context, hash string) (*RefreshToken, error)
+	DeleteRefreshToken(ctx context.Context, id string) error
+	// TakeRefreshToken deletes the session of the hash and returns it, in
+	// one step so only one request can use it. ErrNotFound is returned
+	// when there is none, or another request took it first.
+	TakeRefreshToken(ctx context.Context, hash string) (*RefreshToken, error)
+	// DeleteUserRefreshTokens ends every session of a user.
+	DeleteUserRefreshTokens(ctx context.Context, userId string) error
+}
+
+type ResetStore interface {
+	InsertPasswordReset(ctx context.Context, reset *PasswordReset) (string, error)
+	FindPasswordReset(ctx context.Context, hash string) (*PasswordReset, error)
+	// DeleteUserPasswordResets drops every reset token of a user, a used
+	// one ends the others too.
+	DeleteUserPasswordResets(ctx context.Context, userId string) error
+}
+
+type ApiKeyStore interface {
+	InsertApiKey(ctx context.Context, key *ApiKey) (string, error)
+	FindApiKey(ctx context.Context, id string) (*ApiKey, error)
+	FindApiKeyByHash(ctx context.Context, hash string) (*ApiKey, error)
+	// ListApiKeys returns the keys of a user, every key when userId is
+	// empty.
+	ListApiKeys(ctx context.Context, userId string) ([]ApiKey, error)
+	TouchApiKey(ctx context.Context, id string, at time.Time) error
+	DeleteApiKey(ctx context.Context, id string) error
+}
+
+type QuarantineStore interface {
+	InsertQuarantined(ctx context.Context, mail *QuarantinedMail) (string, error)
+	FindQuarantined(ctx context.Context, id string) (*QuarantinedMail, error)
+	// ListQuarantined returns quarantined mails newest first, without
+	// their raw bytes.
+	ListQuarantined(ctx context.Context) ([]QuarantinedMail, error)
+	DeleteQuarantined(ctx context.Context, id string) error
+}
+
+// MailWatcher is implemented by stores that can report mails inserted by
+// other processes.
+type MailWatcher interface {
+	// WatchMails streams newly inserted mails until ctx is done.
+	WatchMails(ctx context.Context) (<-chan Mail, error)
+}
+
+// Migrator is implemented by the stores that keep documents between runs.
+type Migrator interface {
+	// MigrateTimestamps converts the createdat text written by older
+	// versions into times, and reads the Date header of those mails. It
+	// returns how many documents it changed.
+	MigrateTimestamps(ctx context.Context) (int, error)
+}
+
+// legacyTimeLayout is how time.Time.String formatted createdat before it
+// was stored as a time.
+const legacyTimeLayout = "2006-01-02 15:04:05.999999999 -0700 MST"
+
+// messageDate reads the Date header of a raw message, zero when there is
+// none that parses.
+func messageDate(raw string) time.Time {
+	msg, err := mail.ReadMessage(strings.NewReader(raw))
+	if err != nil {
+		return time.Time{}
+	}
+	date, err := msg.Header.Date()
+	if err != nil {
+		return time.Time{}
+	}
+	return date.UTC()
+}
+
+type Store interface {
+	MailStore
+	UserStore
+	TicketStore
+	CredentialStore
+	ProjectStore
+	QuarantineStore
+	TokenStore
+	ResetStore
+	ApiKeyStore
+	RoleStore
+	Close(ctx context.Context) error
+}
+
+type Config struct {
+	Kind     string
+	MongoURI string
+	Database string
+	BoltPath string
+}
+
+// Open returns the store selected by cfg.Kind, Mongo when it is empty.
+func Open(ctx context.Context, cfg Config) (Store, error) {
+	switch strings.ToLower(cfg.Kind) {
+	case "", KindMongo:
+		return Connect(ctx, cfg.MongoURI, cfg.Database)
+	case KindMemory:
+		return NewMemory(), nil
+	case KindBolt:
+		path := cfg.BoltPath
+		if path == "" {
+			path = "mailtracker.db"
+		}
+		return OpenBolt(path)
+	}
+	return nil, fmt.Errorf("unknown store %q", cfg.Kind)
+}
+
+// summary drops the fields ListMails does not return.
+func summary(m Mail) Mail {
+	m.Data = ""
+	m.Body = ""
+	m.Text = ""
+	m.Html = ""
+	m.Parts = nil
+	return m
+}
+
+// PlainText returns the text of the mail, extracting it from the html body
+// when there is no text part.
+func (m Mail) PlainText() string {
+	if strings.TrimSpace(m.Text) != "" {
+		return strings.TrimSpace(m.Text)
+	}
+	source := m.Html
+	if source == "" {
+		source = m.Body
+	}
+
+	var text strings.Builder
+	skip := 0
+	z := html.NewTokenizer(strings.NewReader(source))
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return strings.Join(strings.Fields(text.String()), " ")
+		case html.StartTagToken:
+			if name, _ := z.TagName(); string(name) == "style" || string(name) == "script" {
+				skip++
+			}
+		case html.EndTagToken:
+			if name, _ := z.TagName(); (string(name) == "style" || string(name) == "script") && skip > 0 {
+				skip--
+			}
+		case html.TextToken:
+			if skip == 0 {
+				text.Write(z.Text())
+				text.WriteByte(' ')
+			}
+		}
+	}
+}
@@ -0,0 +1,689 @@
+package store
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// stores returns every implementation under test, Mongo only when
+// TEST_MONGO_URI points at a disposable server.
+func stores(t *testing.T) map[string]Store {
+	t.Helper()
+	bolt, err := OpenBolt(filepath.Join(t.TempDir(), "mailtracker.db"))
+	if err != nil {
+		t.Fatalf("OpenBolt() error = %v", err)
+	}
+	stores := map[string]Store{
+		"memory": NewMemory(),
+		"bolt":   bolt,
+	}
+	if uri := os.Getenv("TEST_MONGO_URI"); uri != "" {
+		mongo, err := Connect(context.Background(), uri, "mailtracker_test")
+		if err != nil {
+			t.Fatalf("Connect() error = %v", err)
+		}
+		mongo.Database().Drop(context.Background())
+		// dropping the database takes the text index with it
+		if err := mongo.createIndexes(context.Background()); err != nil {
+			t.Fatalf("createIndexes() error = %v", err)
+		}
+		stores["mongo"] = mongo
+	}
+	t.Cleanup(func() {
+		for _, s := range stores {
+			s.Close(context.Background())
+		}
+	})
+	return stores
+}
+
+func TestMailStore(t *testing.T) {
+	ctx := context.Background()
+	for name, s := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			first := &Mail{Subject: "Welcome", From: "app@example.com", To: "ayse@example.com", Rcpt: "ayse@example.com", Text: "Confirm your account",
+				Inbox: UserInbox("ayse"), Data: "raw", Parts: []Part{{Kind: "text", Content: "hi"}}, CreatedAt: time.Date(2023, 7, 19, 10, 0, 0, 0, time.UTC)}
+			second := &Mail{Subject: "Invoice", From: "billing@example.com", To: "mehmet@example.com", Cc: "ayse@example.com", Rcpt: "mehmet@example.com",
+				Html: "<p>Total due</p>", Inbox: "billing", Attachments: []Attachment{{Id: "a1", Filename: "invoice.pdf"}}, CreatedAt: time.Date(2023, 7, 20, 10, 0, 0, 5e8, time.UTC)}
+			for _, mail := range []*Mail{first, second} {
+				if _, err := s.InsertMail(ctx, mail); err != nil {
+					t.Fatalf("InsertMail() error = %v", err)
+				}
+			}
+
+			got, err := s.FindMail(ctx, first.Id)
+			if err != nil {
+				t.Fatalf("FindMail() error = %v", err)
+			}
+			if got.Id != first.Id || got.Data != "raw" || len(got.Parts) != 1 {
+				t.Errorf("FindMail() = %+v", got)
+			}
+			if _, err := s.FindMail(ctx, newID()); err != ErrNotFound {
+				t.Errorf("FindMail() unknown id error = %v, want %v", err, ErrNotFound)
+			}
+
+			yes, no := true, false
+			tests := []struct {
+				name   string
+				filter MailFilter
+				want   []string
+			}{
+				{"all newest first", MailFilter{}, []string{second.Id, first.Id}},
+				{"subject", MailFilter{Subject: "welc"}, []string{first.Id}},
+				{"subject taken literally", MailFilter{Subject: "inv.*("}, nil},
+				{"from", MailFilter{From: "BILLING@"}, []string{second.Id}},
+				{"to", MailFilter{To: "ayse"}, []string{first.Id}},
+				{"rcpt", MailFilter{Rcpt: "mehmet"}, []string{second.Id}},
+				{"cc", MailFilter{Cc: "ayse"}, []string{second.Id}},
+				{"text body", MailFilter{Text: "account"}, []string{first.Id}},
+				{"html body", MailFilter{Text: "total"}, []string{second.Id}},
+				{"after", MailFilter{After: time.Date(2023, 7, 20, 10, 0, 0, 0, time.UTC)}, []string{second.Id}},
+				{"before", MailFilter{Before: time.Date(2023, 7, 20, 10, 0, 0, 0, time.UTC)}, []string{first.Id}},
+				{"unread", MailFilter{Read: &no}, []string{second.Id, first.Id}},
+				{"read", MailFilter{Read: &yes}, nil},
+				{"with attachments", MailFilter{HasAttachments: &yes}, []string{second.Id}},
+				{"without attachments", MailFilter{HasAttachments: &no}, []string{first.Id}},
+				{"combined", MailFilter{Cc: "ayse", Inbox: "billing", Inboxes: []string{"shop"}}, nil},
+				{"inbox", MailFilter{Inbox: "billing"}, []string{second.Id}},
+				{"inboxes", MailFilter{Inboxes: []string{"billing", "other"}}, []string{second.Id}},
+				{"empty inboxes", MailFilter{Inboxes: []string{}}, nil},
+				{"own user inbox", MailFilter{Owner: "ayse"}, []string{second.Id, first.Id}},
+				{"user inbox of another", MailFilter{Owner: "mehmet"}, []string{second.Id}},
+			}
+			for _, tt := range tests {
+				mails, err := s.ListMails(ctx, tt.filter)
+				if err != nil {
+					t.Fatalf("ListMails(%s) error = %v", tt.name, err)
+				}
+				var ids []string
+				for _, mail := range mails {
+					ids = append(ids, mail.Id)
+					if mail.Data != "" || mail.Parts != nil {
+						t.Errorf("ListMails(%s) returned full mail %+v", tt.name, mail)
+					}
+				}
+				if len(ids) != len(tt.want) || (len(ids) > 0 && ids[0] != tt.want[0]) {
+					t.Errorf("ListMails(%s) = %v, want %v", tt.name, ids, tt.want)
+				}
+			}
+
+			attachments, err := s.MailAttachments(ctx, MailFilter{})
+			if err != nil || len(attachments) != 1 || attachments[0].Id != "a1" {
+				t.Errorf("MailAttachments() = %v, %v", attachments, err)
+			}
+			if attachments, _ := s.MailAttachments(ctx, MailFilter{Inboxes: []string{}}); len(attachments) != 0 {
+				t.Errorf("MailAttachments() of no inbox = %v", attachments)
+			}
+
+			if err := s.MarkMailRead(ctx, first.Id); err != nil {
+				t.Fatalf("MarkMailRead() error = %v", err)
+			}
+			if got, _ := s.FindMail(ctx, first.Id); got.IsRead != 1 {
+				t.Errorf("MarkMailRead() did not mark the mail")
+			}
+			if err := s.MarkAllMailsRead(ctx, MailFilter{Inbox: "billing"}); err != nil {
+				t.Fatalf("MarkAllMailsRead() error = %v", err)
+			}
+			if got, _ := s.FindMail(ctx, second.Id); got.IsRead != 1 {
+				t.Errorf("MarkAllMailsRead() did not mark the mail")
+			}
+
+			if err := s.DeleteMail(ctx, first.Id); err != nil {
+				t.Fatalf("DeleteMail() error = %v", err)
+			}
+			if _, err := s.FindMail(ctx, first.Id); err != ErrNotFound {
+				t.Errorf("FindMail() after delete error = %v", err)
+			}
+			third := &Mail{Subject: "Kept", Inbox: "other"}
+			s.InsertMail(ctx, third)
+			if err := s.DeleteAllMails(ctx, MailFilter{Inbox: "other"}); err != nil {
+				t.Fatalf("DeleteAllMails() error = %v", err)
+			}
+			if _, err := s.FindMail(ctx, second.Id); err != nil {
+				t.Errorf("DeleteAllMails() of another inbox deleted the mail: %v", err)
+			}
+			if err := s.DeleteAllMails(ctx, MailFilter{}); err != nil {
+				t.Fatalf("DeleteAllMails() error = %v", err)
+			}
+			if mails, _ := s.ListMails(ctx, MailFilter{}); len(mails) != 0 {
+				t.Errorf("ListMails() after DeleteAllMails = %v", mails)
+			}
+		})
+	}
+}
+
+func TestPageMails(t *testing.T) {
+	ctx := context.Background()
+	for name, s := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			var ids []string
+			for i, subject := range []string{"banana", "Apple", "cherry", "date"} {
+				mail := &Mail{Subject: subject, Inbox: "shop", IsRead: i % 2, CreatedAt: time.Date(2023, 7, 19, i, 0, 0, 0, time.UTC)}
+				s.InsertMail(ctx, mail)
+				ids = append(ids, mail.Id)
+			}
+			s.InsertMail(ctx, &Mail{Subject: "elsewhere", Inbox: "blog"})
+
+			tests := []struct {
+				name string
+				page Page
+				want []string
+			}{
+				{"everything newest first", Page{Desc: true}, []string{ids[3], ids[2], ids[1], ids[0]}},
+				{"first page", Page{Limit: 2, Desc: true}, []string{ids[3], ids[2]}},
+				{"second page", Page{Limit: 2, Offset: 2, Desc: true}, []string{ids[1], ids[0]}},
+				{"past the end", Page{Limit: 2, Offset: 6}, nil},
+				{"oldest first", Page{Limit: 1}, []string{ids[0]}},
+				{"by subject", Page{Sort: MailSortSubject, Limit: 2}, []string{ids[1], ids[0]}},
+			}
+			for _, tt := range tests {
+				page, err := s.PageMails(ctx, MailFilter{Inbox: "shop"}, tt.page)
+				if err != nil {
+					t.Fatalf("PageMails(%s) error = %v", tt.name, err)
+				}
+				var got []string
+				for _, mail := range page.Mails {
+					got = append(got, mail.Id)
+					if mail.Data != "" || mail.Parts != nil {
+						t.Errorf("PageMails(%s) returned full mail %+v", tt.name, mail)
+					}
+				}
+				if !reflect.DeepEqual(got, tt.want) || page.Total != 4 || page.Unread != 2 {
+					t.Errorf("PageMails(%s) = %v, total %d, unread %d, want %v, total 4, unread 2", tt.name, got, page.Total, page.Unread, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestUserStore(t *testing.T) {
+	ctx := context.Background()
+	for name, s := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			user := &User{Username: "ayse", Salt: "s1", Password: "hash", Role: "watcher", Emails: []string{"app@example.com"}}
+			if _, err := s.InsertUser(ctx, user); err != nil {
+				t.Fatalf("InsertUser() error = %v", err)
+			}
+
+			for _, find := range []func() (*User, error){
+				func() (*User, error) { return s.FindUser(ctx, user.Id) },
+				func() (*User, error) { return s.FindUserByUsername(ctx, "ayse") },
+				func() (*User, error) { return s.FindUserBySalt(ctx, "s1") },
+			} {
+				got, err := find()
+				if err != nil || got.Id != user.Id || got.Password != "hash" {
+					t.Errorf("find user = %+v, %v", got, err)
+				}
+			}
+			if _, err := s.FindUserByUsername(ctx, "nobody"); err != ErrNotFound {
+				t.Errorf("FindUserByUsername() unknown error = %v", err)
+			}
+
+			err := s.UpdateUser(ctx, &User{Id: user.Id, Username: "ayse.k", Role: "admin", Emails: nil, Password: "ignored"})
+			if err != nil {
+				t.Fatalf("UpdateUser() error = %v", err)
+			}
+			if err := s.UpdateUserPassword(ctx, user.Id, "new-hash"); err != nil {
+				t.Fatalf("UpdateUserPassword() error = %v", err)
+			}
+			webhooks := []Webhook{{Kind: "slack", URL: "https://hooks.slack.com/x"}}
+			if err := s.UpdateUserWebhooks(ctx, user.Id, webhooks); err != nil {
+				t.Fatalf("UpdateUserWebhooks() error = %v", err)
+			}
+			got, _ := s.FindUser(ctx, user.Id)
+			if got.Username != "ayse.k" || got.Role != "admin" || got.Password != "new-hash" || got.Salt != "s1" {
+				t.Errorf("updated user = %+v", got)
+			}
+			if !reflect.DeepEqual(got.Webhooks, webhooks) {
+				t.Errorf("updated webhooks = %+v", got.Webhooks)
+			}
+			if err := s.UpdateUserSalt(ctx, user.Id, "s2"); err != nil {
+				t.Fatalf("UpdateUserSalt() error = %v", err)
+			}
+			if _, err := s.FindUserBySalt(ctx, "s1"); err != ErrNotFound {
+				t.Errorf("FindUserBySalt() with the old salt error = %v", err)
+			}
+			if err := s.UpdateUserSubject(ctx, user.Id, "sso-1"); err != nil {
+				t.Fatalf("UpdateUserSubject() error = %v", err)
+			}
+			if got, err := s.FindUserBySubject(ctx, "sso-1"); err != nil || got.Id != user.Id {
+				t.Errorf("FindUserBySubject() = %+v, %v", got, err)
+			}
+			if _, err := s.FindUserBySubject(ctx, "sso-2"); err != ErrNotFound {
+				t.Errorf("FindUserBySubject() unknown error = %v", err)
+			}
+
+			users, err := s.ListUsers(ctx)
+			if err != nil || len(users) != 1 {
+				t.Errorf("ListUsers() = %v, %v", users, err)
+			}
+			if err := s.DeleteUser(ctx, user.Id); err != nil {
+				t.Fatalf("DeleteUser() error = %v", err)
+			}
+			if _, err := s.FindUser(ctx, user.Id); err != ErrNotFound {
+				t.Errorf("FindUser() after delete error = %v", err)
+			}
+		})
+	}
+}
+
+func TestTicketStore(t *testing.T) {
+	ctx := context.Background()
+	for name, s := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			mine := &Ticket{Username: "ayse", Subject: "Help", Status: "open"}
+			other := &Ticket{Username: "mehmet", Subject: "Other", Status: "open"}
+			for _, ticket := range []*Ticket{mine, other} {
+				if _, err := s.InsertTicket(ctx, ticket); err != nil {
+					t.Fatalf("InsertTicket() error = %v", err)
+				}
+			}
+
+			if _, err := s.FindTicket(ctx, other.Id, "ayse"); err != ErrNotFound {
+				t.Errorf("FindTicket() of another user error = %v", err)
+			}
+			if got, err := s.FindTicket(ctx, other.Id, ""); err != nil || got.Subject != "Other" {
+				t.Errorf("FindTicket() = %+v, %v", got, err)
+			}
+
+			if tickets, _ := s.ListTickets(ctx, "ayse"); len(tickets) != 1 || tickets[0].Id != mine.Id {
+				t.Errorf("ListTickets(ayse) = %v", tickets)
+			}
+			if tickets, _ := s.ListTickets(ctx, ""); len(tickets) != 2 || tickets[0].Id != other.Id {
+				t.Errorf("ListTickets() = %v", tickets)
+			}
+
+			if err := s.SetTicketStatus(ctx, mine.Id, "closed"); err != nil {
+				t.Fatalf("SetTicketStatus() error = %v", err)
+			}
+			if err := s.MarkTicketRead(ctx, mine.Id); err != nil {
+				t.Fatalf("MarkTicketRead() error = %v", err)
+			}
+			if got, _ := s.FindTicket(ctx, mine.Id, ""); got.Status != "closed" || got.IsRead != 1 {
+				t.Errorf("updated ticket = %+v", got)
+			}
+
+			for _, text := range []string{"first", "second"} {
+				_, err := s.InsertTicketMessage(ctx, &TicketMessage{TicketId: mine.Id, Username: "ayse", Message: text})
+				if err != nil {
+					t.Fatalf("InsertTicketMessage() error = %v", err)
+				}
+			}
+			messages, err := s.ListTicketMessages(ctx, mine.Id)
+			if err != nil || len(messages) != 2 || messages[0].Message != "first" {
+				t.Errorf("ListTicketMessages() = %v, %v", messages, err)
+			}
+
+			if err := s.DeleteTicket(ctx, mine.Id); err != nil {
+				t.Fatalf("DeleteTicket() error = %v", err)
+			}
+			if _, err := s.FindTicket(ctx, mine.Id, ""); err != ErrNotFound {
+				t.Errorf("FindTicket() after delete error = %v", err)
+			}
+		})
+	}
+}
+
+func TestCredentialStore(t *testing.T) {
+	ctx := context.Background()
+	for name, s := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			credential := &SmtpCredential{Username: "billing-app", Password: "hash", Inbox: "billing"}
+			if _, err := s.InsertCredential(ctx, credential); err != nil {
+				t.Fatalf("InsertCredential() error = %v", err)
+			}
+
+			got, err := s.FindCredentialByUsername(ctx, "billing-app")
+			if err != nil || got.Id != credential.Id || got.Password != "hash" || got.Inbox != "billing" {
+				t.Errorf("FindCredentialByUsername() = %+v, %v", got, err)
+			}
+			if _, err := s.FindCredentialByUsername(ctx, "nobody"); err != ErrNotFound {
+				t.Errorf("FindCredentialByUsername() unknown error = %v", err)
+			}
+			if credentials, err := s.ListCredentials(ctx); err != nil || len(credentials) != 1 {
+				t.Errorf("ListCredentials() = %v, %v", credentials, err)
+			}
+
+			if err := s.DeleteCredential(ctx, credential.Id); err != nil {
+				t.Fatalf("DeleteCredential() error = %v", err)
+			}
+			if credentials, _ := s.ListCredentials(ctx); len(credentials) != 0 {
+				t.Errorf("ListCredentials() after delete = %v", credentials)
+			}
+		})
+	}
+}
+
+func TestProjectStore(t *testing.T) {
+	ctx := context.Background()
+	for name, s := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			billing := &Project{Name: "Billing", Inbox: "billing", Members: []ProjectMember{{Username: "ayse", Role: ProjectRoleMaintainer}}}
+			shop := &Project{Name: "Shop", Inbox: "shop"}
+			for _, project := range []*Project{billing, shop} {
+				if _, err := s.InsertProject(ctx, project); err != nil {
+					t.Fatalf("InsertProject() error = %v", err)
+				}
+			}
+
+			if got, err := s.FindProject(ctx, billing.Id); err != nil || got.Inbox != "billing" || len(got.Members) != 1 {
+				t.Errorf("FindProject() = %+v, %v", got, err)
+			}
+			if got, err := s.FindProjectByInbox(ctx, "shop"); err != nil || got.Id != shop.Id {
+				t.Errorf("FindProjectByInbox() = %+v, %v", got, err)
+			}
+			if _, err := s.FindProjectByInbox(ctx, "none"); err != ErrNotFound {
+				t.Errorf("FindProjectByInbox() unknown error = %v", err)
+			}
+			if projects, _ := s.ListProjects(ctx, "ayse"); len(projects) != 1 || projects[0].Id != billing.Id {
+				t.Errorf("ListProjects(ayse) = %v", projects)
+			}
+			if projects, _ := s.ListProjects(ctx, ""); len(projects) != 2 {
+				t.Errorf("ListProjects() = %v", projects)
+			}
+
+			shop.Name = "Store"
+			shop.Members = []ProjectMember{{Username: "ayse", Role: ProjectRoleViewer}}
+			if err := s.UpdateProject(ctx, shop); err != nil {
+				t.Fatalf("UpdateProject() error = %v", err)
+			}
+			if got, _ := s.FindProject(ctx, shop.Id); got.Name != "Store" || got.Inbox != "shop" {
+				t.Errorf("updated project = %+v", got)
+			}
+			if projects, _ := s.ListProjects(ctx, "ayse"); len(projects) != 2 {
+				t.Errorf("ListProjects(ayse) after update = %v", projects)
+			}
+
+			if err := s.DeleteProject(ctx, shop.Id); err != nil {
+				t.Fatalf("DeleteProject() error = %v", err)
+			}
+			if _, err := s.FindProject(ctx, shop.Id); err != ErrNotFound {
+				t.Errorf("FindProject() after delete error = %v", err)
+			}
+		})
+	}
+}
+
+func TestQuarantineStore(t *testing.T) {
+	ctx := context.Background()
+	for name, s := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			// raw bytes that are not valid utf-8 have to survive
+			raw := []byte("Subject: \xff\xfe\r\n\r\nbody")
+			first := &QuarantinedMail{Raw: raw, Reason: "malformed header", Rcpts: []string{"to@example.com"}}
+			second := &QuarantinedMail{Raw: []byte("x"), Reason: "bad part"}
+			for _, mail := range []*QuarantinedMail{first, second} {
+				if _, err := s.InsertQuarantined(ctx, mail); err != nil {
+					t.Fatalf("InsertQuarantined() error = %v", err)
+				}
+			}
+
+			got, err := s.FindQuarantined(ctx, first.Id)
+			if err != nil || !reflect.DeepEqual(got.Raw, raw) || got.Reason != "malformed header" {
+				t.Errorf("FindQuarantined() = %+v, %v", got, err)
+			}
+			mails, err := s.ListQuarantined(ctx)
+			if err != nil || len(mails) != 2 || mails[0].Id != second.Id || mails[0].Raw != nil {
+				t.Errorf("ListQuarantined() = %+v, %v", mails, err)
+			}
+
+			if err := s.DeleteQuarantined(ctx, first.Id); err != nil {
+				t.Fatalf("DeleteQuarantined() error = %v", err)
+			}
+			if _, err := s.FindQuarantined(ctx, first.Id); err != ErrNotFound {
+				t.Errorf("FindQuarantined() after delete error = %v", err)
+			}
+		})
+	}
+}
+
+func TestTokenStore(t *testing.T) {
+	ctx := context.Background()
+	for name, s := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			expires := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+			tokens := []*RefreshToken{
+				{Hash: "h1", UserId: "u1", ExpiresAt: expires},
+				{Hash: "h2", UserId: "u1", ExpiresAt: expires},
+				{Hash: "h3", UserId: "u2", ExpiresAt: expires},
+			}
+			for _, token := range tokens {
+				if _, err := s.InsertRefreshToken(ctx, token); err != nil {
+					t.Fatalf("InsertRefreshToken() error = %v", err)
+				}
+			}
+
+			got, err := s.FindRefreshToken(ctx, "h2")
+			if err != nil || got.Id != tokens[1].Id || got.UserId != "u1" || !got.ExpiresAt.Equal(expires) {
+				t.Errorf("FindRefreshToken() = %+v, %v", got, err)
+			}
+
+			if err := s.DeleteRefreshToken(ctx, tokens[0].Id); err != nil {
+				t.Fatalf("DeleteRefreshToken() error = %v", err)
+			}
+			if _, err := s.FindRefreshToken(ctx, "h1"); err != ErrNotFound {
+				t.Errorf("FindRefreshToken() after delete error = %v", err)
+			}
+
+			if err := s.DeleteUserRefreshTokens(ctx, "u1"); err != nil {
+				t.Fatalf("DeleteUserRefreshTokens() error = %v", err)
+			}
+			if _, err := s.FindRefreshToken(ctx, "h2"); err != ErrNotFound {
+				t.Errorf("FindRefreshToken() of a revoked user error = %v", err)
+			}
+			if _, err := s.FindRefreshToken(ctx, "h3"); err != nil {
+				t.Errorf("FindRefreshToken() of another user error = %v", err)
+			}
+
+			// only one of the requests using a token at once takes it
+			taken := make(chan string, 4)
+			var wg sync.WaitGroup
+			for i := 0; i < 4; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					if got, err := s.TakeRefreshToken(ctx, "h3"); err == nil {
+						taken <- got.Id
+					} else if err != ErrNotFound {
+						t.Errorf("TakeRefreshToken() error = %v", err)
+					}
+				}()
+			}
+			wg.Wait()
+			close(taken)
+			var ids []string
+			for id := range taken {
+				ids = append(ids, id)
+			}
+			if len(ids) != 1 || ids[0] != tokens[2].Id {
+				t.Errorf("TakeRefreshToken() took %v, want %s once", ids, tokens[2].Id)
+			}
+			if _, err := s.FindRefreshToken(ctx, "h3"); err != ErrNotFound {
+				t.Errorf("FindRefreshToken() after take error = %v", err)
+			}
+		})
+	}
+}
+
+func TestResetStore(t *testing.T) {
+	ctx := context.Background()
+	for name, s := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			expires := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+			resets := []*PasswordReset{
+				{Hash: "h1", UserId: "u1", ExpiresAt: expires},
+				{Hash: "h2", UserId: "u1", ExpiresAt: expires},
+				{Hash: "h3", UserId: "u2", ExpiresAt: expires},
+			}
+			for _, reset := range resets {
+				if _, err := s.InsertPasswordReset(ctx, reset); err != nil {
+					t.Fatalf("InsertPasswordReset() error = %v", err)
+				}
+			}
+
+			got, err := s.FindPasswordReset(ctx, "h2")
+			if err != nil || got.Id != resets[1].Id || got.UserId != "u1" || !got.ExpiresAt.Equal(expires) {
+				t.Errorf("FindPasswordReset() = %+v, %v", got, err)
+			}
+			if _, err := s.FindPasswordReset(ctx, "unknown"); err != ErrNotFound {
+				t.Errorf("FindPasswordReset() unknown hash error = %v", err)
+			}
+
+			if err := s.DeleteUserPasswordResets(ctx, "u1"); err != nil {
+				t.Fatalf("DeleteUserPasswordResets() error = %v", err)
+			}
+			if _, err := s.FindPasswordReset(ctx, "h1"); err != ErrNotFound {
+				t.Errorf("FindPasswordReset() after delete error = %v", err)
+			}
+			if _, err := s.FindPasswordReset(ctx, "h3"); err != nil {
+				t.Errorf("FindPasswordReset() of another user error = %v", err)
+			}
+		})
+	}
+}
+
+func TestRoleStore(t *testing.T) {
+	ctx := context.Background()
+	for name, s := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			support := &Role{Name: "support", Permissions: []string{"mails:read", "tickets:manage"}}
+			auditor := &Role{Name: "auditor", Permissions: []string{"mails:read"}}
+			for _, role := range []*Role{support, auditor} {
+				if _, err := s.InsertRole(ctx, role); err != nil {
+					t.Fatalf("InsertRole() error = %v", err)
+				}
+			}
+
+			got, err := s.FindRoleByName(ctx, "support")
+			if err != nil || got.Id != support.Id || !reflect.DeepEqual(got.Permissions, support.Permissions) {
+				t.Errorf("FindRoleByName() = %+v, %v", got, err)
+			}
+			if _, err := s.FindRoleByName(ctx, "unknown"); err != ErrNotFound {
+				t.Errorf("FindRoleByName() unknown name error = %v", err)
+			}
+
+			support.Permissions = []string{"tickets:manage"}
+			support.Name = "renamed"
+			if err := s.UpdateRole(ctx, support); err != nil {
+				t.Fatalf("UpdateRole() error = %v", err)
+			}
+			got, err = s.FindRole(ctx, support.Id)
+			if err != nil || got.Name != "support" || !reflect.DeepEqual(got.Permissions, []string{"tickets:manage"}) {
+				t.Errorf("FindRole() after update = %+v, %v", got, err)
+			}
+
+			if err := s.DeleteRole(ctx, auditor.Id); err != nil {
+				t.Fatalf("DeleteRole() error = %v", err)
+			}
+			roles, err := s.ListRoles(ctx)
+			if err != nil || len(roles) != 1 || roles[0].Id != support.Id {
+				t.Errorf("ListRoles() = %+v, %v", roles, err)
+			}
+		})
+	}
+}
+
+func TestApiKeyStore(t *testing.T) {
+	ctx := context.Background()
+	for name, s := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			keys := []*ApiKey{
+				{Name: "ci", Hash: "h1", UserId: "u1", Scope: ApiKeyScopeRead},
+				{Name: "cleanup", Hash: "h2", UserId: "u1", Inbox: "shop", Scope: ApiKeyScopeReadDelete},
+				{Name: "other", Hash: "h3", UserId: "u2", Scope: ApiKeyScopeRead},
+			}
+			for _, key := range keys {
+				if _, err := s.InsertApiKey(ctx, key); err != nil {
+					t.Fatalf("InsertApiKey() error = %v", err)
+				}
+			}
+
+			got, err := s.FindApiKeyByHash(ctx, "h2")
+			if err != nil || got.Id != keys[1].Id || got.Inbox != "shop" || !got.LastUsedAt.IsZero() {
+				t.Errorf("FindApiKeyByHash() = %+v, %v", got, err)
+			}
+			if _, err := s.FindApiKeyByHash(ctx, "missing"); err != ErrNotFound {
+				t.Errorf("FindApiKeyByHash() missing error = %v", err)
+			}
+
+			used := time.Now().UTC().Truncate(time.Second)
+			if err := s.TouchApiKey(ctx, keys[0].Id, used); err != nil {
+				t.Fatalf("TouchApiKey() error = %v", err)
+			}
+			got, err = s.FindApiKey(ctx, keys[0].Id)
+			if err != nil || !got.LastUsedAt.Equal(used) {
+				t.Errorf("FindApiKey() = %+v, %v", got, err)
+			}
+
+			if own, err := s.ListApiKeys(ctx, "u1"); err != nil || len(own) != 2 {
+				t.Errorf("ListApiKeys(u1) = %+v, %v", own, err)
+			}
+			if all, err := s.ListApiKeys(ctx, ""); err != nil || len(all) != 3 {
+				t.Errorf("ListApiKeys() = %+v, %v", all, err)
+			}
+
+			if err := s.DeleteApiKey(ctx, keys[0].Id); err != nil {
+				t.Fatalf("DeleteApiKey() error = %v", err)
+			}
+			if _, err := s.FindApiKeyByHash(ctx, "h1"); err != ErrNotFound {
+				t.Errorf("FindApiKeyByHash() after delete error = %v", err)
+			}
+		})
+	}
+}
+
+func TestBolt_MigrateTimestamps(t *testing.T) {
+	ctx := context.Background()
+	b, err := OpenBolt(filepath.Join(t.TempDir(), "mailtracker.db"))
+	if err != nil {
+		t.Fatalf("OpenBolt() error = %v", err)
+	}
+	defer b.Close(ctx)
+
+	// documents as older versions wrote them
+	legacy := map[string][]byte{
+		string(mailsBucket): []byte(`{"subject":"Welcome","data":"Date: Wed, 19 Jul 2023 13:00:00 +0300\r\nSubject: Welcome\r\n\r\nhi","createdat":"2023-07-19 10:00:05.25 +0000 UTC"}`),
+		string(usersBucket): []byte(`{"username":"ayse","createdat":""}`),
+	}
+	for bucket, doc := range legacy {
+		if err := b.db.Update(func(tx *bolt.Tx) error {
+			return tx.Bucket([]byte(bucket)).Put([]byte("legacy"), doc)
+		}); err != nil {
+			t.Fatalf("Put() error = %v", err)
+		}
+	}
+	current := &Mail{Subject: "Current", CreatedAt: time.Now().UTC()}
+	b.InsertMail(ctx, current)
+
+	changed, err := b.MigrateTimestamps(ctx)
+	if err != nil || changed != 2 {
+		t.Fatalf("MigrateTimestamps() = %d, %v, want 2", changed, err)
+	}
+	if changed, _ := b.MigrateTimestamps(ctx); changed != 0 {
+		t.Errorf("MigrateTimestamps() again changed %d documents", changed)
+	}
+
+	mail, err := b.FindMail(ctx, "legacy")
+	if err != nil {
+		t.Fatalf("FindMail() error = %v", err)
+	}
+	if want := time.Date(2023, 7, 19, 10, 0, 5, 25e7, time.UTC); !mail.CreatedAt.Equal(want) {
+		t.Errorf("migrated CreatedAt = %v, want %v", mail.CreatedAt, want)
+	}
+	if want := time.Date(2023, 7, 19, 10, 0, 0, 0, time.UTC); !mail.Date.Equal(want) {
+		t.Errorf("migrated Date = %v, want %v", mail.Date, want)
+	}
+	if user, err := b.FindUser(ctx, "legacy"); err != nil || !user.CreatedAt.IsZero() {
+		t.Errorf("FindUser() = %+v, %v", user, err)
+	}
+	if got, _ := b.FindMail(ctx, current.Id); !got.CreatedAt.Equal(current.CreatedAt) {
+		t.Errorf("current mail CreatedAt = %v, want %v", got.CreatedAt, current.CreatedAt)
+	}
+}
@@ -1,12 +0,0 @@
-package tzinit
-
-import (
-	"os"
-)
-
-func init() {
-	err := os.Setenv("TZ", "Europe/Istanbul")
-	if err != nil {
-		return
-	}
-}